@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -95,12 +96,160 @@ func AcceptSavedRoute(userID string, routeUUID string) error {
 		return fmt.Errorf("failed to accept saved route: %w", err)
 	}
 
+	defer res.Body.Close()
+
 	if res.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(res.Body)
 		return fmt.Errorf("failed to accept saved route: status %d, body: %s", res.StatusCode, string(body))
 	}
 
+	var result common.RouteResult
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(result.Alternatives) > 0 {
+		fmt.Printf("⚠️ %s\n", result.Reason)
+		fmt.Printf("Routes from %s ➜ %s today:\n", result.From, result.To)
+		printAlternatives(result.Alternatives, result.SavedRank)
+		return ConfirmRoute(userID, chooseAlternative(bufio.NewReader(os.Stdin), len(result.Alternatives)))
+	}
+
 	fmt.Println("Route accepted and activated successfully.")
+	if result.Reason != "" {
+		fmt.Println(result.Reason)
+	}
+	return nil
+}
+
+func WatchSavedRoute(userID, routeID string) error {
+	watch := common.CommuteWatch{UserID: userID, RouteID: routeID}
+
+	days := readInput("Which days? (e.g. MON,TUE,WED, leave empty for weekdays):")
+	if days == "" {
+		watch.Weekdays = []string{"MON", "TUE", "WED", "THU", "FRI"}
+	} else {
+		for _, day := range strings.Split(days, ",") {
+			watch.Weekdays = append(watch.Weekdays, strings.TrimSpace(day))
+		}
+	}
+	watch.Start = readInput("Watch from (HH:MM):")
+	watch.End = readInput("Watch until (HH:MM):")
+
+	reqBody, err := json.Marshal(watch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal commute watch: %w", err)
+	}
+
+	url := fmt.Sprintf("http://%suser/commute-watches", baseURL)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to contact API Gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("could not watch route: %s", string(body))
+	}
+
+	var saved common.CommuteWatch
+	if err = json.NewDecoder(resp.Body).Decode(&saved); err != nil {
+		return fmt.Errorf("failed to decode response: %s", err)
+	}
+	fmt.Printf("⏰ Watching your route on %s, %s–%s. You'll be alerted about delays before you set off.\n",
+		strings.Join(saved.Weekdays, ", "), saved.Start, saved.End)
+	return nil
+}
+
+// ViewCommuteWatches lists the user's commute watches and returns their IDs, in order.
+func ViewCommuteWatches(userID string) ([]string, error) {
+	url := fmt.Sprintf("http://%suser/commute-watches?userID=%s", baseURL, userID)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch commute watches: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error from server: %s", body)
+	}
+
+	var watches []common.CommuteWatch
+	if err = json.NewDecoder(resp.Body).Decode(&watches); err != nil {
+		return nil, fmt.Errorf("failed to decode commute watches: %s", err)
+	}
+	if len(watches) == 0 {
+		return nil, fmt.Errorf("no commute watches found")
+	}
+
+	ids := make([]string, len(watches))
+	fmt.Println("Commute watches:")
+	for i, watch := range watches {
+		fmt.Printf("%d) %s -> %s on %s, %s–%s\n", i+1, watch.StartPoint, watch.EndPoint,
+			strings.Join(watch.Weekdays, ", "), watch.Start, watch.End)
+		ids[i] = watch.WatchID
+	}
+	return ids, nil
+}
+
+func RemoveCommuteWatch(userID, watchID string) error {
+	url := fmt.Sprintf("http://%suser/commute-watches?userID=%s&watchID=%s", baseURL, userID, watchID)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to contact API Gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("removal failed: %s", string(body))
+	}
+
+	fmt.Println("🗑 Commute watch removed.")
+	return nil
+}
+
+func ViewTravelStats(userID string) error {
+	weeks := readInput("Over how many weeks? (leave empty for all time): ")
+	url := fmt.Sprintf("http://%suser/stats?userID=%s&weeks=%s", baseURL, userID, weeks)
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch travel stats: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error from server: %s", body)
+	}
+
+	var stats common.TravelStats
+	if err = json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return fmt.Errorf("failed to decode travel stats: %s", err)
+	}
+	if stats.Journeys == 0 {
+		fmt.Println("No journeys recorded yet.")
+		return nil
+	}
+
+	fmt.Printf("\n📊 Since %s:\n", stats.Since.Local().Format("02 Jan 2006"))
+	fmt.Printf("Journeys: %d (%d completed, %d aborted, %d recalculated)\n",
+		stats.Journeys, stats.Completed, stats.Aborted, stats.Recalculated)
+	fmt.Printf("Trips per week: %.1f\n", stats.TripsPerWeek)
+	fmt.Printf("Average delay: %.0f min\n", stats.AvgDelayMins)
+	fmt.Printf("Time lost to disruptions: %.0f min\n", stats.TimeLostMins)
+	if len(stats.TopLines) > 0 {
+		fmt.Println("Most used lines:")
+		for _, usage := range stats.TopLines {
+			fmt.Printf("  %s: %d trips\n", usage.Line, usage.Trips)
+		}
+	}
 	return nil
 }
 
@@ -136,7 +285,7 @@ func RecalculateRoute(userID string) error {
 		return fmt.Errorf("failed to encode request: %s", err)
 	}
 
-	url := fmt.Sprintf("http://%sroute/recalculate-route", baseURL)
+	url := fmt.Sprintf("http://%sroute/recalculate", baseURL)
 	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("HTTP request failed: %s", err)
@@ -153,14 +302,21 @@ func RecalculateRoute(userID string) error {
 		return fmt.Errorf("failed to parse response: %s", err)
 	}
 
+	if result.Kept {
+		fmt.Println("👍 Stay on your route.")
+		fmt.Println("   " + result.Reason)
+		return nil
+	}
+
 	fmt.Println("🚀 New Route Recalculated:")
 	fmt.Printf("🧭 From: %s\n", result.From)
 	fmt.Printf("🎯 To:   %s\n", result.To)
-	fmt.Printf("📈 Score: %d\n", result.Score)
+	fmt.Printf("📈 Score: %.2f\n", result.Score)
 	fmt.Println("📝 Summary:")
 	fmt.Println("------------------------------------")
 	fmt.Println("   " + result.Summary)
 	fmt.Println("------------------------------------")
+	fmt.Println("💡 " + result.Reason)
 	return nil
 }
 
@@ -215,13 +371,183 @@ func StartNewRoute(userID string) error {
 	endPoint, _ := reader.ReadString('\n')
 	endPoint = strings.TrimSpace(endPoint)
 
+	waypoints := readWaypoints(reader)
+
 	departure := time.Now()
+	var arriveBy time.Time
+	for {
+		fmt.Print("Arrive by (HH:MM, leave empty to depart now): ")
+		input, _ := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if input == "" {
+			break
+		}
+		t, err := time.ParseInLocation("15:04", input, time.Local)
+		if err != nil {
+			fmt.Println("❌ Invalid time, use HH:MM")
+			continue
+		}
+		arriveBy = time.Date(departure.Year(), departure.Month(), departure.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if arriveBy.Before(departure) {
+			arriveBy = arriveBy.AddDate(0, 0, 1)
+		}
+		break
+	}
+
+	var result common.RouteResult
+	for {
+		req := common.UserRequest{
+			UserID:     userID,
+			StartPoint: startPoint,
+			EndPoint:   endPoint,
+			Departure:  departure,
+			ArriveBy:   arriveBy,
+			Waypoints:  waypoints,
+		}
+
+		var err error
+		result, err = requestRoute(req)
+		if err != nil {
+			return err
+		}
+
+		if len(result.StartCandidates) == 0 && len(result.EndCandidates) == 0 {
+			break
+		}
+		if len(result.StartCandidates) > 0 {
+			startPoint = chooseStation(reader, startPoint, result.StartCandidates)
+		}
+		if len(result.EndCandidates) > 0 {
+			endPoint = chooseStation(reader, endPoint, result.EndCandidates)
+		}
+	}
+
+	for _, rej := range result.Rejected {
+		fmt.Printf("🚫 Skipped ~%d min via %s: %s\n", rej.Duration, strings.Join(rej.Lines, ", "), strings.Join(rej.Reasons, "; "))
+	}
+
+	if len(result.Alternatives) == 0 {
+		return fmt.Errorf("no alternatives returned for %s ➜ %s", result.From, result.To)
+	}
+
+	fmt.Printf("Routes from %s ➜ %s:\n", result.From, result.To)
+	printAlternatives(result.Alternatives, 0)
+
+	return ConfirmRoute(userID, chooseAlternative(reader, len(result.Alternatives)))
+}
+
+// printAlternatives lists the routes offered, marking the saved route if it is one of them.
+func printAlternatives(alternatives []common.RouteAlternative, savedRank int) {
+	for _, alt := range alternatives {
+		fmt.Printf("\n%d) ~%d min, %d change(s), score %.2f\n", alt.Rank, alt.Duration, alt.Changes, alt.Score)
+		if alt.Rank == savedRank {
+			fmt.Println("   ⭐ Your saved route")
+		}
+		if alt.Capped {
+			fmt.Printf("   Fare: £%.2f (daily cap reached)\n", alt.Fare)
+		} else {
+			fmt.Printf("   Fare: £%.2f\n", alt.Fare)
+		}
+		if len(alt.Lines) > 0 {
+			fmt.Printf("   Lines: %s\n", strings.Join(alt.Lines, ", "))
+		}
+		var reasons []string
+		for _, c := range alt.Breakdown {
+			if c.Contribution > 0 {
+				reasons = append(reasons, fmt.Sprintf("%s %.1f", c.Criterion, c.Contribution))
+			}
+		}
+		if len(reasons) > 0 {
+			fmt.Printf("   Score breakdown: %s\n", strings.Join(reasons, ", "))
+		}
+		for i, leg := range alt.Legs {
+			if leg.Status != "" && leg.Status != "Good Service" {
+				fmt.Printf("   - [%s] %s ⚠️ %s\n", leg.Mode, leg.Description, leg.Status)
+			} else {
+				fmt.Printf("   - [%s] %s\n", leg.Mode, leg.Description)
+			}
+			lastOfSegment := i == len(alt.Legs)-1 || alt.Legs[i+1].Segment != leg.Segment
+			if lastOfSegment && leg.Segment < len(alt.Waypoints) {
+				stop := alt.Waypoints[leg.Segment]
+				fmt.Printf("   📍 Stop at %s for %d min\n", stop.Name, stop.DwellMins)
+			}
+		}
+	}
+}
+
+// chooseAlternative asks which of the routes offered to take and returns its rank.
+func chooseAlternative(reader *bufio.Reader, count int) int {
+	var rank int
+	for {
+		fmt.Print("\nEnter the number of the route to take: ")
+		choice, _ := reader.ReadString('\n')
+		if _, err := fmt.Sscanf(strings.TrimSpace(choice), "%d", &rank); err == nil && rank >= 1 && rank <= count {
+			return rank
+		}
+		fmt.Println("❌ Invalid choice")
+	}
+}
 
+// readWaypoints asks for the stops to make on the way, with the time to spend at each.
+func readWaypoints(reader *bufio.Reader) []common.Waypoint {
+	var waypoints []common.Waypoint
+	for {
+		fmt.Print("Add a stop on the way (leave empty when done): ")
+		station, _ := reader.ReadString('\n')
+		station = strings.TrimSpace(station)
+		if station == "" {
+			break
+		}
+		waypoint := common.Waypoint{Station: station}
+		for {
+			fmt.Print("Minutes to spend there (leave empty for none): ")
+			input, _ := reader.ReadString('\n')
+			input = strings.TrimSpace(input)
+			if input == "" {
+				break
+			}
+			mins, err := strconv.Atoi(input)
+			if err == nil && mins >= 0 {
+				waypoint.DwellMins = mins
+				break
+			}
+			fmt.Println("❌ Please enter a whole number of minutes.")
+		}
+		waypoints = append(waypoints, waypoint)
+	}
+	return waypoints
+}
+
+func ScheduleJourney(userID string) error {
+	reader := bufio.NewReader(os.Stdin)
+
+	fmt.Print("Enter start location: ")
+	startPoint, _ := reader.ReadString('\n')
+	fmt.Print("Enter destination: ")
+	endPoint, _ := reader.ReadString('\n')
 	req := common.UserRequest{
 		UserID:     userID,
-		StartPoint: startPoint,
-		EndPoint:   endPoint,
-		Departure:  departure,
+		StartPoint: strings.TrimSpace(startPoint),
+		EndPoint:   strings.TrimSpace(endPoint),
+		Waypoints:  readWaypoints(reader),
+	}
+
+	var when time.Time
+	for {
+		fmt.Print("When? (YYYY-MM-DD HH:MM): ")
+		input, _ := reader.ReadString('\n')
+		t, err := time.ParseInLocation("2006-01-02 15:04", strings.TrimSpace(input), time.Local)
+		if err == nil && t.After(time.Now()) {
+			when = t
+			break
+		}
+		fmt.Println("❌ Enter a future date and time as YYYY-MM-DD HH:MM")
+	}
+	fmt.Print("Is that when you (d)epart or (a)rrive? ")
+	if kind, _ := reader.ReadString('\n'); strings.TrimSpace(kind) == "a" {
+		req.ArriveBy = when
+	} else {
+		req.Departure = when
 	}
 
 	reqBody, err := json.Marshal(req)
@@ -229,7 +555,7 @@ func StartNewRoute(userID string) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	url := fmt.Sprintf("http://%sroute/request", baseURL)
+	url := fmt.Sprintf("http://%sroute/schedule", baseURL)
 	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
 		return fmt.Errorf("failed to contact API Gateway: %w", err)
@@ -238,16 +564,292 @@ func StartNewRoute(userID string) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("route request failed: %s", string(body))
+		return fmt.Errorf("scheduling failed: %s", string(body))
+	}
+
+	var journey common.ScheduledJourney
+	if err = json.NewDecoder(resp.Body).Decode(&journey); err != nil {
+		return fmt.Errorf("failed to decode response: %s", err)
+	}
+
+	fmt.Printf("🗓 Journey from %s ➜ %s booked: leave at %s, arrive at %s.\n", journey.From, journey.To,
+		journey.LeaveAt.Local().Format("Mon 02 Jan 15:04"), journey.ArriveAt.Local().Format("15:04"))
+	fmt.Println("We'll re-plan it with live conditions before you leave and tell you if you should leave earlier or later.")
+	return nil
+}
+
+// ViewScheduledJourneys lists the user's upcoming journeys and returns their IDs, in order.
+func ViewScheduledJourneys(userID string) ([]string, error) {
+	url := fmt.Sprintf("http://%sroute/schedule?userID=%s", baseURL, userID)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch scheduled journeys: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error from server: %s", body)
+	}
+
+	var journeys []common.ScheduledJourney
+	if err = json.NewDecoder(resp.Body).Decode(&journeys); err != nil {
+		return nil, fmt.Errorf("failed to decode scheduled journeys: %s", err)
+	}
+	if len(journeys) == 0 {
+		return nil, fmt.Errorf("no scheduled journeys found")
+	}
+
+	ids := make([]string, len(journeys))
+	fmt.Println("Scheduled journeys:")
+	for i, journey := range journeys {
+		fmt.Printf("%d) %s ➜ %s, leave at %s (%s)\n", i+1, journey.From, journey.To,
+			journey.LeaveAt.Local().Format("Mon 02 Jan 15:04"), journey.Status)
+		ids[i] = journey.ScheduleID
+	}
+	return ids, nil
+}
+
+func CancelScheduledJourney(userID, scheduleID string) error {
+	url := fmt.Sprintf("http://%sroute/schedule?userID=%s&scheduleID=%s", baseURL, userID, scheduleID)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to contact API Gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cancellation failed: %s", string(body))
 	}
 
+	fmt.Println("🗑 Scheduled journey cancelled.")
+	return nil
+}
+
+func requestRoute(req common.UserRequest) (common.RouteResult, error) {
 	var result common.RouteResult
-	err = json.NewDecoder(resp.Body).Decode(&result)
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return result, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("http://%sroute/request", baseURL)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return result, fmt.Errorf("failed to contact API Gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return result, fmt.Errorf("route request failed: %s", string(body))
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return result, fmt.Errorf("failed to decode response: %s", err)
+	}
+	return result, nil
+}
+
+// chooseStation asks the user to pick one of the stations an ambiguous name matched.
+func chooseStation(reader *bufio.Reader, query string, candidates []common.StationMatch) string {
+	fmt.Printf("\n'%s' matches several stations:\n", query)
+	for i, c := range candidates {
+		fmt.Printf("%d) %s\n", i+1, c.Name)
+	}
+	for {
+		fmt.Print("Enter the number of the station: ")
+		choice, _ := reader.ReadString('\n')
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimSpace(choice), "%d", &n); err == nil && n >= 1 && n <= len(candidates) {
+			return candidates[n-1].Naptan
+		}
+		fmt.Println("❌ Invalid choice")
+	}
+}
+
+func ConfirmRoute(userID string, rank int) error {
+	selection := common.RouteSelection{
+		UserID: userID,
+		Rank:   rank,
+	}
+
+	reqBody, err := json.Marshal(selection)
 	if err != nil {
+		return fmt.Errorf("failed to marshal selection: %w", err)
+	}
+
+	url := fmt.Sprintf("http://%sroute/confirm", baseURL)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to contact API Gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("route confirmation failed: %s", string(body))
+	}
+
+	var result common.RouteResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return fmt.Errorf("failed to decode response: %s", err)
 	}
 
 	fmt.Printf("New Route: %s ➜ %s\n", result.From, result.To)
-	fmt.Printf("Summary: %s (Score: %f)\n", result.Summary, result.Score)
+	fmt.Printf("Summary: %s (Score: %.2f)\n", result.Summary, result.Score)
 	return nil
 }
+
+func CheckIn(userID, station string) error {
+	checkIn := common.CheckIn{
+		UserID:  userID,
+		Station: station,
+	}
+
+	reqBody, err := json.Marshal(checkIn)
+	if err != nil {
+		return fmt.Errorf("failed to marshal check-in: %w", err)
+	}
+
+	url := fmt.Sprintf("http://%sroute/checkin", baseURL)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to contact API Gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("check-in failed: %s", string(body))
+	}
+
+	var position common.JourneyPosition
+	if err = json.NewDecoder(resp.Body).Decode(&position); err != nil {
+		return fmt.Errorf("failed to decode response: %s", err)
+	}
+
+	fmt.Printf("📍 Checked in at %s (leg %d, %s)\n", position.StopName, position.LegIndex+1, position.Segment)
+	switch {
+	case position.DelayMins >= 1:
+		fmt.Printf("⏱ You are running %.0f min behind schedule.\n", position.DelayMins)
+	case position.DelayMins <= -1:
+		fmt.Printf("⏱ You are running %.0f min ahead of schedule.\n", -position.DelayMins)
+	}
+	return nil
+}
+
+func GetPreferences(userID string) (common.UserPreferences, error) {
+	var prefs common.UserPreferences
+
+	url := fmt.Sprintf("http://%suser/preferences?userID=%s", baseURL, userID)
+	resp, err := http.Get(url)
+	if err != nil {
+		return prefs, fmt.Errorf("failed to contact API Gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return prefs, fmt.Errorf("could not load preferences: %s", string(body))
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&prefs); err != nil {
+		return prefs, fmt.Errorf("failed to decode response: %s", err)
+	}
+	return prefs, nil
+}
+
+func EditPreferences(userID string) error {
+	prefs, err := GetPreferences(userID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Enter comma-separated values, leave empty to keep the current ones or type '-' to clear them.")
+	prefs.Avoid.Lines = readList("Lines to avoid (e.g. northern)", prefs.Avoid.Lines)
+	prefs.Avoid.PeakLines = readList("Lines to avoid at peak times", prefs.Avoid.PeakLines)
+	prefs.Avoid.Modes = readList("Modes to avoid (e.g. bus)", prefs.Avoid.Modes)
+	prefs.Avoid.Stations = readList("Stations to avoid", prefs.Avoid.Stations)
+	prefs.Access = readAccessibility(prefs.Access)
+	prefs.UserID = userID
+
+	reqBody, err := json.Marshal(prefs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal preferences: %w", err)
+	}
+
+	url := fmt.Sprintf("http://%suser/preferences", baseURL)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to contact API Gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("could not save preferences: %s", string(body))
+	}
+
+	fmt.Println("✅ Preferences saved.")
+	return nil
+}
+
+func readAccessibility(current common.Accessibility) common.Accessibility {
+	for {
+		input := readInput(fmt.Sprintf("Accessibility profile: %s, %s or %s [%s]:",
+			common.AccessStepFree, common.AccessNoStairs, common.AccessExtraInterchange, current.Profile))
+		if input == "-" {
+			current.Profile = ""
+			break
+		}
+		if input == "" || input == common.AccessStepFree || input == common.AccessNoStairs || input == common.AccessExtraInterchange {
+			if input != "" {
+				current.Profile = input
+			}
+			break
+		}
+		fmt.Println("❌ Unknown accessibility profile.")
+	}
+
+	for {
+		input := readInput(fmt.Sprintf("Extra minutes to allow for each change [%d]:", current.ExtraInterchangeMins))
+		if input == "" {
+			return current
+		}
+		if input == "-" {
+			current.ExtraInterchangeMins = 0
+			return current
+		}
+		mins, err := strconv.Atoi(input)
+		if err == nil && mins >= 0 {
+			current.ExtraInterchangeMins = mins
+			return current
+		}
+		fmt.Println("❌ Please enter a whole number of minutes.")
+	}
+}
+
+func readList(prompt string, current []string) []string {
+	input := readInput(fmt.Sprintf("%s [%s]:", prompt, strings.Join(current, ", ")))
+	switch input {
+	case "":
+		return current
+	case "-":
+		return nil
+	}
+
+	var values []string
+	for _, v := range strings.Split(input, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
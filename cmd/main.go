@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -93,8 +94,6 @@ func sharedLogic(userID string) error {
 				if answer == "y" {
 					if err := RecalculateRoute(userID); err != nil {
 						fmt.Println("❌ Failed to recalculate route:", err)
-					} else {
-						fmt.Println("✅ Route recalculated successfully.")
 					}
 				}
 			} else if strings.Contains(note, "Critical delay") {
@@ -104,7 +103,13 @@ func sharedLogic(userID string) error {
 	}()
 
 	for {
-		choice := readInput("🛑 Terminate journey? (y/n): ")
+		choice := readInput("🛑 Terminate journey? (y/n, c to check in at a station): ")
+		if choice == "c" {
+			if err := CheckIn(userID, readInput("📍 Which station are you at?")); err != nil {
+				fmt.Println("❌ Check-in failed:", err)
+			}
+			continue
+		}
 		if choice == "y" {
 			if err := TerminateRouteFromMenu(userID); err != nil {
 				return fmt.Errorf("could not terminate route: %w", err)
@@ -123,7 +128,9 @@ func savedUserMenu(userID string, uuids []string) error {
 	for {
 		fmt.Println("\n\nWhere are we going next?")
 		fmt.Println("1. 🛫 Embark on one of your saved routes")
-		fmt.Println("2. Go back")
+		fmt.Println("2. ⏰ Watch a saved route on a schedule")
+		fmt.Println("3. 📋 View or remove commute watches")
+		fmt.Println("4. Go back")
 		choice := readInput("> ")
 		switch choice {
 		case "1":
@@ -145,6 +152,69 @@ func savedUserMenu(userID string, uuids []string) error {
 				continue
 			}
 		case "2":
+			chosen, err := strconv.Atoi(readInput("Enter the number of the route to watch:"))
+			if err != nil || chosen < 1 || chosen > len(uuids) {
+				fmt.Println("❌ Invalid choice")
+				continue
+			}
+			if err = WatchSavedRoute(userID, uuids[chosen-1]); err != nil {
+				fmt.Println("❌ Error:", err)
+			}
+		case "3":
+			ids, err := ViewCommuteWatches(userID)
+			if err != nil {
+				fmt.Println("❌ Error:", err)
+				continue
+			}
+			choice := readInput("Enter the number of a watch to remove (leave empty to keep them all):")
+			if choice == "" {
+				continue
+			}
+			n, err := strconv.Atoi(choice)
+			if err != nil || n < 1 || n > len(ids) {
+				fmt.Println("❌ Invalid choice")
+				continue
+			}
+			if err = RemoveCommuteWatch(userID, ids[n-1]); err != nil {
+				fmt.Println("❌ Error:", err)
+			}
+		case "4":
+			return nil
+		default:
+			fmt.Println("Invalid input. Try again.")
+		}
+	}
+}
+
+func scheduledJourneysMenu(userID string) error {
+	for {
+		fmt.Println("\n1. ➕ Book a journey for later")
+		fmt.Println("2. 📋 View or cancel scheduled journeys")
+		fmt.Println("3. Go back")
+		switch readInput("Select an option: ") {
+		case "1":
+			if err := ScheduleJourney(userID); err != nil {
+				fmt.Println("❌ Error:", err)
+			}
+		case "2":
+			ids, err := ViewScheduledJourneys(userID)
+			if err != nil {
+				fmt.Println("❌ Error:", err)
+				continue
+			}
+			choice := readInput("Enter the number of a journey to cancel (leave empty to keep them all):")
+			if choice == "" {
+				continue
+			}
+			n, err := strconv.Atoi(choice)
+			if err != nil || n < 1 || n > len(ids) {
+				fmt.Println("❌ Invalid choice")
+				continue
+			}
+			if err = CancelScheduledJourney(userID, ids[n-1]); err != nil {
+				fmt.Println("❌ Error:", err)
+			}
+		case "3":
 			return nil
 		default:
 			fmt.Println("Invalid input. Try again.")
@@ -158,7 +228,10 @@ func loggedUserMenu(userID string) error {
 		fmt.Println("\nWhat do you want to do today?")
 		fmt.Println("1. 🗂 Check favorite routes")
 		fmt.Println("2. 🛫 Start new route")
-		fmt.Println("3. Go back")
+		fmt.Println("3. ⚙️ Routing preferences")
+		fmt.Println("4. 🗓 Scheduled journeys")
+		fmt.Println("5. 📊 Travel stats")
+		fmt.Println("6. Go back")
 		choice := readInput("Select an option: ")
 		switch choice {
 		case "1":
@@ -185,6 +258,18 @@ func loggedUserMenu(userID string) error {
 				continue
 			}
 		case "3":
+			if err := EditPreferences(userID); err != nil {
+				fmt.Println("❌ Error:", err)
+			}
+		case "4":
+			if err := scheduledJourneysMenu(userID); err != nil {
+				fmt.Println("❌ Error:", err)
+			}
+		case "5":
+			if err := ViewTravelStats(userID); err != nil {
+				fmt.Println("❌ Error:", err)
+			}
+		case "6":
 			fmt.Println("Logging out...")
 			return nil
 		default:
@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/rpc"
 	"os"
+	"strconv"
+	"time"
 )
 
 var userServiceClient *rpc.Client
@@ -138,15 +140,15 @@ func handleAcceptSavedRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var reply common.SavedResp
+	var reply common.RouteResult
 	err := userServiceClient.Call("UserService.CallAcceptSavedRoute", &savedRoute, &reply)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("RPC call failed: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, "Saved route accepted successfully")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
 }
 
 func handleUserSavedRoutes(w http.ResponseWriter, r *http.Request) {
@@ -179,6 +181,146 @@ func handleUserSavedRoutes(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(reply)
 }
 
+func handleUserPreferences(w http.ResponseWriter, r *http.Request) {
+	if userServiceClient == nil {
+		http.Error(w, "User service client not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("userID")
+		if userID == "" {
+			http.Error(w, "Missing userID parameter", http.StatusBadRequest)
+			return
+		}
+
+		var reply common.UserPreferences
+		err := userServiceClient.Call("UserService.GetPreferences", &common.NewRequest{UserID: userID}, &reply)
+		if err != nil {
+			http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	case http.MethodPost:
+		var prefs common.UserPreferences
+		if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil || prefs.UserID == "" {
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		var reply common.SavedResp
+		err := userServiceClient.Call("UserService.SavePreferences", &prefs, &reply)
+		if err != nil {
+			http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	default:
+		http.Error(w, "Only GET and POST allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleCommuteWatches(w http.ResponseWriter, r *http.Request) {
+	if userServiceClient == nil {
+		http.Error(w, "User service client not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("userID")
+		if userID == "" {
+			http.Error(w, "Missing userID parameter", http.StatusBadRequest)
+			return
+		}
+
+		var reply []common.CommuteWatch
+		err := userServiceClient.Call("UserService.GetCommuteWatches", &common.NewRequest{UserID: userID}, &reply)
+		if err != nil {
+			http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	case http.MethodPost:
+		var watch common.CommuteWatch
+		if err := json.NewDecoder(r.Body).Decode(&watch); err != nil || watch.UserID == "" {
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		var reply common.CommuteWatch
+		err := userServiceClient.Call("UserService.SaveCommuteWatch", &watch, &reply)
+		if err != nil {
+			http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	case http.MethodDelete:
+		args := common.WatchLookup{UserID: r.URL.Query().Get("userID"), WatchID: r.URL.Query().Get("watchID")}
+		if args.UserID == "" || args.WatchID == "" {
+			http.Error(w, "Missing userID or watchID", http.StatusBadRequest)
+			return
+		}
+
+		var reply common.SavedResp
+		err := userServiceClient.Call("UserService.DeleteCommuteWatch", &args, &reply)
+		if err != nil {
+			http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	default:
+		http.Error(w, "Only GET, POST and DELETE allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleTravelStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	args := common.StatsQuery{UserID: r.URL.Query().Get("userID")}
+	if args.UserID == "" {
+		http.Error(w, "Missing userID parameter", http.StatusBadRequest)
+		return
+	}
+	if weeks := r.URL.Query().Get("weeks"); weeks != "" {
+		n, err := strconv.Atoi(weeks)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid weeks parameter", http.StatusBadRequest)
+			return
+		}
+		args.Weeks = n
+	}
+
+	if userServiceClient == nil {
+		http.Error(w, "User service client not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var reply common.TravelStats
+	err := userServiceClient.Call("UserService.GetTravelStats", &args, &reply)
+	if err != nil {
+		http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
 func handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
@@ -252,11 +394,18 @@ func handleServeRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !userReq.ArriveBy.IsZero() && userReq.ArriveBy.Before(time.Now()) {
+		http.Error(w, "arriveBy must be in the future", http.StatusBadRequest)
+		return
+	}
+
 	if routePlannerClient == nil {
 		http.Error(w, "Route planner service client not initialized", http.StatusInternalServerError)
 		return
 	}
 
+	addPreferences(&userReq)
+
 	var result common.RouteResult
 	err = routePlannerClient.Call("RoutePlanner.ServeRequest", &userReq, &result)
 	if err != nil {
@@ -268,6 +417,189 @@ func handleServeRequest(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
+// addPreferences merges the user's stored routing preferences into a route request.
+func addPreferences(userReq *common.UserRequest) {
+	if userServiceClient == nil {
+		return
+	}
+	var prefs common.UserPreferences
+	if err := userServiceClient.Call("UserService.GetPreferences", &common.NewRequest{UserID: userReq.UserID}, &prefs); err != nil {
+		log.Printf("Could not load preferences for user %s, planning without them: %v", userReq.UserID, err)
+		return
+	}
+	userReq.Avoid.Lines = append(userReq.Avoid.Lines, prefs.Avoid.Lines...)
+	userReq.Avoid.PeakLines = append(userReq.Avoid.PeakLines, prefs.Avoid.PeakLines...)
+	userReq.Avoid.Modes = append(userReq.Avoid.Modes, prefs.Avoid.Modes...)
+	userReq.Avoid.Stations = append(userReq.Avoid.Stations, prefs.Avoid.Stations...)
+	if userReq.Access == (common.Accessibility{}) {
+		userReq.Access = prefs.Access
+	}
+}
+
+func handleScheduledJourneys(w http.ResponseWriter, r *http.Request) {
+	if routePlannerClient == nil {
+		http.Error(w, "Route planner service client not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("userID")
+		if userID == "" {
+			http.Error(w, "Missing userID parameter", http.StatusBadRequest)
+			return
+		}
+
+		var reply []common.ScheduledJourney
+		err := routePlannerClient.Call("RoutePlanner.GetScheduledJourneys", &common.NewRequest{UserID: userID}, &reply)
+		if err != nil {
+			http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	case http.MethodPost:
+		var userReq common.UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&userReq); err != nil || userReq.UserID == "" {
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		booked := userReq.Departure
+		if !userReq.ArriveBy.IsZero() {
+			booked = userReq.ArriveBy
+		}
+		if !booked.After(time.Now()) {
+			http.Error(w, "departure or arriveBy must be in the future", http.StatusBadRequest)
+			return
+		}
+
+		addPreferences(&userReq)
+
+		var reply common.ScheduledJourney
+		err := routePlannerClient.Call("RoutePlanner.ScheduleJourney", &userReq, &reply)
+		if err != nil {
+			http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	case http.MethodDelete:
+		args := common.ScheduleLookup{UserID: r.URL.Query().Get("userID"), ScheduleID: r.URL.Query().Get("scheduleID")}
+		if args.UserID == "" || args.ScheduleID == "" {
+			http.Error(w, "Missing userID or scheduleID", http.StatusBadRequest)
+			return
+		}
+
+		var reply common.SavedResp
+		err := routePlannerClient.Call("RoutePlanner.CancelScheduledJourney", &args, &reply)
+		if err != nil {
+			http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	default:
+		http.Error(w, "Only GET, POST and DELETE allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleSearchStations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Missing q parameter", http.StatusBadRequest)
+		return
+	}
+
+	args := &common.StationQuery{Query: query}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		args.Limit = n
+	}
+
+	if routePlannerClient == nil {
+		http.Error(w, "Route planner service client not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var reply []common.StationMatch
+	err := routePlannerClient.Call("RoutePlanner.SearchStations", args, &reply)
+	if err != nil {
+		http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+func handleConfirmRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var selection common.RouteSelection
+	if err := json.NewDecoder(r.Body).Decode(&selection); err != nil || selection.UserID == "" {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	if routePlannerClient == nil {
+		http.Error(w, "Route planner service client not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var result common.RouteResult
+	err := routePlannerClient.Call("RoutePlanner.ConfirmRoute", &selection, &result)
+	if err != nil {
+		http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleCheckIn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var checkIn common.CheckIn
+	if err := json.NewDecoder(r.Body).Decode(&checkIn); err != nil || checkIn.UserID == "" || checkIn.Station == "" {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	if routePlannerClient == nil {
+		http.Error(w, "Route planner service client not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	var position common.JourneyPosition
+	err := routePlannerClient.Call("RoutePlanner.CheckIn", &checkIn, &position)
+	if err != nil {
+		http.Error(w, "RPC call failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(position)
+}
+
 func handleRecalculateRoute(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -342,9 +674,16 @@ func main() {
 	mux.HandleFunc("/user/saved-route", handleGetSavedRouteByID)
 	mux.HandleFunc("/user/accept-saved-route", handleAcceptSavedRoute)
 	mux.HandleFunc("/user/save-favorite", handleSaveFavoriteRoute)
+	mux.HandleFunc("/user/preferences", handleUserPreferences)
+	mux.HandleFunc("/user/commute-watches", handleCommuteWatches)
+	mux.HandleFunc("/user/stats", handleTravelStats)
 	mux.HandleFunc("/route/request", handleServeRequest)
+	mux.HandleFunc("/route/confirm", handleConfirmRoute)
+	mux.HandleFunc("/route/checkin", handleCheckIn)
 	mux.HandleFunc("/route/recalculate", handleRecalculateRoute)
 	mux.HandleFunc("/route/terminate", handleTerminateRoute)
+	mux.HandleFunc("/route/schedule", handleScheduledJourneys)
+	mux.HandleFunc("/stations/search", handleSearchStations)
 	mux.HandleFunc("/ws", wsHandler)
 	mux.HandleFunc("/send-notification", sendNotificationHandler)
 
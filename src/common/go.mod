@@ -1,3 +1,12 @@
 module github.com/matteoavallone7/optimaLDN/src/common
 
 go 1.23.3
+
+require (
+	github.com/aws/aws-sdk-go-v2 v1.36.6
+	github.com/aws/aws-sdk-go-v2/config v1.29.18
+	github.com/aws/aws-sdk-go-v2/credentials v1.17.71
+	github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue v1.19.6
+	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.44.1
+	github.com/jackc/pgx/v5 v5.7.5
+)
@@ -0,0 +1,201 @@
+// Package lines is the registry of the lines every service refers to. A line is identified by
+// its TfL line ID (e.g. "hammersmith-city"); names and aliases such as "Hammersmith & City" or
+// "H&C" only ever resolve to that ID.
+package lines
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrUnknownLine is returned for a line reference the registry cannot resolve.
+var ErrUnknownLine = errors.New("unknown line")
+
+// Modes are the modes whose lines are fetched from TfL on top of the bundled file.
+var Modes = []string{"tube", "dlr", "overground", "elizabeth-line", "tram", "bus"}
+
+// Line is a line with every name it is known by.
+type Line struct {
+	ID      string
+	Name    string
+	Mode    string
+	Aliases []string
+}
+
+// Registry resolves line IDs, names and aliases to lines. It is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	byID  map[string]Line
+	byKey map[string]string // normalized ID, name or alias -> ID
+}
+
+func NewRegistry(lines []Line) *Registry {
+	r := &Registry{byID: make(map[string]Line), byKey: make(map[string]string)}
+	r.Add(lines...)
+	return r
+}
+
+// Open loads the bundled file and, when client is not nil, adds the lines TfL lists for Modes.
+// TfL being unreachable is logged rather than returned, since the file covers every rail line.
+func Open(path string, client tfl.Client) (*Registry, error) {
+	lines, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRegistry(lines)
+	if client != nil {
+		statuses, err := client.LineStatus(Modes...)
+		if err != nil {
+			log.Printf("Could not fetch line metadata from TfL, using %s only: %v", path, err)
+		} else {
+			r.Learn(*statuses)
+		}
+	}
+	return r, nil
+}
+
+// LoadFile reads a line file, "id,name,mode,aliases" with a header and aliases separated by "|".
+func LoadFile(path string) ([]Line, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return ParseRecords(records)
+}
+
+// ParseRecords builds lines from the records of a line file, header included.
+func ParseRecords(records [][]string) ([]Line, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty line file")
+	}
+
+	var lines []Line
+	for i, rec := range records[1:] {
+		if len(rec) < 3 || rec[0] == "" || rec[1] == "" {
+			return nil, fmt.Errorf("line file row %d: want id, name and mode", i+2)
+		}
+		line := Line{ID: strings.ToLower(rec[0]), Name: rec[1], Mode: rec[2]}
+		if len(rec) > 3 && rec[3] != "" {
+			line.Aliases = strings.Split(rec[3], "|")
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// Add registers lines. A line already registered keeps its aliases and gains the new ones.
+func (r *Registry) Add(lines ...Line) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, line := range lines {
+		line.ID = strings.ToLower(line.ID)
+		if existing, ok := r.byID[line.ID]; ok {
+			line.Aliases = append(existing.Aliases, line.Aliases...)
+			if line.Name == "" {
+				line.Name = existing.Name
+			}
+			if line.Mode == "" {
+				line.Mode = existing.Mode
+			}
+		}
+		r.byID[line.ID] = line
+
+		// An ID always resolves to its own line; names and aliases go to the first line claiming them.
+		r.byKey[key(line.ID)] = line.ID
+		for _, name := range append([]string{line.Name}, line.Aliases...) {
+			if k := key(name); k != "" {
+				if _, taken := r.byKey[k]; !taken {
+					r.byKey[k] = line.ID
+				}
+			}
+		}
+	}
+}
+
+// Learn registers the lines of a TfL line status response and returns how many there were.
+func (r *Registry) Learn(statuses common.TfLLineStatusResponse) int {
+	lines := make([]Line, 0, len(statuses))
+	for _, status := range statuses {
+		if status.ID != "" {
+			lines = append(lines, Line{ID: status.ID, Name: status.Name, Mode: status.ModeName})
+		}
+	}
+	r.Add(lines...)
+	return len(lines)
+}
+
+// Resolve returns the line an ID, name or alias refers to, ignoring case, punctuation and a
+// trailing "line".
+func (r *Registry) Resolve(ref string) (Line, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if line, ok := r.byID[strings.ToLower(strings.TrimSpace(ref))]; ok {
+		return line, nil
+	}
+	if id, ok := r.byKey[key(ref)]; ok {
+		return r.byID[id], nil
+	}
+	return Line{}, fmt.Errorf("%w '%s'", ErrUnknownLine, ref)
+}
+
+// Canonical resolves line references to line IDs, in order and without duplicates. Empty
+// references are skipped. If some cannot be resolved, it returns the IDs of the others along
+// with an error naming every unknown reference.
+func (r *Registry) Canonical(refs []string) ([]string, error) {
+	var ids, unknown []string
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if strings.TrimSpace(ref) == "" {
+			continue
+		}
+		line, err := r.Resolve(ref)
+		if err != nil {
+			unknown = append(unknown, fmt.Sprintf("'%s'", ref))
+			continue
+		}
+		if !seen[line.ID] {
+			seen[line.ID] = true
+			ids = append(ids, line.ID)
+		}
+	}
+	if len(unknown) > 0 {
+		return ids, fmt.Errorf("%w: %s", ErrUnknownLine, strings.Join(unknown, ", "))
+	}
+	return ids, nil
+}
+
+// Len returns how many lines are registered.
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.byID)
+}
+
+// key normalizes a reference for lookup: "Hammersmith & City line" and "hammersmith-and-city"
+// both become "hammersmithandcity".
+func key(ref string) string {
+	words := strings.FieldsFunc(strings.ToLower(strings.ReplaceAll(ref, "&", " and ")), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > 1 && words[len(words)-1] == "line" {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, "")
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"os"
+)
+
+// Storage backends.
+const (
+	BackendDynamo   = "dynamodb"
+	BackendPostgres = "postgres"
+	BackendMemory   = "memory"
+)
+
+// Config selects and configures a storage backend.
+type Config struct {
+	Backend     string
+	PostgresURL string
+	Tables      Tables
+}
+
+// ConfigFromEnv reads STORE_BACKEND (dynamodb by default), DATABASE_URL for Postgres, and
+// CHOSEN_ROUTES_TABLE, ACTIVE_ROUTES_TABLE, LINE_SUBSCRIPTIONS_TABLE, SCHEDULED_JOURNEYS_TABLE
+// and FARE_LEDGERS_TABLE to rename the DynamoDB tables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Backend:     os.Getenv("STORE_BACKEND"),
+		PostgresURL: os.Getenv("DATABASE_URL"),
+		Tables:      DefaultTables,
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = BackendDynamo
+	}
+	if table := os.Getenv("CHOSEN_ROUTES_TABLE"); table != "" {
+		cfg.Tables.ChosenRoutes = table
+	}
+	if table := os.Getenv("ACTIVE_ROUTES_TABLE"); table != "" {
+		cfg.Tables.ActiveRoutes = table
+	}
+	if table := os.Getenv("LINE_SUBSCRIPTIONS_TABLE"); table != "" {
+		cfg.Tables.LineSubscriptions = table
+	}
+	if table := os.Getenv("SCHEDULED_JOURNEYS_TABLE"); table != "" {
+		cfg.Tables.ScheduledJourneys = table
+	}
+	if table := os.Getenv("FARE_LEDGERS_TABLE"); table != "" {
+		cfg.Tables.FareLedgers = table
+	}
+	return cfg
+}
+
+// Open connects to the configured backend.
+func Open(ctx context.Context, cfg Config) (Repository, error) {
+	switch cfg.Backend {
+	case BackendDynamo:
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		return NewDynamo(dynamodb.NewFromConfig(awsCfg), cfg.Tables), nil
+	case BackendPostgres:
+		if cfg.PostgresURL == "" {
+			return nil, fmt.Errorf("the postgres backend needs DATABASE_URL")
+		}
+		pool, err := pgxpool.New(ctx, cfg.PostgresURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
+		}
+		return NewPostgres(pool), nil
+	case BackendMemory:
+		return NewMemory(), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend '%s': use %s, %s or %s", cfg.Backend, BackendDynamo, BackendPostgres, BackendMemory)
+}
@@ -0,0 +1,480 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Tables names the DynamoDB tables of each kind of route.
+type Tables struct {
+	ChosenRoutes      string // partition key userID
+	ActiveRoutes      string // partition key userID
+	LineSubscriptions string // partition key lineID, sort key userID: the line index of ActiveRoutes
+	ScheduledJourneys string // partition key scheduleID
+	FareLedgers       string // partition key userID
+}
+
+// DefaultTables are the tables described in the README.
+var DefaultTables = Tables{
+	ChosenRoutes:      "ChosenRoutes",
+	ActiveRoutes:      "ActiveRoutes",
+	LineSubscriptions: "LineSubscriptions",
+	ScheduledJourneys: "ScheduledJourneys",
+	FareLedgers:       "FareLedgers",
+}
+
+// Dynamo keeps routes in DynamoDB.
+type Dynamo struct {
+	client *dynamodb.Client
+	tables Tables
+}
+
+func NewDynamo(client *dynamodb.Client, tables Tables) *Dynamo {
+	return &Dynamo{client: client, tables: tables}
+}
+
+func keyOf(name, value string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		name: &types.AttributeValueMemberS{Value: value},
+	}
+}
+
+func (d *Dynamo) GetChosenRoute(ctx context.Context, userID string) (*common.ChosenRoute, error) {
+	result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.tables.ChosenRoutes),
+		Key:       keyOf("userID", userID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chosen route: %w", err)
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("no chosen route for user %s: %w", userID, ErrNotFound)
+	}
+
+	var route common.ChosenRoute
+	if err = attributevalue.UnmarshalMap(result.Item, &route); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal route: %w", err)
+	}
+	return &route, nil
+}
+
+func (d *Dynamo) SaveChosenRoute(ctx context.Context, route common.ChosenRoute) error {
+	item, err := attributevalue.MarshalMap(route)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chosen route: %w", err)
+	}
+
+	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.tables.ChosenRoutes),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save route to DynamoDB: %w", err)
+	}
+	return nil
+}
+
+func (d *Dynamo) DeleteChosenRoute(ctx context.Context, userID string) error {
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.tables.ChosenRoutes),
+		Key:       keyOf("userID", userID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete route for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (d *Dynamo) ListChosenRoutes(ctx context.Context) ([]common.ChosenRoute, error) {
+	var routes []common.ChosenRoute
+	if err := d.scan(ctx, d.tables.ChosenRoutes, func(items []map[string]types.AttributeValue) error {
+		var batch []common.ChosenRoute
+		if err := attributevalue.UnmarshalListOfMaps(items, &batch); err != nil {
+			return fmt.Errorf("failed to unmarshal chosen routes: %w", err)
+		}
+		routes = append(routes, batch...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].UserID < routes[j].UserID
+	})
+	return routes, nil
+}
+
+// Active routes are written in one transaction with their rows of the line index, so that the
+// two never disagree. Each item carries a version, and the transaction only applies if the
+// route is still the one read to compute the index rows; otherwise it is read again and retried.
+
+func (d *Dynamo) PutActiveRoute(ctx context.Context, route common.ActiveRoute) error {
+	_, err := d.rewriteActiveRoute(ctx, route.UserID, &route)
+	return err
+}
+
+func (d *Dynamo) DeleteActiveRoute(ctx context.Context, userID string) (*common.ActiveRoute, error) {
+	return d.rewriteActiveRoute(ctx, userID, nil)
+}
+
+// maxRewriteAttempts bounds how many times a write racing another write to the same route is retried.
+const maxRewriteAttempts = 3
+
+// rewriteActiveRoute replaces the active route of a user, deleting it if route is nil, and
+// returns the route it replaced, or nil if the user had none.
+func (d *Dynamo) rewriteActiveRoute(ctx context.Context, userID string, route *common.ActiveRoute) (*common.ActiveRoute, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(d.tables.ActiveRoutes),
+			Key:            keyOf("userID", userID),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read active route for user %s: %w", userID, err)
+		}
+		var previous *common.ActiveRoute
+		if result.Item != nil {
+			previous = &common.ActiveRoute{}
+			if err = attributevalue.UnmarshalMap(result.Item, previous); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal active route: %w", err)
+			}
+		}
+		if previous == nil && route == nil {
+			return nil, nil
+		}
+
+		items, err := d.activeRouteWrites(userID, result.Item, previous, route)
+		if err != nil {
+			return nil, err
+		}
+		_, err = d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && conditionFailed(canceled) && attempt < maxRewriteAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write active route for user %s: %w", userID, err)
+		}
+		return previous, nil
+	}
+}
+
+// maxTransactItems is the most items DynamoDB accepts in one TransactWriteItems call.
+const maxTransactItems = 100
+
+// activeRouteWrites returns the transaction that turns the stored item of a user, holding
+// previous, into route, or deletes it if route is nil.
+func (d *Dynamo) activeRouteWrites(userID string, stored map[string]types.AttributeValue, previous, route *common.ActiveRoute) ([]types.TransactWriteItem, error) {
+	// The first write is the route itself, under the condition that it is still as read.
+	condition := "attribute_not_exists(userID)"
+	var values map[string]types.AttributeValue
+	version := 0
+	if stored != nil {
+		condition = "attribute_exists(userID) AND attribute_not_exists(version)"
+		if v, ok := stored["version"]; ok {
+			condition = "version = :version"
+			values = map[string]types.AttributeValue{":version": v}
+			if err := attributevalue.Unmarshal(v, &version); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal active route version: %w", err)
+			}
+		}
+	}
+
+	var items []types.TransactWriteItem
+	var from, to []string
+	if previous != nil {
+		from = previous.LineIDs
+	}
+	if route == nil {
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(d.tables.ActiveRoutes),
+			Key:                       keyOf("userID", userID),
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeValues: values,
+		}})
+	} else {
+		item, err := attributevalue.MarshalMap(route)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal ActiveRoute: %w", err)
+		}
+		item["version"] = &types.AttributeValueMemberN{Value: strconv.Itoa(version + 1)}
+		items = append(items, types.TransactWriteItem{Put: &types.Put{
+			TableName:                 aws.String(d.tables.ActiveRoutes),
+			Item:                      item,
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeValues: values,
+		}})
+		to = route.LineIDs
+	}
+
+	keep := uniqueLines(to)
+	for lineID := range uniqueLines(from) {
+		if !keep[lineID] {
+			key, _ := attributevalue.MarshalMap(subscription{LineID: lineID, UserID: userID}) // a struct of strings always marshals
+			items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+				TableName: aws.String(d.tables.LineSubscriptions),
+				Key:       key,
+			}})
+		}
+	}
+	for lineID := range keep {
+		item, _ := attributevalue.MarshalMap(subscription{LineID: lineID, UserID: userID})
+		items = append(items, types.TransactWriteItem{Put: &types.Put{
+			TableName: aws.String(d.tables.LineSubscriptions),
+			Item:      item,
+		}})
+	}
+	if len(items) > maxTransactItems {
+		return nil, fmt.Errorf("active route for user %s changes too many lines (%d) to write in one transaction", userID, len(items)-1)
+	}
+	return items, nil
+}
+
+// conditionFailed reports whether a transaction was canceled because a condition did not hold.
+func conditionFailed(canceled *types.TransactionCanceledException) bool {
+	for _, reason := range canceled.CancellationReasons {
+		if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dynamo) ListActiveRoutes(ctx context.Context) ([]common.ActiveRoute, error) {
+	var routes []common.ActiveRoute
+	if err := d.scan(ctx, d.tables.ActiveRoutes, func(items []map[string]types.AttributeValue) error {
+		var batch []common.ActiveRoute
+		if err := attributevalue.UnmarshalListOfMaps(items, &batch); err != nil {
+			return fmt.Errorf("failed to unmarshal active routes: %w", err)
+		}
+		routes = append(routes, batch...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// subscription is an item of the line index.
+type subscription struct {
+	LineID string `dynamodbav:"lineID"`
+	UserID string `dynamodbav:"userID"`
+}
+
+func (d *Dynamo) UsersOnLine(ctx context.Context, lineID string) ([]string, error) {
+	paginator := dynamodb.NewQueryPaginator(d.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(d.tables.LineSubscriptions),
+		KeyConditionExpression:    aws.String("lineID = :line"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":line": &types.AttributeValueMemberS{Value: lineID}},
+	})
+
+	var users []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query users on line %s: %w", lineID, err)
+		}
+		var batch []subscription
+		if err = attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal line subscriptions: %w", err)
+		}
+		for _, s := range batch {
+			users = append(users, s.UserID)
+		}
+	}
+	return users, nil
+}
+
+func (d *Dynamo) RebuildLineIndex(ctx context.Context) (int, error) {
+	routes, err := d.ListActiveRoutes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	want := make(map[subscription]bool)
+	for _, route := range routes {
+		for _, lineID := range route.LineIDs {
+			want[subscription{LineID: lineID, UserID: route.UserID}] = true
+		}
+	}
+
+	var requests []types.WriteRequest
+	if err = d.scan(ctx, d.tables.LineSubscriptions, func(items []map[string]types.AttributeValue) error {
+		var batch []subscription
+		if err := attributevalue.UnmarshalListOfMaps(items, &batch); err != nil {
+			return fmt.Errorf("failed to unmarshal line subscriptions: %w", err)
+		}
+		for _, s := range batch {
+			if want[s] {
+				delete(want, s) // already indexed
+				continue
+			}
+			requests = append(requests, unsubscribeRequest(s))
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	for s := range want {
+		requests = append(requests, subscribeRequest(s))
+	}
+	if err = d.writeSubscriptions(ctx, requests); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, route := range routes {
+		total += len(uniqueLines(route.LineIDs))
+	}
+	return total, nil
+}
+
+func uniqueLines(lineIDs []string) map[string]bool {
+	lines := make(map[string]bool, len(lineIDs))
+	for _, lineID := range lineIDs {
+		lines[lineID] = true
+	}
+	return lines
+}
+
+func subscribeRequest(s subscription) types.WriteRequest {
+	item, _ := attributevalue.MarshalMap(s) // a struct of strings always marshals
+	return types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+}
+
+func unsubscribeRequest(s subscription) types.WriteRequest {
+	key, _ := attributevalue.MarshalMap(s)
+	return types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}}
+}
+
+// maxBatchWrite is the most items DynamoDB accepts in one BatchWriteItem call.
+const maxBatchWrite = 25
+
+// writeSubscriptions applies writes to the line index in batches, retrying the items DynamoDB
+// leaves unprocessed. Only RebuildLineIndex uses it, as it has more writes than a transaction
+// takes and nothing to keep consistent with.
+func (d *Dynamo) writeSubscriptions(ctx context.Context, requests []types.WriteRequest) error {
+	for len(requests) > 0 {
+		n := min(len(requests), maxBatchWrite)
+		pending := map[string][]types.WriteRequest{d.tables.LineSubscriptions: requests[:n]}
+		requests = requests[n:]
+
+		for attempt := 1; len(pending) > 0; attempt++ {
+			if attempt > 5 {
+				return fmt.Errorf("failed to update the line index: items left unprocessed")
+			}
+			result, err := d.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return fmt.Errorf("failed to update the line index: %w", err)
+			}
+			pending = result.UnprocessedItems
+			if len(pending) > 0 {
+				time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Dynamo) SaveScheduledJourney(ctx context.Context, journey common.ScheduledJourney) error {
+	item, err := attributevalue.MarshalMap(journey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduled journey: %w", err)
+	}
+
+	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.tables.ScheduledJourneys),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save scheduled journey to DynamoDB: %w", err)
+	}
+	return nil
+}
+
+func (d *Dynamo) DeleteScheduledJourney(ctx context.Context, scheduleID string) error {
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.tables.ScheduledJourneys),
+		Key:       keyOf("scheduleID", scheduleID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete scheduled journey %s: %w", scheduleID, err)
+	}
+	return nil
+}
+
+func (d *Dynamo) ListScheduledJourneys(ctx context.Context) ([]common.ScheduledJourney, error) {
+	var journeys []common.ScheduledJourney
+	if err := d.scan(ctx, d.tables.ScheduledJourneys, func(items []map[string]types.AttributeValue) error {
+		var batch []common.ScheduledJourney
+		if err := attributevalue.UnmarshalListOfMaps(items, &batch); err != nil {
+			return fmt.Errorf("failed to unmarshal scheduled journeys: %w", err)
+		}
+		journeys = append(journeys, batch...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return journeys, nil
+}
+
+func (d *Dynamo) GetFareLedger(ctx context.Context, userID string) (*common.FareLedger, error) {
+	result, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.tables.FareLedgers),
+		Key:       keyOf("userID", userID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fare ledger: %w", err)
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("no fare ledger for user %s: %w", userID, ErrNotFound)
+	}
+
+	var ledger common.FareLedger
+	if err = attributevalue.UnmarshalMap(result.Item, &ledger); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fare ledger: %w", err)
+	}
+	return &ledger, nil
+}
+
+func (d *Dynamo) SaveFareLedger(ctx context.Context, ledger common.FareLedger) error {
+	item, err := attributevalue.MarshalMap(ledger)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fare ledger: %w", err)
+	}
+
+	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.tables.FareLedgers),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save fare ledger to DynamoDB: %w", err)
+	}
+	return nil
+}
+
+// scan reads a whole table, page by page.
+func (d *Dynamo) scan(ctx context.Context, table string, page func([]map[string]types.AttributeValue) error) error {
+	paginator := dynamodb.NewScanPaginator(d.client, &dynamodb.ScanInput{
+		TableName: aws.String(table),
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+		if err = page(result.Items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dynamo) Close() {}
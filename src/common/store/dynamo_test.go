@@ -0,0 +1,89 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"github.com/matteoavallone7/optimaLDN/src/common/store/storetest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDynamo runs against a local DynamoDB, e.g. amazon/dynamodb-local, at DYNAMODB_ENDPOINT.
+// Every test creates its own tables and deletes them afterwards.
+func TestDynamo(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT not set")
+	}
+
+	ctx := context.Background()
+	awsCfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion("eu-west-2"),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("local", "local", "")),
+	)
+	if err != nil {
+		t.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	storetest.Run(t, func(t *testing.T) store.Repository {
+		suffix := fmt.Sprintf("_%s_%d", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+		tables := store.Tables{
+			ChosenRoutes:      "ChosenRoutes" + suffix,
+			ActiveRoutes:      "ActiveRoutes" + suffix,
+			LineSubscriptions: "LineSubscriptions" + suffix,
+			ScheduledJourneys: "ScheduledJourneys" + suffix,
+			FareLedgers:       "FareLedgers" + suffix,
+		}
+		createTable(t, client, tables.ChosenRoutes, "userID", "")
+		createTable(t, client, tables.ActiveRoutes, "userID", "")
+		createTable(t, client, tables.LineSubscriptions, "lineID", "userID")
+		createTable(t, client, tables.ScheduledJourneys, "scheduleID", "")
+		createTable(t, client, tables.FareLedgers, "userID", "")
+		return store.NewDynamo(client, tables)
+	})
+}
+
+// createTable creates a table with string keys, which is deleted when the test ends.
+func createTable(t *testing.T, client *dynamodb.Client, name, partitionKey, sortKey string) {
+	ctx := context.Background()
+	input := &dynamodb.CreateTableInput{
+		TableName:   aws.String(name),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(partitionKey), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(partitionKey), KeyType: types.KeyTypeHash},
+		},
+	}
+	if sortKey != "" {
+		input.AttributeDefinitions = append(input.AttributeDefinitions,
+			types.AttributeDefinition{AttributeName: aws.String(sortKey), AttributeType: types.ScalarAttributeTypeS})
+		input.KeySchema = append(input.KeySchema,
+			types.KeySchemaElement{AttributeName: aws.String(sortKey), KeyType: types.KeyTypeRange})
+	}
+	if _, err := client.CreateTable(ctx, input); err != nil {
+		t.Fatalf("failed to create table %s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		if _, err := client.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(name)}); err != nil {
+			t.Logf("failed to delete table %s: %v", name, err)
+		}
+	})
+
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(name)}, time.Minute); err != nil {
+		t.Fatalf("table %s did not become active: %v", name, err)
+	}
+}
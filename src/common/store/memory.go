@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"sort"
+	"sync"
+)
+
+// Memory keeps routes in process memory. They are lost on restart, which is fine for
+// development and tests.
+type Memory struct {
+	mu        sync.RWMutex
+	chosen    map[string][]byte
+	active    map[string]common.ActiveRoute
+	byLine    map[string]map[string]bool // line ID → user IDs
+	scheduled map[string][]byte
+	ledgers   map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		chosen:    make(map[string][]byte),
+		active:    make(map[string]common.ActiveRoute),
+		byLine:    make(map[string]map[string]bool),
+		scheduled: make(map[string][]byte),
+		ledgers:   make(map[string][]byte),
+	}
+}
+
+// Routes are kept encoded, so callers never share slices with the store.
+
+func (m *Memory) GetChosenRoute(_ context.Context, userID string) (*common.ChosenRoute, error) {
+	m.mu.RLock()
+	data, ok := m.chosen[userID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no chosen route for user %s: %w", userID, ErrNotFound)
+	}
+
+	var route common.ChosenRoute
+	if err := json.Unmarshal(data, &route); err != nil {
+		return nil, fmt.Errorf("failed to decode chosen route: %w", err)
+	}
+	return &route, nil
+}
+
+func (m *Memory) SaveChosenRoute(_ context.Context, route common.ChosenRoute) error {
+	data, err := json.Marshal(route)
+	if err != nil {
+		return fmt.Errorf("failed to encode chosen route: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chosen[route.UserID] = data
+	return nil
+}
+
+func (m *Memory) DeleteChosenRoute(_ context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.chosen, userID)
+	return nil
+}
+
+func (m *Memory) ListChosenRoutes(_ context.Context) ([]common.ChosenRoute, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	routes := make([]common.ChosenRoute, 0, len(m.chosen))
+	for _, data := range m.chosen {
+		var route common.ChosenRoute
+		if err := json.Unmarshal(data, &route); err != nil {
+			return nil, fmt.Errorf("failed to decode chosen route: %w", err)
+		}
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].UserID < routes[j].UserID
+	})
+	return routes, nil
+}
+
+func (m *Memory) PutActiveRoute(_ context.Context, route common.ActiveRoute) error {
+	route.LineIDs = append([]string(nil), route.LineIDs...)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.unsubscribe(route.UserID)
+	m.active[route.UserID] = route
+	m.subscribe(route)
+	return nil
+}
+
+func (m *Memory) DeleteActiveRoute(_ context.Context, userID string) (*common.ActiveRoute, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	route, ok := m.active[userID]
+	if !ok {
+		return nil, nil
+	}
+	m.unsubscribe(userID)
+	delete(m.active, userID)
+	return &route, nil
+}
+
+func (m *Memory) ListActiveRoutes(_ context.Context) ([]common.ActiveRoute, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	routes := make([]common.ActiveRoute, 0, len(m.active))
+	for _, route := range m.active {
+		route.LineIDs = append([]string(nil), route.LineIDs...)
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].UserID < routes[j].UserID
+	})
+	return routes, nil
+}
+
+func (m *Memory) UsersOnLine(_ context.Context, lineID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]string, 0, len(m.byLine[lineID]))
+	for userID := range m.byLine[lineID] {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+func (m *Memory) RebuildLineIndex(_ context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.byLine = make(map[string]map[string]bool)
+	subscriptions := 0
+	for _, route := range m.active {
+		subscriptions += m.subscribe(route)
+	}
+	return subscriptions, nil
+}
+
+// subscribe adds a route to the line index and returns how many lines it subscribed to.
+func (m *Memory) subscribe(route common.ActiveRoute) int {
+	added := 0
+	for _, lineID := range route.LineIDs {
+		users, ok := m.byLine[lineID]
+		if !ok {
+			users = make(map[string]bool)
+			m.byLine[lineID] = users
+		}
+		if !users[route.UserID] {
+			users[route.UserID] = true
+			added++
+		}
+	}
+	return added
+}
+
+// unsubscribe removes the current route of a user from the line index.
+func (m *Memory) unsubscribe(userID string) {
+	for _, lineID := range m.active[userID].LineIDs {
+		delete(m.byLine[lineID], userID)
+		if len(m.byLine[lineID]) == 0 {
+			delete(m.byLine, lineID)
+		}
+	}
+}
+
+func (m *Memory) SaveScheduledJourney(_ context.Context, journey common.ScheduledJourney) error {
+	data, err := json.Marshal(journey)
+	if err != nil {
+		return fmt.Errorf("failed to encode scheduled journey: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scheduled[journey.ScheduleID] = data
+	return nil
+}
+
+func (m *Memory) DeleteScheduledJourney(_ context.Context, scheduleID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.scheduled, scheduleID)
+	return nil
+}
+
+func (m *Memory) ListScheduledJourneys(_ context.Context) ([]common.ScheduledJourney, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	journeys := make([]common.ScheduledJourney, 0, len(m.scheduled))
+	for _, data := range m.scheduled {
+		var journey common.ScheduledJourney
+		if err := json.Unmarshal(data, &journey); err != nil {
+			return nil, fmt.Errorf("failed to decode scheduled journey: %w", err)
+		}
+		journeys = append(journeys, journey)
+	}
+	return journeys, nil
+}
+
+func (m *Memory) GetFareLedger(_ context.Context, userID string) (*common.FareLedger, error) {
+	m.mu.RLock()
+	data, ok := m.ledgers[userID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no fare ledger for user %s: %w", userID, ErrNotFound)
+	}
+
+	var ledger common.FareLedger
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		return nil, fmt.Errorf("failed to decode fare ledger: %w", err)
+	}
+	return &ledger, nil
+}
+
+func (m *Memory) SaveFareLedger(_ context.Context, ledger common.FareLedger) error {
+	data, err := json.Marshal(ledger)
+	if err != nil {
+		return fmt.Errorf("failed to encode fare ledger: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ledgers[ledger.UserID] = data
+	return nil
+}
+
+func (m *Memory) Close() {}
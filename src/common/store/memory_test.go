@@ -0,0 +1,11 @@
+package store_test
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"github.com/matteoavallone7/optimaLDN/src/common/store/storetest"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Repository { return store.NewMemory() })
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+)
+
+// Postgres keeps routes in the chosen_routes, active_routes and scheduled_journeys tables
+// of postgresql/db.sql, and fare ledgers in fare_ledgers. Routes and ledgers are stored whole
+// as JSONB; the lines of active routes are indexed by a GIN index on line_ids.
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+func (p *Postgres) GetChosenRoute(ctx context.Context, userID string) (*common.ChosenRoute, error) {
+	var route common.ChosenRoute
+	err := p.pool.QueryRow(ctx, `SELECT route FROM chosen_routes WHERE user_id = $1`, userID).Scan(&route)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("no chosen route for user %s: %w", userID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chosen route: %w", err)
+	}
+	return &route, nil
+}
+
+func (p *Postgres) SaveChosenRoute(ctx context.Context, route common.ChosenRoute) error {
+	_, err := p.pool.Exec(ctx, `
+        INSERT INTO chosen_routes (user_id, route)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE SET route = EXCLUDED.route
+    `, route.UserID, route)
+	if err != nil {
+		return fmt.Errorf("failed to save chosen route: %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteChosenRoute(ctx context.Context, userID string) error {
+	_, err := p.pool.Exec(ctx, `DELETE FROM chosen_routes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete route for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (p *Postgres) ListChosenRoutes(ctx context.Context) ([]common.ChosenRoute, error) {
+	rows, err := p.pool.Query(ctx, `SELECT route FROM chosen_routes ORDER BY user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chosen routes: %w", err)
+	}
+	defer rows.Close()
+
+	var routes []common.ChosenRoute
+	for rows.Next() {
+		var route common.ChosenRoute
+		if err = rows.Scan(&route); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		routes = append(routes, route)
+	}
+	return routes, rows.Err()
+}
+
+func (p *Postgres) PutActiveRoute(ctx context.Context, route common.ActiveRoute) error {
+	lineIDs := route.LineIDs
+	if lineIDs == nil {
+		lineIDs = []string{}
+	}
+	_, err := p.pool.Exec(ctx, `
+        INSERT INTO active_routes (user_id, line_ids)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE SET line_ids = EXCLUDED.line_ids
+    `, route.UserID, lineIDs)
+	if err != nil {
+		return fmt.Errorf("failed to write active route: %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteActiveRoute(ctx context.Context, userID string) (*common.ActiveRoute, error) {
+	var deleted common.ActiveRoute
+	err := p.pool.QueryRow(ctx, `DELETE FROM active_routes WHERE user_id = $1 RETURNING user_id, line_ids`, userID).
+		Scan(&deleted.UserID, &deleted.LineIDs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete active route for user %s: %w", userID, err)
+	}
+	return &deleted, nil
+}
+
+func (p *Postgres) ListActiveRoutes(ctx context.Context) ([]common.ActiveRoute, error) {
+	rows, err := p.pool.Query(ctx, `SELECT user_id, line_ids FROM active_routes ORDER BY user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active routes: %w", err)
+	}
+	defer rows.Close()
+
+	var routes []common.ActiveRoute
+	for rows.Next() {
+		var route common.ActiveRoute
+		if err = rows.Scan(&route.UserID, &route.LineIDs); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		routes = append(routes, route)
+	}
+	return routes, rows.Err()
+}
+
+func (p *Postgres) UsersOnLine(ctx context.Context, lineID string) ([]string, error) {
+	rows, err := p.pool.Query(ctx, `SELECT user_id FROM active_routes WHERE line_ids @> ARRAY[$1::text] ORDER BY user_id`, lineID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users on line %s: %w", lineID, err)
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		users = append(users, userID)
+	}
+	return users, rows.Err()
+}
+
+func (p *Postgres) RebuildLineIndex(ctx context.Context) (int, error) {
+	if _, err := p.pool.Exec(ctx, `REINDEX INDEX active_routes_line_ids`); err != nil {
+		return 0, fmt.Errorf("failed to rebuild the line index: %w", err)
+	}
+
+	var subscriptions int
+	err := p.pool.QueryRow(ctx, `
+        SELECT count(*) FROM (SELECT DISTINCT user_id, unnest(line_ids) FROM active_routes) AS subscriptions
+    `).Scan(&subscriptions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count line subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+func (p *Postgres) SaveScheduledJourney(ctx context.Context, journey common.ScheduledJourney) error {
+	_, err := p.pool.Exec(ctx, `
+        INSERT INTO scheduled_journeys (schedule_id, user_id, journey)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (schedule_id) DO UPDATE SET user_id = EXCLUDED.user_id, journey = EXCLUDED.journey
+    `, journey.ScheduleID, journey.UserID, journey)
+	if err != nil {
+		return fmt.Errorf("failed to save scheduled journey: %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteScheduledJourney(ctx context.Context, scheduleID string) error {
+	_, err := p.pool.Exec(ctx, `DELETE FROM scheduled_journeys WHERE schedule_id = $1`, scheduleID)
+	if err != nil {
+		return fmt.Errorf("failed to delete scheduled journey %s: %w", scheduleID, err)
+	}
+	return nil
+}
+
+func (p *Postgres) ListScheduledJourneys(ctx context.Context) ([]common.ScheduledJourney, error) {
+	rows, err := p.pool.Query(ctx, `SELECT journey FROM scheduled_journeys`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled journeys: %w", err)
+	}
+	defer rows.Close()
+
+	var journeys []common.ScheduledJourney
+	for rows.Next() {
+		var journey common.ScheduledJourney
+		if err = rows.Scan(&journey); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		journeys = append(journeys, journey)
+	}
+	return journeys, rows.Err()
+}
+
+func (p *Postgres) GetFareLedger(ctx context.Context, userID string) (*common.FareLedger, error) {
+	var ledger common.FareLedger
+	err := p.pool.QueryRow(ctx, `SELECT ledger FROM fare_ledgers WHERE user_id = $1`, userID).Scan(&ledger)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("no fare ledger for user %s: %w", userID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fare ledger: %w", err)
+	}
+	return &ledger, nil
+}
+
+func (p *Postgres) SaveFareLedger(ctx context.Context, ledger common.FareLedger) error {
+	_, err := p.pool.Exec(ctx, `
+        INSERT INTO fare_ledgers (user_id, ledger)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE SET ledger = EXCLUDED.ledger
+    `, ledger.UserID, ledger)
+	if err != nil {
+		return fmt.Errorf("failed to save fare ledger: %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) Close() {
+	p.pool.Close()
+}
@@ -0,0 +1,32 @@
+package store_test
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"github.com/matteoavallone7/optimaLDN/src/common/store/storetest"
+	"os"
+	"testing"
+)
+
+// TestPostgres runs against the database in DATABASE_URL, which must have the tables of
+// postgresql/db.sql. Their rows are deleted before every test.
+func TestPostgres(t *testing.T) {
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+
+	storetest.Run(t, func(t *testing.T) store.Repository {
+		ctx := context.Background()
+		pool, err := pgxpool.New(ctx, url)
+		if err != nil {
+			t.Fatalf("failed to connect to Postgres: %v", err)
+		}
+		if _, err = pool.Exec(ctx, `TRUNCATE chosen_routes, active_routes, scheduled_journeys, fare_ledgers`); err != nil {
+			pool.Close()
+			t.Fatalf("failed to empty the store tables: %v", err)
+		}
+		return store.NewPostgres(pool)
+	})
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+)
+
+// ErrNotFound is returned when a user has no route in the store.
+var ErrNotFound = errors.New("not found")
+
+// ChosenRouteRepository keeps the route each user is following, one per user.
+type ChosenRouteRepository interface {
+	GetChosenRoute(ctx context.Context, userID string) (*common.ChosenRoute, error)
+	SaveChosenRoute(ctx context.Context, route common.ChosenRoute) error
+	DeleteChosenRoute(ctx context.Context, userID string) error
+	// ListChosenRoutes returns the route of every user following one, sorted by user.
+	ListChosenRoutes(ctx context.Context) ([]common.ChosenRoute, error)
+}
+
+// ActiveRouteRepository keeps the lines of each user's active route, which delay alerts are
+// matched against. Routes are indexed by line as they are written, so that the users of a line
+// are found without reading every route.
+type ActiveRouteRepository interface {
+	PutActiveRoute(ctx context.Context, route common.ActiveRoute) error
+	// DeleteActiveRoute returns the route it deleted, or nil if the user had none.
+	DeleteActiveRoute(ctx context.Context, userID string) (*common.ActiveRoute, error)
+	ListActiveRoutes(ctx context.Context) ([]common.ActiveRoute, error)
+	// UsersOnLine returns the users whose active route uses a line, from the line index.
+	UsersOnLine(ctx context.Context, lineID string) ([]string, error)
+	// RebuildLineIndex recomputes the line index from the active routes, e.g. after a failed
+	// write left them out of step, and returns how many line subscriptions it holds.
+	RebuildLineIndex(ctx context.Context) (int, error)
+}
+
+// ScheduleRepository keeps the journeys booked for later, by schedule ID.
+type ScheduleRepository interface {
+	SaveScheduledJourney(ctx context.Context, journey common.ScheduledJourney) error
+	DeleteScheduledJourney(ctx context.Context, scheduleID string) error
+	ListScheduledJourneys(ctx context.Context) ([]common.ScheduledJourney, error)
+}
+
+// FareLedgerRepository keeps each user's fare ledger, so that daily caps survive restarts.
+type FareLedgerRepository interface {
+	// GetFareLedger returns ErrNotFound if the user has no ledger.
+	GetFareLedger(ctx context.Context, userID string) (*common.FareLedger, error)
+	SaveFareLedger(ctx context.Context, ledger common.FareLedger) error
+}
+
+// Repository is a storage backend for every kind of route, and the fare ledgers.
+type Repository interface {
+	ChosenRouteRepository
+	ActiveRouteRepository
+	ScheduleRepository
+	FareLedgerRepository
+	Close()
+}
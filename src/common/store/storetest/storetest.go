@@ -0,0 +1,407 @@
+// Package storetest is the conformance suite every store.Repository must pass. A backend's
+// tests call Run with a function that opens an empty repository:
+//
+//	func TestMemory(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Repository { return store.NewMemory() })
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Run checks a backend against the behaviour the services rely on. open must return an empty
+// repository every time it is called.
+func Run(t *testing.T, open func(t *testing.T) store.Repository) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, store.Repository)
+	}{
+		{"ChosenRouteMissing", testChosenRouteMissing},
+		{"ChosenRouteRoundTrip", testChosenRouteRoundTrip},
+		{"ChosenRouteReplace", testChosenRouteReplace},
+		{"ChosenRouteDelete", testChosenRouteDelete},
+		{"ChosenRouteList", testChosenRouteList},
+		{"ActiveRoutes", testActiveRoutes},
+		{"ActiveRouteDelete", testActiveRouteDelete},
+		{"LineIndex", testLineIndex},
+		{"RebuildLineIndex", testRebuildLineIndex},
+		{"ScheduledJourneys", testScheduledJourneys},
+		{"FareLedger", testFareLedger},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := open(t)
+			defer repo.Close()
+			tt.run(t, repo)
+		})
+	}
+}
+
+// at is a fixed time without monotonic reading or sub-second part, which every backend keeps.
+var at = time.Date(2025, 3, 14, 8, 30, 0, 0, time.UTC)
+
+func sampleRoute(userID string) common.ChosenRoute {
+	return common.ChosenRoute{
+		UserID:        userID,
+		TotalDuration: 27,
+		Description:   "Journey with 2 legs",
+		Legs: []common.RouteLeg{
+			{
+				From: "Brixton", FromID: "940GZZLUBXN", To: "Green Park", ToID: "940GZZLUGPK",
+				Mode: "tube", StartTime: "2025-03-14T08:30:00", EndTime: "2025-03-14T08:45:00",
+				Description: "Victoria line to Walthamstow Central", LineName: "Victoria", LineID: "victoria",
+				Stops:   []string{"Brixton", "Stockwell", "Vauxhall", "Pimlico", "Victoria", "Green Park"},
+				StopIDs: []string{"940GZZLUBXN", "940GZZLUSKW", "940GZZLUVXL", "940GZZLUPCO", "940GZZLUVIC", "940GZZLUGPK"},
+			},
+			{
+				From: "Green Park", FromID: "940GZZLUGPK", To: "Bond Street", ToID: "940GZZLUBND",
+				Mode: "tube", StartTime: "2025-03-14T08:48:00", EndTime: "2025-03-14T08:57:00",
+				Description: "Jubilee line to Stanmore", LineName: "Jubilee", LineID: "jubilee", Segment: 1,
+				Stops:   []string{"Green Park", "Bond Street"},
+				StopIDs: []string{"940GZZLUGPK", "940GZZLUBND"},
+			},
+		},
+		Waypoints:      []common.RouteWaypoint{{Name: "Green Park", Naptan: "940GZZLUGPK", DwellMins: 5}},
+		JourneyID:      "a1b2c3d4",
+		StartedAt:      at,
+		PlannedArrival: at.Add(27 * time.Minute),
+		Recalculations: []common.RecalculationEvent{
+			{At: at.Add(5 * time.Minute), Reason: "Severe delays", Lines: []string{"northern"}, Previous: "Journey with 3 legs", MinutesLost: 4},
+		},
+	}
+}
+
+func sameRoute(t *testing.T, got *common.ChosenRoute, want common.ChosenRoute) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("got no route, want %+v", want)
+	}
+	if !got.StartedAt.Equal(want.StartedAt) || !got.PlannedArrival.Equal(want.PlannedArrival) {
+		t.Errorf("times: got %v and %v, want %v and %v", got.StartedAt, got.PlannedArrival, want.StartedAt, want.PlannedArrival)
+	}
+	if len(got.Recalculations) != len(want.Recalculations) {
+		t.Fatalf("got %d recalculations, want %d", len(got.Recalculations), len(want.Recalculations))
+	}
+	for i := range want.Recalculations {
+		if !got.Recalculations[i].At.Equal(want.Recalculations[i].At) {
+			t.Errorf("recalculation %d at %v, want %v", i, got.Recalculations[i].At, want.Recalculations[i].At)
+		}
+		got.Recalculations[i].At = want.Recalculations[i].At
+	}
+	got.StartedAt, got.PlannedArrival = want.StartedAt, want.PlannedArrival
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("got route %+v, want %+v", *got, want)
+	}
+}
+
+func testChosenRouteMissing(t *testing.T, repo store.Repository) {
+	route, err := repo.GetChosenRoute(context.Background(), "nobody")
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("got route %v and error %v, want store.ErrNotFound", route, err)
+	}
+}
+
+func testChosenRouteRoundTrip(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	want := sampleRoute("alice")
+	if err := repo.SaveChosenRoute(ctx, want); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	got, err := repo.GetChosenRoute(ctx, "alice")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	sameRoute(t, got, sampleRoute("alice"))
+
+	// The stored route must not change with the copy handed out.
+	got.Legs[0].LineID = "northern"
+	again, err := repo.GetChosenRoute(ctx, "alice")
+	if err != nil {
+		t.Fatalf("get again: %v", err)
+	}
+	if again.Legs[0].LineID != "victoria" {
+		t.Errorf("stored route changed with the copy returned")
+	}
+}
+
+func testChosenRouteReplace(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	if err := repo.SaveChosenRoute(ctx, sampleRoute("alice")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	next := sampleRoute("alice")
+	next.Legs = next.Legs[:1]
+	next.Waypoints = nil
+	next.Description = "Journey with 1 legs"
+	if err := repo.SaveChosenRoute(ctx, next); err != nil {
+		t.Fatalf("save replacement: %v", err)
+	}
+	if err := repo.SaveChosenRoute(ctx, sampleRoute("bob")); err != nil {
+		t.Fatalf("save other user: %v", err)
+	}
+
+	got, err := repo.GetChosenRoute(ctx, "alice")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Description != next.Description || len(got.Legs) != 1 || len(got.Waypoints) != 0 {
+		t.Errorf("got %q with %d legs and %d waypoints, want the replacement", got.Description, len(got.Legs), len(got.Waypoints))
+	}
+}
+
+func testChosenRouteDelete(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	if err := repo.SaveChosenRoute(ctx, sampleRoute("alice")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := repo.SaveChosenRoute(ctx, sampleRoute("bob")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := repo.DeleteChosenRoute(ctx, "alice"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := repo.GetChosenRoute(ctx, "alice"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("deleted route: got error %v, want store.ErrNotFound", err)
+	}
+	if _, err := repo.GetChosenRoute(ctx, "bob"); err != nil {
+		t.Errorf("route of another user was deleted: %v", err)
+	}
+	if err := repo.DeleteChosenRoute(ctx, "alice"); err != nil {
+		t.Errorf("deleting a missing route: %v", err)
+	}
+}
+
+func testChosenRouteList(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	routes, err := repo.ListChosenRoutes(ctx)
+	if err != nil || len(routes) != 0 {
+		t.Fatalf("empty store: got %v and error %v", routes, err)
+	}
+
+	for _, userID := range []string{"bob", "alice"} {
+		if err = repo.SaveChosenRoute(ctx, sampleRoute(userID)); err != nil {
+			t.Fatalf("save %s: %v", userID, err)
+		}
+	}
+	routes, err = repo.ListChosenRoutes(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(routes) != 2 || routes[0].UserID != "alice" || routes[1].UserID != "bob" {
+		t.Fatalf("got %d routes, want those of alice and bob in order", len(routes))
+	}
+	sameRoute(t, &routes[0], sampleRoute("alice"))
+}
+
+func testActiveRoutes(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	routes, err := repo.ListActiveRoutes(ctx)
+	if err != nil || len(routes) != 0 {
+		t.Fatalf("empty store: got %v and error %v", routes, err)
+	}
+
+	for _, route := range []common.ActiveRoute{
+		{UserID: "alice", LineIDs: []string{"victoria", "jubilee"}},
+		{UserID: "bob", LineIDs: []string{"northern"}},
+		{UserID: "alice", LineIDs: []string{"central"}},
+	} {
+		if err = repo.PutActiveRoute(ctx, route); err != nil {
+			t.Fatalf("put %s: %v", route.UserID, err)
+		}
+	}
+
+	routes, err = repo.ListActiveRoutes(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	got := make(map[string][]string)
+	for _, route := range routes {
+		got[route.UserID] = route.LineIDs
+	}
+	want := map[string][]string{"alice": {"central"}, "bob": {"northern"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got active routes %v, want %v", got, want)
+	}
+}
+
+func testActiveRouteDelete(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	if err := repo.PutActiveRoute(ctx, common.ActiveRoute{UserID: "alice", LineIDs: []string{"victoria"}}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	deleted, err := repo.DeleteActiveRoute(ctx, "alice")
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if deleted == nil || deleted.UserID != "alice" || !reflect.DeepEqual(deleted.LineIDs, []string{"victoria"}) {
+		t.Errorf("got deleted route %+v, want alice on victoria", deleted)
+	}
+
+	deleted, err = repo.DeleteActiveRoute(ctx, "alice")
+	if err != nil || deleted != nil {
+		t.Errorf("deleting a missing route: got %+v and error %v, want nil and no error", deleted, err)
+	}
+	if routes, _ := repo.ListActiveRoutes(ctx); len(routes) != 0 {
+		t.Errorf("got %d active routes after deleting, want none", len(routes))
+	}
+}
+
+// usersOn checks the users the line index holds for each line.
+func usersOn(t *testing.T, repo store.Repository, want map[string][]string) {
+	t.Helper()
+	for lineID, users := range want {
+		got, err := repo.UsersOnLine(context.Background(), lineID)
+		if err != nil {
+			t.Fatalf("users on %s: %v", lineID, err)
+		}
+		sort.Strings(got)
+		if len(got) != len(users) || (len(users) > 0 && !reflect.DeepEqual(got, users)) {
+			t.Errorf("users on %s: got %v, want %v", lineID, got, users)
+		}
+	}
+}
+
+func testLineIndex(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	usersOn(t, repo, map[string][]string{"victoria": nil})
+
+	for _, route := range []common.ActiveRoute{
+		{UserID: "alice", LineIDs: []string{"victoria", "jubilee"}},
+		{UserID: "bob", LineIDs: []string{"victoria", "northern"}},
+		{UserID: "carol", LineIDs: []string{"jubilee"}},
+	} {
+		if err := repo.PutActiveRoute(ctx, route); err != nil {
+			t.Fatalf("put %s: %v", route.UserID, err)
+		}
+	}
+	usersOn(t, repo, map[string][]string{
+		"victoria": {"alice", "bob"},
+		"jubilee":  {"alice", "carol"},
+		"northern": {"bob"},
+		"central":  nil,
+	})
+
+	// Alice recalculates onto the Central line and Bob finishes his journey.
+	if err := repo.PutActiveRoute(ctx, common.ActiveRoute{UserID: "alice", LineIDs: []string{"central", "jubilee"}}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if _, err := repo.DeleteActiveRoute(ctx, "bob"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	usersOn(t, repo, map[string][]string{
+		"victoria": nil,
+		"jubilee":  {"alice", "carol"},
+		"northern": nil,
+		"central":  {"alice"},
+	})
+}
+
+func testRebuildLineIndex(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	if n, err := repo.RebuildLineIndex(ctx); err != nil || n != 0 {
+		t.Fatalf("empty store: got %d subscriptions and error %v, want 0", n, err)
+	}
+
+	for _, route := range []common.ActiveRoute{
+		{UserID: "alice", LineIDs: []string{"victoria", "jubilee"}},
+		{UserID: "bob", LineIDs: []string{"victoria"}},
+	} {
+		if err := repo.PutActiveRoute(ctx, route); err != nil {
+			t.Fatalf("put %s: %v", route.UserID, err)
+		}
+	}
+	n, err := repo.RebuildLineIndex(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("rebuild: got %d subscriptions and error %v, want 3", n, err)
+	}
+	usersOn(t, repo, map[string][]string{
+		"victoria": {"alice", "bob"},
+		"jubilee":  {"alice"},
+	})
+}
+
+func testScheduledJourneys(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	plan := &common.RouteAlternative{Rank: 1, Score: 31.5, Duration: 27, Lines: []string{"Victoria"}, Summary: "Victoria line to Walthamstow Central"}
+	journeys := []common.ScheduledJourney{
+		{ScheduleID: "s1", UserID: "alice", Request: common.UserRequest{UserID: "alice", StartPoint: "940GZZLUBXN", EndPoint: "940GZZLUGPK", ArriveBy: at},
+			From: "Brixton", To: "Green Park", Status: common.ScheduleWaiting, LeaveAt: at.Add(-20 * time.Minute), ArriveAt: at, Plan: plan, PlannedAt: at.Add(-time.Hour), CreatedAt: at.Add(-time.Hour)},
+		{ScheduleID: "s2", UserID: "bob", Request: common.UserRequest{UserID: "bob", StartPoint: "940GZZLUKSX", EndPoint: "940GZZLUWLO", Departure: at},
+			From: "King's Cross St. Pancras", To: "Waterloo", Status: common.ScheduleMonitoring, LeaveAt: at, CreatedAt: at.Add(-2 * time.Hour)},
+	}
+	for _, journey := range journeys {
+		if err := repo.SaveScheduledJourney(ctx, journey); err != nil {
+			t.Fatalf("save %s: %v", journey.ScheduleID, err)
+		}
+	}
+	monitored := journeys[0]
+	monitored.Status = common.ScheduleMonitoring
+	if err := repo.SaveScheduledJourney(ctx, monitored); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	listed, err := repo.ListScheduledJourneys(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	byID := make(map[string]common.ScheduledJourney)
+	for _, journey := range listed {
+		byID[journey.ScheduleID] = journey
+	}
+	if len(listed) != 2 || byID["s1"].Status != common.ScheduleMonitoring || byID["s2"].UserID != "bob" {
+		t.Fatalf("got scheduled journeys %+v, want s1 monitored and s2", listed)
+	}
+	if got := byID["s1"]; !got.LeaveAt.Equal(monitored.LeaveAt) || !got.Request.ArriveBy.Equal(at) || got.Plan == nil || got.Plan.Score != plan.Score {
+		t.Errorf("got %+v, want the journey as saved", got)
+	}
+
+	if err = repo.DeleteScheduledJourney(ctx, "s1"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err = repo.DeleteScheduledJourney(ctx, "s1"); err != nil {
+		t.Errorf("deleting a missing journey: %v", err)
+	}
+	listed, err = repo.ListScheduledJourneys(ctx)
+	if err != nil || len(listed) != 1 || listed[0].ScheduleID != "s2" {
+		t.Errorf("after deleting s1: got %+v and error %v, want s2 only", listed, err)
+	}
+}
+
+func testFareLedger(t *testing.T, repo store.Repository) {
+	ctx := context.Background()
+	if ledger, err := repo.GetFareLedger(ctx, "alice"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("empty store: got ledger %v and error %v, want store.ErrNotFound", ledger, err)
+	}
+
+	first := common.FareLedger{UserID: "alice", Day: "2025-03-14", Trips: []common.FareTrip{
+		{Pence: 290, ZoneFrom: 1, ZoneTo: 2, Modes: []string{"tube", "walking"}},
+	}}
+	if err := repo.SaveFareLedger(ctx, first); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	next := first
+	next.Trips = append(append([]common.FareTrip(nil), first.Trips...), common.FareTrip{Pence: 175, Modes: []string{"bus"}})
+	if err := repo.SaveFareLedger(ctx, next); err != nil {
+		t.Fatalf("save replacement: %v", err)
+	}
+	if err := repo.SaveFareLedger(ctx, common.FareLedger{UserID: "bob", Day: "2025-03-13"}); err != nil {
+		t.Fatalf("save other user: %v", err)
+	}
+
+	got, err := repo.GetFareLedger(ctx, "alice")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !reflect.DeepEqual(*got, next) {
+		t.Errorf("got ledger %+v, want %+v", *got, next)
+	}
+}
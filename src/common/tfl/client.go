@@ -0,0 +1,229 @@
+// Package tfl is the single entry point to the TfL Unified API used by every service.
+// The live client talks to api.tfl.gov.uk, the recording client additionally saves every
+// response to disk and the replay client serves those saved fixtures without network access.
+package tfl
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // London times must parse correctly in minimal containers
+)
+
+const (
+	Departing = "Departing"
+	Arriving  = "Arriving"
+
+	// Journey Planner accessibility preferences.
+	StepFreeToVehicle  = "StepFreeToVehicle"
+	StepFreeToPlatform = "StepFreeToPlatform"
+	NoSolidStairs      = "NoSolidStairs"
+
+	BaseURL   = "https://api.tfl.gov.uk"
+	userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36"
+)
+
+// London is the time zone of every time the TfL API accepts and returns.
+var London = mustLoadLocation("Europe/London")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load time zone %s: %v", name, err))
+	}
+	return loc
+}
+
+// Client is implemented by LiveClient, RecordingClient and ReplayClient.
+type Client interface {
+	Journey(q JourneyQuery) (*common.TFLJourneyResponse, error)
+	Crowding(naptan, weekday string) (*common.CrowdingResp, error)
+	LineStatus(modes ...string) (*common.TfLLineStatusResponse, error)
+	StopDisruptions(modes ...string) ([]common.DisruptedPoint, error)
+}
+
+// Modes are the transport modes the Journey Planner may use when a query does not restrict them.
+var Modes = []string{"tube", "dlr", "overground", "elizabeth-line", "tram", "bus", "national-rail", "cable-car", "river-bus", "walking"}
+
+// JourneyQuery describes a Journey Planner request between two NaPTAN stop points.
+type JourneyQuery struct {
+	From          string
+	To            string
+	Time          time.Time
+	TimeIs        string   // Departing (default) or Arriving
+	Modes         []string // modes to travel by, TfL's default selection when empty
+	Accessibility []string // accessibilityPreference values, e.g. StepFreeToVehicle
+}
+
+func (q JourneyQuery) path() string {
+	return fmt.Sprintf("/Journey/JourneyResults/%s/to/%s", q.From, q.To)
+}
+
+func (q JourneyQuery) params() url.Values {
+	timeIs := q.TimeIs
+	if timeIs == "" {
+		timeIs = Departing
+	}
+	at := q.Time.In(London)
+	params := url.Values{
+		"date":   {at.Format("20060102")},
+		"time":   {at.Format("1504")},
+		"timeIs": {timeIs},
+	}
+	if len(q.Modes) > 0 {
+		params.Set("mode", strings.Join(q.Modes, ","))
+	}
+	if len(q.Accessibility) > 0 {
+		params.Set("accessibilityPreference", strings.Join(q.Accessibility, ","))
+	}
+	return params
+}
+
+// getFunc fetches the raw body of a TfL API resource.
+type getFunc func(path string, params url.Values) ([]byte, error)
+
+func journey(get getFunc, q JourneyQuery) (*common.TFLJourneyResponse, error) {
+	body, err := get(q.path(), q.params())
+	if err != nil {
+		return nil, err
+	}
+	var result common.TFLJourneyResponse
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("error decoding journey response: %w", err)
+	}
+	return &result, nil
+}
+
+func crowding(get getFunc, naptan, weekday string) (*common.CrowdingResp, error) {
+	body, err := get(fmt.Sprintf("/crowding/%s/%s", naptan, weekday), url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	var result common.CrowdingResp
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("error decoding crowding response: %w", err)
+	}
+	return &result, nil
+}
+
+func lineStatus(get getFunc, modes []string) (*common.TfLLineStatusResponse, error) {
+	body, err := get(fmt.Sprintf("/Line/Mode/%s/Status", strings.Join(modes, ",")), url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	var result common.TfLLineStatusResponse
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("error decoding line status response: %w", err)
+	}
+	return &result, nil
+}
+
+func stopDisruptions(get getFunc, modes []string) ([]common.DisruptedPoint, error) {
+	body, err := get(fmt.Sprintf("/StopPoint/Mode/%s/Disruption", strings.Join(modes, ",")), url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	var result []common.DisruptedPoint
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("error decoding stop point disruptions: %w", err)
+	}
+	return result, nil
+}
+
+// LiveClient calls the TfL API over HTTP.
+type LiveClient struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+func NewLiveClient(apiKey string) *LiveClient {
+	return &LiveClient{
+		baseURL: BaseURL,
+		apiKey:  apiKey,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *LiveClient) Journey(q JourneyQuery) (*common.TFLJourneyResponse, error) {
+	return journey(c.get, q)
+}
+
+func (c *LiveClient) Crowding(naptan, weekday string) (*common.CrowdingResp, error) {
+	return crowding(c.get, naptan, weekday)
+}
+
+func (c *LiveClient) LineStatus(modes ...string) (*common.TfLLineStatusResponse, error) {
+	return lineStatus(c.get, modes)
+}
+
+func (c *LiveClient) StopDisruptions(modes ...string) ([]common.DisruptedPoint, error) {
+	return stopDisruptions(c.get, modes)
+}
+
+func (c *LiveClient) get(path string, params url.Values) ([]byte, error) {
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	if c.apiKey != "" {
+		query.Set("app_key", c.apiKey)
+	}
+
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Add("User-Agent", userAgent)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling TfL API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TfL API %s returned status %s", path, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading TfL response: %w", err)
+	}
+	return body, nil
+}
+
+// NewFromEnv picks the implementation from TFL_MODE ("live", "record" or "replay").
+// Recording and replay use the fixture directory in TFL_FIXTURES.
+func NewFromEnv() (Client, error) {
+	apiKey := os.Getenv("TFL_API_KEY")
+	dir := os.Getenv("TFL_FIXTURES")
+
+	switch mode := os.Getenv("TFL_MODE"); mode {
+	case "", "live":
+		return NewLiveClient(apiKey), nil
+	case "record":
+		if dir == "" {
+			return nil, fmt.Errorf("TFL_FIXTURES must be set when TFL_MODE=record")
+		}
+		return NewRecordingClient(NewLiveClient(apiKey), dir), nil
+	case "replay":
+		if dir == "" {
+			return nil, fmt.Errorf("TFL_FIXTURES must be set when TFL_MODE=replay")
+		}
+		return NewReplayClient(dir), nil
+	default:
+		return nil, fmt.Errorf("unknown TFL_MODE '%s'", mode)
+	}
+}
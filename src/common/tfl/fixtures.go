@@ -0,0 +1,113 @@
+package tfl
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Fixtures are stored as one file per request: the sanitised path followed by a hash of the
+// query parameters. A second "<path>.latest.json" copy lets replay answer requests whose
+// parameters (typically the departure time) differ from the ones that were recorded.
+
+func fixtureBase(path string) string {
+	replacer := strings.NewReplacer("/", "_", ",", "-", ":", "-")
+	return strings.Trim(replacer.Replace(path), "_")
+}
+
+func fixtureName(path string, params url.Values) string {
+	sum := sha1.Sum([]byte(params.Encode()))
+	return fmt.Sprintf("%s.%s.json", fixtureBase(path), hex.EncodeToString(sum[:])[:10])
+}
+
+func latestFixtureName(path string) string {
+	return fixtureBase(path) + ".latest.json"
+}
+
+// RecordingClient forwards every request to a LiveClient and saves the response as a fixture.
+type RecordingClient struct {
+	live *LiveClient
+	dir  string
+}
+
+func NewRecordingClient(live *LiveClient, dir string) *RecordingClient {
+	return &RecordingClient{live: live, dir: dir}
+}
+
+func (c *RecordingClient) Journey(q JourneyQuery) (*common.TFLJourneyResponse, error) {
+	return journey(c.get, q)
+}
+
+func (c *RecordingClient) Crowding(naptan, weekday string) (*common.CrowdingResp, error) {
+	return crowding(c.get, naptan, weekday)
+}
+
+func (c *RecordingClient) LineStatus(modes ...string) (*common.TfLLineStatusResponse, error) {
+	return lineStatus(c.get, modes)
+}
+
+func (c *RecordingClient) StopDisruptions(modes ...string) ([]common.DisruptedPoint, error) {
+	return stopDisruptions(c.get, modes)
+}
+
+func (c *RecordingClient) get(path string, params url.Values) ([]byte, error) {
+	body, err := c.live.get(path, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(c.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	for _, name := range []string{fixtureName(path, params), latestFixtureName(path)} {
+		if err = os.WriteFile(filepath.Join(c.dir, name), body, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to record fixture %s: %w", name, err)
+		}
+	}
+
+	return body, nil
+}
+
+// ReplayClient serves previously recorded fixtures and never touches the network.
+type ReplayClient struct {
+	dir string
+}
+
+func NewReplayClient(dir string) *ReplayClient {
+	return &ReplayClient{dir: dir}
+}
+
+func (c *ReplayClient) Journey(q JourneyQuery) (*common.TFLJourneyResponse, error) {
+	return journey(c.get, q)
+}
+
+func (c *ReplayClient) Crowding(naptan, weekday string) (*common.CrowdingResp, error) {
+	return crowding(c.get, naptan, weekday)
+}
+
+func (c *ReplayClient) LineStatus(modes ...string) (*common.TfLLineStatusResponse, error) {
+	return lineStatus(c.get, modes)
+}
+
+func (c *ReplayClient) StopDisruptions(modes ...string) ([]common.DisruptedPoint, error) {
+	return stopDisruptions(c.get, modes)
+}
+
+func (c *ReplayClient) get(path string, params url.Values) ([]byte, error) {
+	for _, name := range []string{fixtureName(path, params), latestFixtureName(path)} {
+		body, err := os.ReadFile(filepath.Join(c.dir, name))
+		if err == nil {
+			return body, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read fixture %s: %w", name, err)
+		}
+	}
+	return nil, fmt.Errorf("no fixture recorded for TfL API %s", path)
+}
@@ -3,10 +3,64 @@ package common
 import "time"
 
 type UserRequest struct {
-	UserID     string    `json:"userID"`
-	StartPoint string    `json:"startPoint"`
-	EndPoint   string    `json:"endPoint"`
-	Departure  time.Time `json:"departure"`
+	UserID     string             `json:"userID"`
+	StartPoint string             `json:"startPoint"`
+	EndPoint   string             `json:"endPoint"`
+	Departure  time.Time          `json:"departure"`
+	ArriveBy   time.Time          `json:"arriveBy"`            // when set, plan to arrive by this time instead of departing at Departure
+	Weights    map[string]float64 `json:"weights,omitempty"`   // per-user overrides of the scoring weights
+	Avoid      Avoidance          `json:"avoid"`               // filled in from the user's stored preferences
+	Access     Accessibility      `json:"access"`              // filled in from the user's stored preferences
+	Waypoints  []Waypoint         `json:"waypoints,omitempty"` // visited in order between StartPoint and EndPoint
+}
+
+// Waypoint is an intermediate stop of a multi-stop journey, e.g. a school drop-off on the way to work.
+type Waypoint struct {
+	Station   string `json:"station"`
+	DwellMins int    `json:"dwellMins,omitempty"` // time spent at the waypoint before setting off again
+}
+
+// RouteWaypoint is a resolved Waypoint of a planned route. Waypoint i is where segment i ends.
+type RouteWaypoint struct {
+	Name      string `json:"name" dynamodbav:"name"`
+	Naptan    string `json:"naptan" dynamodbav:"naptan"`
+	DwellMins int    `json:"dwellMins" dynamodbav:"dwellMins"`
+}
+
+// Accessibility profiles.
+const (
+	AccessStepFree         = "step-free"         // step-free from street to train
+	AccessNoStairs         = "no-stairs"         // lifts and escalators are fine, fixed stairs are not
+	AccessExtraInterchange = "extra-interchange" // no physical restriction, but changes take longer
+)
+
+// Accessibility describes a user's mobility needs.
+type Accessibility struct {
+	Profile              string `json:"profile,omitempty"`
+	ExtraInterchangeMins int    `json:"extraInterchangeMins,omitempty"` // added to every change, whatever the profile
+}
+
+// Avoidance lists what a user never wants to travel on. Stations are avoided as places to
+// board, alight or change; riding through them is allowed.
+type Avoidance struct {
+	Lines     []string `json:"lines,omitempty"`     // TfL line IDs, e.g. "northern"
+	PeakLines []string `json:"peakLines,omitempty"` // lines avoided only during peak hours
+	Modes     []string `json:"modes,omitempty"`     // TfL mode names, e.g. "bus"
+	Stations  []string `json:"stations,omitempty"`  // station names or NaPTAN codes
+}
+
+type UserPreferences struct {
+	UserID string        `json:"userID"`
+	Avoid  Avoidance     `json:"avoid"`
+	Access Accessibility `json:"access"`
+}
+
+// RejectedAlternative is a journey that was left out because it conflicts with the user's avoidance or accessibility preferences.
+type RejectedAlternative struct {
+	Duration int      `json:"duration"`
+	Lines    []string `json:"lines"`
+	Summary  string   `json:"summary"`
+	Reasons  []string `json:"reasons"`
 }
 
 type RouteLookup struct {
@@ -24,6 +78,29 @@ type UserSavedRoute struct {
 	EstimatedTime int      `json:"estimatedTime"`
 	LineNames     []string `json:"lineNames"`
 	StopsNames    []string `json:"stopsNames"`
+	// The route as it was chosen. Leg times are only meaningful relative to the first departure.
+	Legs      []RouteLeg      `json:"legs,omitempty"`
+	Waypoints []RouteWaypoint `json:"waypoints,omitempty"`
+}
+
+// CommuteWatch attaches a recurring time window to a saved route. During the window the route's
+// lines are watched for delays as if the route were active.
+type CommuteWatch struct {
+	WatchID  string   `json:"watchID"`
+	UserID   string   `json:"userID"`
+	RouteID  string   `json:"routeID"`
+	Weekdays []string `json:"weekdays"` // "MON" to "SUN"
+	Start    string   `json:"start"`    // "08:00", London time
+	End      string   `json:"end"`      // before Start for windows spanning midnight
+	// Filled in from the saved route.
+	StartPoint string   `json:"startPoint,omitempty"`
+	EndPoint   string   `json:"endPoint,omitempty"`
+	Lines      []string `json:"lines,omitempty"`
+}
+
+type WatchLookup struct {
+	UserID  string `json:"userID"`
+	WatchID string `json:"watchID"`
 }
 
 type ActiveRoute struct {
@@ -31,14 +108,137 @@ type ActiveRoute struct {
 	LineIDs []string `dynamodbav:"lineIDs" json:"lineIDs"`
 }
 
+// FareLedger is what a user spent on fares on their last day of travel, kept for daily capping.
+type FareLedger struct {
+	UserID string     `json:"userID" dynamodbav:"userID"`
+	Day    string     `json:"day" dynamodbav:"day"` // in London, as 2006-01-02
+	Trips  []FareTrip `json:"trips" dynamodbav:"trips"`
+}
+
+// FareTrip is a journey of a fare ledger, with the zones and modes that decide the daily cap.
+type FareTrip struct {
+	Pence    int      `json:"pence" dynamodbav:"pence"`
+	ZoneFrom int      `json:"zoneFrom" dynamodbav:"zoneFrom"` // 0 when no zonal mode was used
+	ZoneTo   int      `json:"zoneTo" dynamodbav:"zoneTo"`
+	Modes    []string `json:"modes" dynamodbav:"modes"`
+}
+
 type RouteResult struct {
-	From    string  `json:"from"`
-	To      string  `json:"to"`
-	Score   float64 `json:"score"`
-	Summary string  `json:"summary"`
+	From         string                `json:"from"`
+	To           string                `json:"to"`
+	Score        float64               `json:"score"`
+	Fare         float64               `json:"fare"` // estimated fare of the best route in pounds
+	Summary      string                `json:"summary"`
+	Breakdown    []ScoreComponent      `json:"breakdown,omitempty"`
+	Alternatives []RouteAlternative    `json:"alternatives,omitempty"`
+	Rejected     []RejectedAlternative `json:"rejected,omitempty"` // left out because of the user's avoidance preferences
+	// Set instead of Alternatives when the start or end point matched several stations.
+	StartCandidates []StationMatch `json:"startCandidates,omitempty"`
+	EndCandidates   []StationMatch `json:"endCandidates,omitempty"`
+	// Set by a recalculation that found no meaningfully better route; Reason explains why.
+	Kept   bool   `json:"kept,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Set when a saved route is worse than usual today: its rank among the Alternatives offered
+	// instead, or 0 if it could not be compared.
+	SavedRank int `json:"savedRank,omitempty"`
+}
+
+// Scheduled journey states.
+const (
+	ScheduleWaiting    = "waiting"    // planned when booked, not re-planned yet
+	ScheduleMonitoring = "monitoring" // re-planned before departure, watched until the rider leaves
+)
+
+// ScheduledJourney is a trip booked for later. The route planner re-plans it shortly before
+// departure, pushes the final recommendation and warns the rider if they should leave earlier or later.
+type ScheduledJourney struct {
+	ScheduleID string            `json:"scheduleID" dynamodbav:"scheduleID"`
+	UserID     string            `json:"userID" dynamodbav:"userID"`
+	Request    UserRequest       `json:"request" dynamodbav:"request"` // Departure or ArriveBy is the booked time
+	From       string            `json:"from" dynamodbav:"from"`
+	To         string            `json:"to" dynamodbav:"to"`
+	Status     string            `json:"status" dynamodbav:"status"`
+	LeaveAt    time.Time         `json:"leaveAt" dynamodbav:"leaveAt"` // when the latest recommendation sets off
+	ArriveAt   time.Time         `json:"arriveAt" dynamodbav:"arriveAt"`
+	Plan       *RouteAlternative `json:"plan,omitempty" dynamodbav:"plan,omitempty"` // latest recommendation
+	PlannedAt  time.Time         `json:"plannedAt" dynamodbav:"plannedAt"`
+	CreatedAt  time.Time         `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+type ScheduleLookup struct {
+	UserID     string `json:"userID"`
+	ScheduleID string `json:"scheduleID"`
+}
+
+type StationMatch struct {
+	Name   string  `json:"name"`
+	Naptan string  `json:"naptan"`
+	Score  float64 `json:"score"`
+}
+
+type StationQuery struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+}
+
+// RouteAlternative is one ranked candidate journey offered to the user before a route is committed.
+type RouteAlternative struct {
+	Rank      int              `json:"rank"`
+	Score     float64          `json:"score"`
+	Breakdown []ScoreComponent `json:"breakdown"`
+	Duration  int              `json:"duration"`
+	Changes   int              `json:"changes"`
+	Lines     []string         `json:"lines"`
+	Summary   string           `json:"summary"`
+	Legs      []RouteLeg       `json:"legs"`
+	Waypoints []RouteWaypoint  `json:"waypoints,omitempty"`
+	Fare      float64          `json:"fare"`             // estimated pay as you go fare in pounds, after the daily cap
+	Capped    bool             `json:"capped,omitempty"` // the daily cap lowered the fare
+}
+
+// ScoreComponent explains how much a single criterion contributed to a route's score.
+type ScoreComponent struct {
+	Criterion    string  `json:"criterion"`
+	Value        float64 `json:"value"`
+	Weight       float64 `json:"weight"`
+	Contribution float64 `json:"contribution"`
+}
+
+// RouteSelection confirms which ranked alternative becomes the user's ChosenRoute.
+type RouteSelection struct {
+	UserID string `json:"userID"`
+	Rank   int    `json:"rank"`
+}
+
+// Journey progress segments.
+const (
+	SegmentRide = "ride"
+	SegmentWalk = "walk"
+	SegmentWait = "wait"
+)
+
+// CheckIn is a rider reporting the station they are at ("I'm at X").
+type CheckIn struct {
+	UserID  string `json:"userID"`
+	Station string `json:"station"`
+}
+
+// JourneyPosition is where a rider is estimated to be on their active route. StopIndex points
+// into the leg's StopIDs and is -1 while walking; StopID is the stop to re-plan from.
+type JourneyPosition struct {
+	LegIndex   int       `json:"legIndex"`
+	StopIndex  int       `json:"stopIndex"`
+	StopID     string    `json:"stopId"`
+	StopName   string    `json:"stopName"`
+	Segment    string    `json:"segment"`
+	Confidence float64   `json:"confidence"`
+	CheckedIn  bool      `json:"checkedIn"`
+	DelayMins  float64   `json:"delayMins"`
+	EstimateAt time.Time `json:"estimateAt"`
 }
 
 type TfLAlert struct {
+	LineID            string    `json:"lineId,omitempty"` // canonical line ID, empty if the line is unknown
 	LineName          string    `json:"lineName"`
 	ModeName          string    `json:"modeName"`
 	StatusDescription string    `json:"statusDescription,omitempty"` // Omit if empty
@@ -47,9 +247,30 @@ type TfLAlert struct {
 	Timestamp         time.Time `json:"timestamp"`
 }
 
+// LineReliability is the historical probability that a line is disrupted during one time band
+// of one weekday, as observed in the tfl_line_status measurement.
+type LineReliability struct {
+	LineID      string  `json:"lineId"`
+	Weekday     string  `json:"weekday"`  // MON, TUE, ...
+	TimeBand    string  `json:"timeBand"` // London time, e.g. "08:30-08:45"
+	Probability float64 `json:"probability"`
+	Samples     int     `json:"samples"`
+}
+
+type ReliabilityQuery struct {
+	LineIDs []string `json:"lineIds,omitempty"` // all lines when empty
+}
+
+type ReliabilityReport struct {
+	GeneratedAt time.Time         `json:"generatedAt"`
+	Since       time.Time         `json:"since"`
+	Bands       []LineReliability `json:"bands"`
+}
+
 // NotificationPayload wraps the alert type and a list of alerts.
 type NotificationPayload struct {
-	AlertType   string     `json:"alertType"` // e.g., "CriticalDelay", "SuddenServiceWorsening"
+	AlertType   string     `json:"alertType"`          // e.g., "CriticalDelay", "SuddenServiceWorsening"
+	Severity    string     `json:"severity,omitempty"` // "critical", "warning" or "info"
 	Alerts      []TfLAlert `json:"alerts"`
 	GeneratedAt time.Time  `json:"generatedAt"`
 }
@@ -77,16 +298,31 @@ type ValidityPeriod struct {
 	IsNow    bool
 }
 
+// DisruptedPoint is a TfL stop point disruption, such as a lift out of service.
+type DisruptedPoint struct {
+	AtcoCode        string `json:"atcoCode"`
+	StationAtcoCode string `json:"stationAtcoCode"`
+	CommonName      string `json:"commonName"`
+	Description     string `json:"description"`
+	Type            string `json:"type"`
+	Mode            string `json:"mode"`
+	FromDate        string `json:"fromDate"`
+	ToDate          string `json:"toDate"`
+}
+
 type TFLJourneyResponse struct {
 	Journeys []TFLJourney `json:"journeys"`
 }
 
 type TFLJourney struct {
-	Duration int      `json:"duration"`
-	Legs     []TFLLeg `json:"legs"`
+	StartDateTime   string   `json:"startDateTime"`
+	ArrivalDateTime string   `json:"arrivalDateTime"`
+	Duration        int      `json:"duration"`
+	Legs            []TFLLeg `json:"legs"`
 }
 
 type TFLLeg struct {
+	Duration       int           `json:"duration"`
 	DepartureTime  string        `json:"departureTime"`
 	ArrivalTime    string        `json:"arrivalTime"`
 	DeparturePoint StopPoint     `json:"departurePoint"`
@@ -135,6 +371,70 @@ type ChosenRoute struct {
 	TotalDuration int        `dynamodbav:"totalDuration"`
 	Description   string     `dynamodbav:"description"`
 	Legs          []RouteLeg `dynamodbav:"legs"`
+	// Waypoints of a multi-stop route, in order. Legs[i].Segment tells which one a leg leads to.
+	Waypoints []RouteWaypoint `dynamodbav:"waypoints,omitempty"`
+	// The journey the route belongs to, kept across recalculations for the history.
+	JourneyID      string               `dynamodbav:"journeyID,omitempty"`
+	StartedAt      time.Time            `dynamodbav:"startedAt,omitempty"`
+	PlannedArrival time.Time            `dynamodbav:"plannedArrival,omitempty"` // of the route first chosen
+	Recalculations []RecalculationEvent `dynamodbav:"recalculations,omitempty"`
+}
+
+// RecalculationEvent records a journey switching to a new route.
+type RecalculationEvent struct {
+	At          time.Time `json:"at" dynamodbav:"at"`
+	Reason      string    `json:"reason" dynamodbav:"reason"`
+	Lines       []string  `json:"lines,omitempty" dynamodbav:"lines,omitempty"` // disrupted lines behind it
+	Previous    string    `json:"previous" dynamodbav:"previous"`               // description of the route left
+	MinutesLost float64   `json:"minutesLost" dynamodbav:"minutesLost"`         // how much later the new route arrives
+}
+
+// Journey outcomes.
+const (
+	JourneyCompleted = "completed"
+	JourneyAborted   = "aborted" // terminated before reaching the destination
+)
+
+// JourneyRecord is a finished journey, as archived in the user's history.
+type JourneyRecord struct {
+	JourneyID      string               `json:"journeyID"`
+	UserID         string               `json:"userID"`
+	Outcome        string               `json:"outcome"`
+	Reason         string               `json:"reason"` // given when terminating
+	From           string               `json:"from"`
+	To             string               `json:"to"`
+	StartedAt      time.Time            `json:"startedAt"`
+	EndedAt        time.Time            `json:"endedAt"`
+	PlannedArrival time.Time            `json:"plannedArrival"`
+	DelayMins      float64              `json:"delayMins"`    // how late the rider was on the route first chosen
+	TimeLostMins   float64              `json:"timeLostMins"` // lost to disruptions, through recalculations
+	Lines          []string             `json:"lines"`        // of the route the journey ended on
+	Legs           []RouteLeg           `json:"legs"`
+	Recalculations []RecalculationEvent `json:"recalculations,omitempty"`
+}
+
+type StatsQuery struct {
+	UserID string `json:"userID"`
+	Weeks  int    `json:"weeks"` // 0 for the whole history
+}
+
+type LineUsage struct {
+	Line  string `json:"line"`
+	Trips int    `json:"trips"`
+}
+
+// TravelStats summarises a user's journey history.
+type TravelStats struct {
+	UserID       string      `json:"userID"`
+	Since        time.Time   `json:"since"`
+	Journeys     int         `json:"journeys"`
+	Completed    int         `json:"completed"`
+	Aborted      int         `json:"aborted"`
+	Recalculated int         `json:"recalculated"` // journeys that switched route at least once
+	TripsPerWeek float64     `json:"tripsPerWeek"`
+	AvgDelayMins float64     `json:"avgDelayMins"`
+	TimeLostMins float64     `json:"timeLostMins"`
+	TopLines     []LineUsage `json:"topLines"`
 }
 
 type RouteLeg struct {
@@ -150,6 +450,8 @@ type RouteLeg struct {
 	LineID      string   `json:"lineId"`
 	Stops       []string `json:"stops"`
 	StopIDs     []string `json:"stopIds"`
+	Segment     int      `json:"segment"`          // segment of a multi-stop route, 0 for the first or only one
+	Status      string   `json:"status,omitempty"` // live status of the line, set on the alternatives offered
 }
 
 type TimeBandCrowding struct {
@@ -179,8 +481,9 @@ type SavedResp struct {
 }
 
 type NewRequest struct {
-	UserID string `json:"userID"`
-	Reason string `json:"reason"`
+	UserID string   `json:"userID"`
+	Reason string   `json:"reason"`
+	Lines  []string `json:"lines,omitempty"` // IDs of the disrupted lines behind the request, if any
 }
 
 type Auth struct {
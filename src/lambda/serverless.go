@@ -2,15 +2,13 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-lambda-go/lambda"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
-	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
 	"log"
-	"net/http"
 	"os"
 	"time"
 )
@@ -23,16 +21,19 @@ var influxOrg string
 var influxBucket string
 var influxDBToken string
 
-var tflAPIKey string
+var tflClient tfl.Client
 
 func init() {
 	log.Println("Lambda cold start: Initializing...")
 
-	tflAPIKey = os.Getenv("TFL_API_KEY")
-	if tflAPIKey == "" {
+	if os.Getenv("TFL_API_KEY") == "" && os.Getenv("TFL_MODE") != "replay" {
 		log.Fatal("TFL_API_KEY environment variable is not set. Please configure it in Lambda settings.")
 	}
-	log.Printf("Using TfL key: %q", tflAPIKey)
+	var err error
+	tflClient, err = tfl.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure TfL client: %v", err)
+	}
 
 	influxDBUrl = os.Getenv("INFLUXDB_URL")
 	influxOrg = os.Getenv("INFLUXDB_ORG")
@@ -44,46 +45,10 @@ func init() {
 	}
 }
 
-func FetchLineStatus() (*common.TfLLineStatusResponse, error) {
-
-	url := fmt.Sprintf(
-		"https://api.tfl.gov.uk/Line/Mode/tube,dlr,bus/Status?app_key=%s",
-		tflAPIKey,
-	)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-
-	// Add the User-Agent header
-	req.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36")
-
-	client := http.Client{Timeout: 10 * time.Second}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error calling TfL API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TfL Journey API returned status %s", resp.Status)
-	}
-
-	var result common.TfLLineStatusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("error decoding response: %w", err)
-	}
-
-	return &result, nil
-
-}
-
 func handler(ctx context.Context) error {
 	log.Println("Lambda function invoked for TfL status update.")
 
-	tflStatusResponse, fetchErr := FetchLineStatus()
+	tflStatusResponse, fetchErr := tflClient.LineStatus("tube", "dlr", "bus")
 	if fetchErr != nil {
 		log.Printf("Error fetching TfL status: %v", fetchErr)
 		return fmt.Errorf("failed to fetch TfL status: %w", fetchErr)
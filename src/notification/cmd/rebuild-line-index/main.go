@@ -0,0 +1,27 @@
+// Command rebuild-line-index recomputes the line index of the active routes, for when it has
+// drifted from them (e.g. after a write to one of them failed). It reads the same environment
+// as the notification service.
+package main
+
+import (
+	"context"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"log"
+)
+
+func main() {
+	ctx := context.Background()
+
+	storeCfg := store.ConfigFromEnv()
+	repo, err := store.Open(ctx, storeCfg)
+	if err != nil {
+		log.Fatalf("Failed to open route store: %s", err)
+	}
+	defer repo.Close()
+
+	subscriptions, err := repo.RebuildLineIndex(ctx)
+	if err != nil {
+		log.Fatalf("Failed to rebuild the line index: %s", err)
+	}
+	log.Printf("Line index rebuilt (%s): %d line subscription(s).", storeCfg.Backend, subscriptions)
+}
@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+func NotifyUser(userID, msg string) {
+	resp, err := http.PostForm("http://api_gateway:8080/send-notification", url.Values{
+		"userID": {userID},
+		"msg":    {msg},
+	})
+	if err != nil {
+		log.Printf("❌ Failed to send notification for user=%s: %v", userID, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		log.Printf("⚠️ Notification API returned status %d: %s", resp.StatusCode, string(body))
+	} else {
+		log.Printf("✅ Notification sent successfully for user=%s", userID)
+	}
+}
@@ -1,11 +1,19 @@
 package internal
 
 import (
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/lines"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
 	"github.com/patrickmn/go-cache"
+	"time"
 )
 
 var (
-	DBClient *dynamodb.Client
-	AppCache *cache.Cache
+	Store       store.Repository
+	Lines       *lines.Registry
+	AppCache    *cache.Cache
+	Commutes    = NewWatchlist()
+	Subscribers = NewSubscriptions(time.Minute)
+	// ShareSubscription, when set, tells the other replicas about a change to the subscriptions.
+	ShareSubscription func(event string, route common.ActiveRoute)
 )
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"context"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"log"
+)
+
+// CheckActiveRoutes returns the users whose active route uses a line. The first lookup of a
+// line reads the store's line index; if the store is unreachable, only the users subscribed
+// since startup are found.
+func CheckActiveRoutes(ctx context.Context, lineID string) (bool, []string) {
+	userIDs, err := Subscribers.UsersOn(lineID, func(lineID string) ([]string, error) {
+		return Store.UsersOnLine(ctx, lineID)
+	})
+	if err != nil {
+		log.Printf("Failed to load subscriptions of line %s from the route store: %v", lineID, err)
+	}
+	log.Printf("Found %d subscribed user(s) for line %s.", len(userIDs), lineID)
+	return len(userIDs) > 0, userIDs
+}
+
+// The routes are written to the store first, so a failed write leaves the subscriptions as
+// they were and the event is retried.
+
+func RegisterNewRoute(ctx context.Context, route common.ActiveRoute) error {
+	if err := Store.PutActiveRoute(ctx, route); err != nil {
+		return err
+	}
+	Subscribers.Add(route)
+	shareSubscription(SubscriptionAdded, route)
+	return nil
+}
+
+func DeleteActiveRoute(ctx context.Context, userID string) (*common.ActiveRoute, error) {
+	deletedRoute, err := Store.DeleteActiveRoute(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// The subscriptions may hold a route the store no longer has, e.g. after a failed write.
+	Subscribers.Remove(userID)
+	shareSubscription(SubscriptionRemoved, common.ActiveRoute{UserID: userID})
+	if deletedRoute == nil {
+		log.Printf("Info: No active route found for user %s to delete.", userID)
+		return nil, nil
+	}
+
+	log.Printf("Successfully deleted active route for user %s on line %s.", deletedRoute.UserID, deletedRoute.LineIDs)
+	return deletedRoute, nil
+}
+
+func shareSubscription(event string, route common.ActiveRoute) {
+	if ShareSubscription != nil {
+		ShareSubscription(event, route)
+	}
+}
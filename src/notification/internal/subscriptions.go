@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Events replicas exchange when they share their subscriptions, with the route as body.
+const (
+	SubscriptionAdded   = "subscription.added"
+	SubscriptionRemoved = "subscription.removed"
+)
+
+// Subscriptions holds the lines of active routes, indexed by line, so that delay alerts are
+// matched without going to the route store. A line is loaded from the store's line index the
+// first time it is looked up, and again once it is older than the maximum age; in between it is
+// updated in place as routes are created and terminated. The maximum age bounds how long a
+// replica misses the changes other replicas make, when they do not share them.
+type Subscriptions struct {
+	mu      sync.RWMutex
+	byLine  map[string]map[string]bool // line ID → user IDs
+	byUser  map[string][]string        // user ID → line IDs
+	loaded  map[string]time.Time       // lines whose users all come from the store, and when
+	maxAge  time.Duration              // 0 keeps loaded lines for ever
+	changes int                        // routes added or removed so far, to spot a load racing a change
+}
+
+func NewSubscriptions(maxAge time.Duration) *Subscriptions {
+	return &Subscriptions{
+		byLine: make(map[string]map[string]bool),
+		byUser: make(map[string][]string),
+		loaded: make(map[string]time.Time),
+		maxAge: maxAge,
+	}
+}
+
+// Add subscribes a user to the lines of their route, replacing the route they had.
+func (s *Subscriptions) Add(route common.ActiveRoute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.changes++
+	s.remove(route.UserID)
+	s.add(route)
+}
+
+// Remove unsubscribes a user from every line and returns the lines they were on. A user on no
+// loaded line counts as a change too: a load in progress may be about to return them.
+func (s *Subscriptions) Remove(userID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.changes++
+	return s.remove(userID)
+}
+
+// UsersOn returns the users subscribed to a line, sorted. A line not loaded yet, or loaded too
+// long ago, is read with load, which should query the store's line index; if load fails, the
+// users known in memory are returned along with the error.
+func (s *Subscriptions) UsersOn(lineID string, load func(lineID string) ([]string, error)) ([]string, error) {
+	s.mu.RLock()
+	at, loaded := s.loaded[lineID]
+	fresh := loaded && (s.maxAge <= 0 || time.Since(at) < s.maxAge)
+	changes := s.changes
+	users := s.usersOn(lineID)
+	s.mu.RUnlock()
+	if fresh {
+		return users, nil
+	}
+
+	stored, err := load(lineID)
+	if err != nil {
+		return users, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A route added or removed while the store was read may or may not be in what it returned:
+	// answer this lookup with both, but leave the line to be read again next time.
+	if s.changes != changes {
+		for _, userID := range s.usersOn(lineID) {
+			if !slices.Contains(stored, userID) {
+				stored = append(stored, userID)
+			}
+		}
+		sort.Strings(stored)
+		return stored, nil
+	}
+	for _, userID := range s.usersOn(lineID) {
+		if !slices.Contains(stored, userID) {
+			s.unsubscribe(userID, lineID)
+		}
+	}
+	for _, userID := range stored {
+		if !s.byLine[lineID][userID] {
+			s.subscribe(userID, lineID)
+		}
+	}
+	s.loaded[lineID] = time.Now()
+	return s.usersOn(lineID), nil
+}
+
+func (s *Subscriptions) usersOn(lineID string) []string {
+	var users []string
+	for userID := range s.byLine[lineID] {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+	return users
+}
+
+func (s *Subscriptions) add(route common.ActiveRoute) {
+	for _, lineID := range route.LineIDs {
+		if !s.byLine[lineID][route.UserID] {
+			s.subscribe(route.UserID, lineID)
+		}
+	}
+}
+
+func (s *Subscriptions) subscribe(userID, lineID string) {
+	users, ok := s.byLine[lineID]
+	if !ok {
+		users = make(map[string]bool)
+		s.byLine[lineID] = users
+	}
+	users[userID] = true
+	s.byUser[userID] = append(s.byUser[userID], lineID)
+}
+
+func (s *Subscriptions) unsubscribe(userID, lineID string) {
+	delete(s.byLine[lineID], userID)
+	if len(s.byLine[lineID]) == 0 {
+		delete(s.byLine, lineID)
+	}
+	lineIDs := slices.DeleteFunc(s.byUser[userID], func(l string) bool { return l == lineID })
+	if len(lineIDs) == 0 {
+		delete(s.byUser, userID)
+	} else {
+		s.byUser[userID] = lineIDs
+	}
+}
+
+func (s *Subscriptions) remove(userID string) []string {
+	lineIDs, ok := s.byUser[userID]
+	if !ok {
+		return nil
+	}
+	for _, lineID := range lineIDs {
+		delete(s.byLine[lineID], userID)
+		if len(s.byLine[lineID]) == 0 {
+			delete(s.byLine, lineID)
+		}
+	}
+	delete(s.byUser, userID)
+	return lineIDs
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"errors"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"slices"
+	"testing"
+	"time"
+)
+
+func storedUsers(users ...string) func(string) ([]string, error) {
+	return func(string) ([]string, error) { return users, nil }
+}
+
+func TestSubscriptionsLoadOnce(t *testing.T) {
+	s := NewSubscriptions(0)
+	loads := 0
+	load := func(string) ([]string, error) {
+		loads++
+		return []string{"bob", "alice"}, nil
+	}
+	for range 2 {
+		users, err := s.UsersOn("victoria", load)
+		if err != nil || !slices.Equal(users, []string{"alice", "bob"}) {
+			t.Fatalf("UsersOn = %v, %v", users, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loaded %d times, want once", loads)
+	}
+
+	s.Remove("alice")
+	s.Add(common.ActiveRoute{UserID: "carol", LineIDs: []string{"victoria", "central"}})
+	users, _ := s.UsersOn("victoria", load)
+	if !slices.Equal(users, []string{"bob", "carol"}) {
+		t.Errorf("after changes, UsersOn = %v", users)
+	}
+}
+
+// A Remove that comes while the store is read must not be undone by what the read returns, even
+// when the user was not in memory yet.
+func TestSubscriptionsRemoveDuringLoad(t *testing.T) {
+	s := NewSubscriptions(0)
+	started, release := make(chan struct{}), make(chan struct{})
+	load := func(string) ([]string, error) {
+		close(started)
+		<-release
+		return []string{"alice", "bob"}, nil
+	}
+	done := make(chan []string)
+	go func() {
+		users, _ := s.UsersOn("victoria", load)
+		done <- users
+	}()
+	<-started
+	s.Remove("alice")
+	close(release)
+	<-done
+
+	users, _ := s.UsersOn("victoria", storedUsers("bob"))
+	if !slices.Equal(users, []string{"bob"}) {
+		t.Errorf("after the racing load, UsersOn = %v, want [bob]", users)
+	}
+}
+
+func TestSubscriptionsReloadAfterMaxAge(t *testing.T) {
+	s := NewSubscriptions(time.Millisecond)
+	if users, _ := s.UsersOn("victoria", storedUsers("alice", "bob")); len(users) != 2 {
+		t.Fatalf("UsersOn = %v", users)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	// Another replica terminated alice's route and started carol's.
+	users, _ := s.UsersOn("victoria", storedUsers("bob", "carol"))
+	if !slices.Equal(users, []string{"bob", "carol"}) {
+		t.Errorf("after the max age, UsersOn = %v, want [bob carol]", users)
+	}
+	if lineIDs := s.Remove("alice"); lineIDs != nil {
+		t.Errorf("alice still on %v", lineIDs)
+	}
+}
+
+func TestSubscriptionsLoadError(t *testing.T) {
+	s := NewSubscriptions(0)
+	s.Add(common.ActiveRoute{UserID: "alice", LineIDs: []string{"victoria"}})
+	users, err := s.UsersOn("victoria", func(string) ([]string, error) { return nil, errors.New("store down") })
+	if err == nil || !slices.Equal(users, []string{"alice"}) {
+		t.Fatalf("UsersOn = %v, %v", users, err)
+	}
+	if users, _ := s.UsersOn("victoria", storedUsers("alice", "bob")); len(users) != 2 {
+		t.Errorf("a failed load was cached: UsersOn = %v", users)
+	}
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watchlist holds the commute watches of saved routes, indexed by the lines they use.
+type Watchlist struct {
+	mu     sync.RWMutex
+	byLine map[string][]common.CommuteWatch
+}
+
+func NewWatchlist() *Watchlist {
+	return &Watchlist{byLine: make(map[string][]common.CommuteWatch)}
+}
+
+// Update replaces every watch. Line names are turned into line IDs with normalize; those it
+// turns into "" are skipped.
+func (w *Watchlist) Update(watches []common.CommuteWatch, normalize func(string) string) {
+	byLine := make(map[string][]common.CommuteWatch)
+	for _, watch := range watches {
+		seen := make(map[string]bool)
+		for _, line := range watch.Lines {
+			key := normalize(line)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			byLine[key] = append(byLine[key], watch)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.byLine = byLine
+}
+
+// Watching returns the watches on a line whose window is open at a given time.
+func (w *Watchlist) Watching(line string, at time.Time) []common.CommuteWatch {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var open []common.CommuteWatch
+	for _, watch := range w.byLine[line] {
+		if InWindow(watch, at) {
+			open = append(open, watch)
+		}
+	}
+	return open
+}
+
+// InWindow reports whether a time, in London, falls within a watch's weekly window. A window
+// spanning midnight belongs to the weekday it starts on.
+func InWindow(watch common.CommuteWatch, at time.Time) bool {
+	at = at.In(tfl.London)
+	start, errStart := time.Parse("15:04", watch.Start)
+	end, errEnd := time.Parse("15:04", watch.End)
+	if errStart != nil || errEnd != nil {
+		return false
+	}
+	minute := at.Hour()*60 + at.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+
+	day := at
+	switch {
+	case from < to:
+		if minute < from || minute >= to {
+			return false
+		}
+	case minute >= from:
+	case minute < to:
+		day = at.AddDate(0, 0, -1)
+	default:
+		return false
+	}
+
+	weekday := strings.ToUpper(day.Weekday().String()[:3])
+	for _, d := range watch.Weekdays {
+		if d == weekday {
+			return true
+		}
+	}
+	return false
+}
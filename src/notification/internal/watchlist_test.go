@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInWindow(t *testing.T) {
+	morning := common.CommuteWatch{Weekdays: []string{"MON", "FRI"}, Start: "07:30", End: "09:00"}
+	night := common.CommuteWatch{Weekdays: []string{"FRI"}, Start: "23:00", End: "01:00"}
+	// Summer time starts at 01:00 GMT on Sunday 29 March 2026 and ends at 01:00 GMT on Sunday
+	// 25 October 2026.
+	sunday := common.CommuteWatch{Weekdays: []string{"SUN"}, Start: "01:00", End: "03:00"}
+
+	london := func(value string) time.Time {
+		at, err := time.ParseInLocation("2006-01-02 15:04", value, tfl.London)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	tests := []struct {
+		name  string
+		watch common.CommuteWatch
+		at    time.Time
+		want  bool
+	}{
+		{"at the start", morning, london("2026-10-19 07:30"), true},
+		{"a minute before the start", morning, london("2026-10-19 07:29"), false},
+		{"a minute before the end", morning, london("2026-10-19 08:59"), true},
+		{"at the end", morning, london("2026-10-19 09:00"), false},
+		{"another weekday", morning, london("2026-10-20 08:00"), false},
+		{"read in London time", morning, time.Date(2026, 10, 19, 7, 15, 0, 0, time.UTC), true},
+		{"in winter, read in London time", morning, time.Date(2026, 10, 30, 7, 15, 0, 0, time.UTC), false},
+		{"spanning midnight, before it", night, london("2026-10-23 23:30"), true},
+		{"spanning midnight, after it", night, london("2026-10-24 00:30"), true},
+		{"spanning midnight, at the end", night, london("2026-10-24 01:00"), false},
+		{"spanning midnight, the night before", night, london("2026-10-23 00:30"), false},
+		{"spanning midnight, from the next weekday", night, london("2026-10-24 23:30"), false},
+		{"clocks go forward, the skipped hour", sunday, time.Date(2026, 3, 29, 1, 30, 0, 0, time.UTC), true},
+		{"clocks go forward, after the window", sunday, time.Date(2026, 3, 29, 2, 0, 0, 0, time.UTC), false},
+		{"clocks go back, the first 01:30", sunday, time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC), true},
+		{"clocks go back, the second 01:30", sunday, time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC), true},
+		{"clocks go back, after the window", sunday, time.Date(2026, 10, 25, 3, 0, 0, 0, time.UTC), false},
+		{"invalid window", common.CommuteWatch{Weekdays: []string{"MON"}, Start: "8am", End: "09:00"}, london("2026-10-19 08:00"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InWindow(tt.watch, tt.at); got != tt.want {
+				t.Errorf("InWindow(%s-%s %v, %s) = %v, want %v", tt.watch.Start, tt.watch.End, tt.watch.Weekdays, tt.at.In(tfl.London).Format(time.RFC1123), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchlistWatching(t *testing.T) {
+	w := NewWatchlist()
+	w.Update([]common.CommuteWatch{
+		{WatchID: "commute", Weekdays: []string{"MON"}, Start: "07:30", End: "09:00", Lines: []string{"Victoria", "victoria", "Jubilee"}},
+		{WatchID: "unknown", Weekdays: []string{"MON"}, Start: "07:30", End: "09:00", Lines: []string{"Tramlink 9"}},
+	}, func(line string) string {
+		if strings.HasPrefix(line, "Tramlink") {
+			return ""
+		}
+		return strings.ToLower(line)
+	})
+
+	monday := time.Date(2026, 10, 19, 8, 0, 0, 0, tfl.London)
+	if open := w.Watching("victoria", monday); len(open) != 1 || open[0].WatchID != "commute" {
+		t.Errorf("Watching(victoria) = %+v, want the commute once", open)
+	}
+	if open := w.Watching("victoria", monday.Add(2*time.Hour)); len(open) != 0 {
+		t.Errorf("after the window, Watching(victoria) = %+v", open)
+	}
+	if open := w.Watching("", monday); len(open) != 0 {
+		t.Errorf("an unknown line was watched: %+v", open)
+	}
+}
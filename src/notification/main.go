@@ -4,17 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/lines"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
 	"github.com/matteoavallone7/optimaLDN/src/notification/internal"
 	"github.com/matteoavallone7/optimaLDN/src/rabbitmq"
 	"github.com/patrickmn/go-cache"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
+	"net/rpc"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -29,8 +30,10 @@ const (
 	routeQueueName                   = "route_planner_queue"
 	routeBindingKey                  = "active.route.#"
 	routeExchange                    = "route_planner_exchange"
+	subscriptionBindingKey           = "subscription.#"
 	defaultCacheExpiration           = 5 * time.Minute // How long an item stays in cache
 	cacheCleanupInterval             = 10 * time.Minute
+	commuteAlertCooldown             = time.Hour // a commuter hears about the same delay once per window
 )
 
 var notificationPublisher *rabbitmq.Publisher
@@ -41,17 +44,39 @@ func failOnError(err error, msg string) {
 	}
 }
 
-func normalizeLineName(line string) string {
-	line = strings.ToLower(line)
-	line = strings.ReplaceAll(line, " & ", "-")
-	line = strings.ReplaceAll(line, " ", "-")
-	return line
+// alertLineID returns the canonical ID of the line an alert is about. The traffic service sets
+// it; alerts without it are resolved by line name.
+func alertLineID(alert common.TfLAlert) (string, error) {
+	ref := alert.LineID
+	if ref == "" {
+		ref = alert.LineName
+	}
+	line, err := internal.Lines.Resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	return line.ID, nil
+}
+
+// commuteLineID resolves a line of a commute watch, which saved routes keep by name. Unknown
+// lines resolve to "" and are left out of the watchlist.
+func commuteLineID(name string) string {
+	line, err := internal.Lines.Resolve(name)
+	if err != nil {
+		log.Printf("Validation error in a commute watch: %v", err)
+		return ""
+	}
+	return line.ID
 }
 
 func handleCriticalDelay(ctx context.Context, payload common.NotificationPayload) {
 	for _, alert := range payload.Alerts {
-		normalizedLine := normalizeLineName(alert.LineName)
-		res, userIDs := internal.CheckActiveRoutes(ctx, normalizedLine)
+		lineID, err := alertLineID(alert)
+		if err != nil {
+			log.Printf("Validation error, skipping %s alert: %v", payload.AlertType, err)
+			continue
+		}
+		res, userIDs := internal.CheckActiveRoutes(ctx, lineID)
 		if res {
 			for _, userID := range userIDs {
 				_, err1 := internal.DeleteActiveRoute(ctx, userID)
@@ -62,6 +87,7 @@ func handleCriticalDelay(ctx context.Context, payload common.NotificationPayload
 				req := common.NewRequest{
 					UserID: userID,
 					Reason: msg,
+					Lines:  []string{lineID},
 				}
 				data, err := json.Marshal(req)
 				failOnError(err, "Failed to marshal critical delay json")
@@ -69,22 +95,27 @@ func handleCriticalDelay(ctx context.Context, payload common.NotificationPayload
 				failOnError(err, "Failed to publish notification")
 			}
 		} else {
-			log.Printf("No active route subscriptions found for line '%s'. Skipping notification.", alert.LineName)
-			continue
+			log.Printf("No active route subscriptions found for line '%s'.", alert.LineName)
 		}
+		alertCommuters(payload.AlertType, alert, lineID, userIDs)
 	}
 }
 
 func handleSuddenDelay(ctx context.Context, payload common.NotificationPayload) {
 	for _, alert := range payload.Alerts {
-		normalizedLine := normalizeLineName(alert.LineName)
-		res, userIDs := internal.CheckActiveRoutes(ctx, normalizedLine)
+		lineID, err := alertLineID(alert)
+		if err != nil {
+			log.Printf("Validation error, skipping %s alert: %v", payload.AlertType, err)
+			continue
+		}
+		res, userIDs := internal.CheckActiveRoutes(ctx, lineID)
 		if res {
 			for _, userID := range userIDs {
 				msg := fmt.Sprintf("Line %s for user %s is experiencing sudden worsening delays.", alert.LineName, userID)
 				req := common.NewRequest{
 					UserID: userID,
 					Reason: msg,
+					Lines:  []string{lineID},
 				}
 				data, err := json.Marshal(req)
 				failOnError(err, "Failed to marshal sudden delay JSON")
@@ -92,37 +123,129 @@ func handleSuddenDelay(ctx context.Context, payload common.NotificationPayload)
 				failOnError(err, "Failed to publish sudden delay notification")
 			}
 		} else {
-			log.Printf("No active route subscriptions found for line '%s'. Skipping notification.", alert.LineName)
+			log.Printf("No active route subscriptions found for line '%s'.", alert.LineName)
+		}
+		alertCommuters(payload.AlertType, alert, lineID, userIDs)
+	}
+}
+
+// alertCommuters warns users whose commute watch on a line is open, unless they are already
+// travelling on an active route and were alerted through it.
+func alertCommuters(alertType string, alert common.TfLAlert, line string, activeUsers []string) {
+	active := make(map[string]bool, len(activeUsers))
+	for _, userID := range activeUsers {
+		active[userID] = true
+	}
+
+	for _, watch := range internal.Commutes.Watching(line, time.Now()) {
+		if active[watch.UserID] {
 			continue
 		}
+		// Add fails while the key exists, so each watch hears about a delay once per cooldown.
+		if err := internal.AppCache.Add(fmt.Sprintf("commute|%s|%s|%s", watch.WatchID, line, alertType), true, commuteAlertCooldown); err != nil {
+			continue
+		}
+
+		kind := "sudden worsening delays"
+		if alertType == "CriticalDelay" {
+			kind = "critical delays"
+		}
+		msg := fmt.Sprintf("🚦 Line %s is experiencing %s, on your %s–%s commute from %s to %s.", alert.LineName, kind, watch.Start, watch.End, watch.StartPoint, watch.EndPoint)
+		if alert.StatusDescription != "" {
+			msg += "\nStatus: " + alert.StatusDescription
+		}
+		msg += "\nConsider leaving earlier or planning another route before you set off."
+		log.Printf("Alerting user %s about line '%s' on commute watch %s.", watch.UserID, line, watch.WatchID)
+		internal.NotifyUser(watch.UserID, msg)
 	}
 }
 
-func RemoveUserFromLineCache(userID string, lineNames []string) {
-	for _, line := range lineNames {
-		cacheKey := fmt.Sprintf("%s", line)
-
-		if cached, found := internal.AppCache.Get(cacheKey); found {
-			if userIDs, ok := cached.([]string); ok {
-				var updated []string
-				for _, uid := range userIDs {
-					if uid != userID {
-						updated = append(updated, uid)
-					}
-				}
+// syncCommuteWatches keeps the commute watchlist in step with the user service.
+func syncCommuteWatches(ctx context.Context, addr string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-				if len(updated) == 0 {
-					internal.AppCache.Delete(cacheKey)
-					log.Printf("Deleted cache for line '%s' (no remaining users).", cacheKey)
-				} else {
-					internal.AppCache.Set(cacheKey, updated, cache.DefaultExpiration)
-					log.Printf("Removed user '%s' from cache for line '%s'.", userID, cacheKey)
-				}
+	var client *rpc.Client
+	for {
+		var err error
+		if client == nil {
+			client, err = rpc.Dial("tcp", addr)
+		}
+		if err == nil {
+			var watches []common.CommuteWatch
+			if err = client.Call("UserService.GetCommuteWatches", &common.NewRequest{}, &watches); err == nil {
+				internal.Commutes.Update(watches, commuteLineID)
+				log.Printf("Commute watchlist updated: %d watches.", len(watches))
+			} else if _, remote := err.(rpc.ServerError); !remote {
+				// The connection is gone: dial again next time.
+				client.Close()
+				client = nil
 			}
 		}
+		if err != nil {
+			log.Printf("Could not sync commute watches from %s: %v", addr, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if client != nil {
+				client.Close()
+			}
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
+// shareSubscriptions keeps the subscriptions of this replica in step with those of the other
+// replicas: each change is published on the outbound exchange, and every replica applies the
+// changes the others publish.
+func shareSubscriptions(ctx context.Context, ch *amqp.Channel, wg *sync.WaitGroup) {
+	replica, err := os.Hostname()
+	failOnError(err, "Failed to get the replica's hostname")
+
+	q, err := rabbitmq.DeclareAndBindExclusiveQueue(ch, subscriptionBindingKey, notificationOutboundExchangeName)
+	failOnError(err, "Failed to declare the subscription sharing queue")
+
+	internal.ShareSubscription = func(event string, route common.ActiveRoute) {
+		data, err := json.Marshal(route)
+		if err != nil {
+			log.Printf("Failed to marshal subscription change: %v", err)
+			return
+		}
+		if err = notificationPublisher.Publish(event, data, amqp.Table{"Replica": replica}); err != nil {
+			log.Printf("Failed to share subscription change for user %s: %v", route.UserID, err)
+		}
+	}
+
+	handler := func(delivery amqp.Delivery) bool {
+		if delivery.Headers["Replica"] == replica {
+			return true
+		}
+		var route common.ActiveRoute
+		if err := json.Unmarshal(delivery.Body, &route); err != nil {
+			log.Printf("Failed to unmarshal subscription change: %v", err)
+			return true // a malformed change will not get any better
+		}
+		switch delivery.RoutingKey {
+		case internal.SubscriptionAdded:
+			internal.Subscribers.Add(route)
+		case internal.SubscriptionRemoved:
+			internal.Subscribers.Remove(route.UserID)
+		default:
+			log.Printf("Unrecognized routing key: %s", delivery.RoutingKey)
+		}
+		return true
+	}
+	consumer := rabbitmq.NewConsumer(ch, q.Name, handler)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		consumer.StartConsume(ctx)
+	}()
+	log.Printf("Sharing subscriptions with the other replicas as %s.", replica)
+}
+
 func main() {
 	fmt.Println("Starting Notification service...")
 	fmt.Println("Setting up RabbitMQ...")
@@ -130,12 +253,18 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		log.Fatalf("Failed to load AWS config: %v", err)
-	}
-	internal.DBClient = dynamodb.NewFromConfig(cfg)
-	log.Println("DynamoDB client initialized.")
+	storeCfg := store.ConfigFromEnv()
+	var err error
+	internal.Store, err = store.Open(ctx, storeCfg)
+	failOnError(err, "Failed to open route store")
+	defer internal.Store.Close()
+	log.Printf("Route store initialized (%s).", storeCfg.Backend)
+
+	tflClient, err := tfl.NewFromEnv()
+	failOnError(err, "Failed to configure TfL client")
+	internal.Lines, err = lines.Open("lines.csv", tflClient)
+	failOnError(err, "Failed to load lines.csv")
+	log.Printf("Line registry loaded with %d lines.", internal.Lines.Len())
 
 	conn, ch, err := rabbitmq.InitRabbitMQ(notificationOutboundExchangeName, notificationOutboundExchangeType)
 	failOnError(err, "Failed to initialize RabbitMQ for Notification Service's outbound events")
@@ -154,11 +283,32 @@ func main() {
 	internal.AppCache = cache.New(defaultCacheExpiration, cacheCleanupInterval)
 	log.Println("In-memory cache initialized.")
 
+	var wg sync.WaitGroup
+
+	if v := os.Getenv("SUBSCRIPTIONS_MAX_AGE"); v != "" {
+		maxAge, err := time.ParseDuration(v)
+		failOnError(err, "Failed to parse SUBSCRIPTIONS_MAX_AGE")
+		internal.Subscribers = internal.NewSubscriptions(maxAge)
+	}
+
+	if os.Getenv("SHARE_SUBSCRIPTIONS") == "true" {
+		shareSubscriptions(ctx, ch, &wg)
+	}
+
+	if addr := os.Getenv("USER_SERVICE_ADDR"); addr != "" {
+		watchInterval := 5 * time.Minute
+		if v := os.Getenv("COMMUTE_WATCH_REFRESH"); v != "" {
+			watchInterval, err = time.ParseDuration(v)
+			failOnError(err, "Failed to parse COMMUTE_WATCH_REFRESH")
+		}
+		go syncCommuteWatches(ctx, addr, watchInterval)
+	} else {
+		log.Println("USER_SERVICE_ADDR not set, commute watches are disabled.")
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	var wg sync.WaitGroup
-
 	trafficHandler := func(delivery amqp.Delivery) bool {
 		log.Printf("[Notification Service] Received Delay Event: %s (Key: %s)", string(delivery.Body), delivery.RoutingKey)
 		var payload common.NotificationPayload
@@ -171,7 +321,15 @@ func main() {
 		case "SuddenServiceWorsening":
 			handleSuddenDelay(ctx, payload)
 		default:
-			log.Printf("  -> Unrecognized alert type in payload: '%s'.", payload.AlertType)
+			// Alert types added through the traffic service's rules are handled by severity.
+			switch payload.Severity {
+			case "critical":
+				handleCriticalDelay(ctx, payload)
+			case "warning":
+				handleSuddenDelay(ctx, payload)
+			default:
+				log.Printf("  -> Unrecognized alert type in payload: '%s'.", payload.AlertType)
+			}
 		}
 
 		return true
@@ -198,6 +356,10 @@ func main() {
 
 		switch delivery.RoutingKey {
 		case "active.route.created":
+			var invalid error
+			if req.LineIDs, invalid = internal.Lines.Canonical(req.LineIDs); invalid != nil {
+				log.Printf("Validation error in the active route of user %s: %v", req.UserID, invalid)
+			}
 			err = internal.RegisterNewRoute(ctx, req)
 			if err != nil {
 				log.Printf("Failed to write active route for user %s: %v", req.UserID, err)
@@ -206,12 +368,10 @@ func main() {
 			log.Printf("Stored active route for user %s.", req.UserID)
 
 		case "active.route.terminated":
-			deletedRoute, err := internal.DeleteActiveRoute(ctx, req.UserID)
-			if err != nil {
+			if _, err := internal.DeleteActiveRoute(ctx, req.UserID); err != nil {
 				log.Printf("Failed to delete active route for user %s: %v", req.UserID, err)
 				return false
 			}
-			RemoveUserFromLineCache(req.UserID, deletedRoute.LineIDs)
 
 		default:
 			log.Printf("Unrecognized routing key: %s", delivery.RoutingKey)
@@ -23,5 +23,84 @@ CREATE TABLE IF NOT EXISTS user_saved_routes (
     stops INTEGER,
     estimated_time INTEGER,
     line_names TEXT[],
-    stops_names TEXT[]
+    stops_names TEXT[],
+    legs JSONB NOT NULL DEFAULT '[]',
+    waypoints JSONB NOT NULL DEFAULT '[]'
+);
+
+-- Saved routes created before the legs were stored keep only the flattened line and stop names,
+-- until the same trip is saved again and its legs and waypoints are filled in.
+ALTER TABLE user_saved_routes ADD COLUMN IF NOT EXISTS legs JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE user_saved_routes ADD COLUMN IF NOT EXISTS waypoints JSONB NOT NULL DEFAULT '[]';
+
+-- A user saves one route per trip: saving it again replaces it.
+-- Databases from before the index may hold several copies of a trip: the one stored last is kept.
+DELETE FROM user_saved_routes older
+USING user_saved_routes newer
+WHERE older.user_id = newer.user_id
+  AND older.start_point = newer.start_point
+  AND older.end_point = newer.end_point
+  AND older.transport_mode = newer.transport_mode
+  AND older.ctid < newer.ctid;
+CREATE UNIQUE INDEX IF NOT EXISTS user_saved_routes_trip ON user_saved_routes (user_id, start_point, end_point, transport_mode);
+
+CREATE TABLE IF NOT EXISTS user_preferences (
+    user_id TEXT PRIMARY KEY,
+    avoid_lines TEXT[] NOT NULL DEFAULT '{}',
+    avoid_peak_lines TEXT[] NOT NULL DEFAULT '{}',
+    avoid_modes TEXT[] NOT NULL DEFAULT '{}',
+    avoid_stations TEXT[] NOT NULL DEFAULT '{}',
+    access_profile TEXT NOT NULL DEFAULT '',
+    extra_interchange_mins INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS commute_watches (
+    watch_id UUID PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    route_id UUID NOT NULL REFERENCES user_saved_routes (route_id) ON DELETE CASCADE,
+    weekdays TEXT[] NOT NULL,
+    start_time TIME NOT NULL,
+    end_time TIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS journey_history (
+    journey_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    outcome TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    start_point TEXT NOT NULL DEFAULT '',
+    end_point TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ NOT NULL,
+    planned_arrival TIMESTAMPTZ,
+    delay_mins DOUBLE PRECISION NOT NULL DEFAULT 0,
+    time_lost_mins DOUBLE PRECISION NOT NULL DEFAULT 0,
+    line_names TEXT[] NOT NULL DEFAULT '{}',
+    legs JSONB NOT NULL DEFAULT '[]',
+    recalculations JSONB NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX IF NOT EXISTS journey_history_user_started ON journey_history (user_id, started_at);
+
+CREATE TABLE IF NOT EXISTS chosen_routes (
+    user_id TEXT PRIMARY KEY,
+    route JSONB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS active_routes (
+    user_id TEXT PRIMARY KEY,
+    line_ids TEXT[] NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_journeys (
+    schedule_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    journey JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS active_routes_line_ids ON active_routes USING GIN (line_ids);
+
+CREATE TABLE IF NOT EXISTS fare_ledgers (
+    user_id TEXT PRIMARY KEY,
+    ledger JSONB NOT NULL
 );
\ No newline at end of file
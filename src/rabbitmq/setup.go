@@ -85,6 +85,36 @@ func DeclareAndBindQueue(ch *amqp.Channel, queueName, bindingKey, exchangeName s
 	return q, nil
 }
 
+// DeclareAndBindExclusiveQueue declares a server-named queue that only lives as long as this
+// connection, so that every instance of a service gets its own copy of the matching messages.
+func DeclareAndBindExclusiveQueue(ch *amqp.Channel, bindingKey, exchangeName string) (amqp.Queue, error) {
+	q, err := ch.QueueDeclare(
+		"",
+		false,
+		true,
+		true,
+		false,
+		nil,
+	)
+	if err != nil {
+		return amqp.Queue{}, fmt.Errorf("failed to declare exclusive queue: %w", err)
+	}
+
+	err = ch.QueueBind(
+		q.Name,
+		bindingKey,
+		exchangeName,
+		false,
+		nil,
+	)
+	if err != nil {
+		return amqp.Queue{}, fmt.Errorf("failed to bind queue '%s' to exchange '%s' with key '%s': %w", q.Name, exchangeName, bindingKey, err)
+	}
+	log.Printf("Exclusive queue '%s' bound to exchange '%s' with key '%s' successfully.", q.Name, exchangeName, bindingKey)
+
+	return q, nil
+}
+
 func CloseResources(ch *amqp.Channel, conn *amqp.Connection) func() error {
 	return func() error {
 		var errCh error
@@ -10,6 +10,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/matteoavallone7/optimaLDN/src/common v0.0.0
 	github.com/matteoavallone7/optimaLDN/src/rabbitmq v0.0.0
+	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/rabbitmq/amqp091-go v1.10.0
 
 )
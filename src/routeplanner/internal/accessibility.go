@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"strings"
+	"sync"
+)
+
+// Step-free levels of stationAccessibility.csv.
+const (
+	StepFreeVehicle  = "vehicle"  // step-free from street to train
+	StepFreePlatform = "platform" // step-free to the platform, with a step or gap onto the train
+	StepFreeNone     = "none"
+)
+
+const (
+	// inaccessibleEndpointPenalty is the cost of starting or ending at a station the user cannot use.
+	inaccessibleEndpointPenalty = 15
+	// platformGapPenalty is the cost of each boarding or alighting with a step or gap onto the train.
+	platformGapPenalty = 3
+)
+
+// StationAccess is the accessibility metadata of one station.
+type StationAccess struct {
+	Name     string
+	StepFree string
+	Stairs   bool
+}
+
+// AccessibilityIndex holds the local station accessibility metadata, together with the lift
+// outages currently reported by TfL.
+type AccessibilityIndex struct {
+	stations map[string]StationAccess
+
+	mu      sync.RWMutex
+	outages map[string]string // NaPTAN -> outage description
+}
+
+// NewAccessibilityIndex builds the index from stationAccessibility.csv records, header included:
+// "naptan,name,step_free,stairs" with step_free one of vehicle, platform or none.
+func NewAccessibilityIndex(records [][]string) (*AccessibilityIndex, error) {
+	index := &AccessibilityIndex{
+		stations: make(map[string]StationAccess),
+		outages:  make(map[string]string),
+	}
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+		if len(record) < 4 {
+			return nil, fmt.Errorf("accessibility row %d: expected 4 columns, got %d", i+1, len(record))
+		}
+		switch record[2] {
+		case StepFreeVehicle, StepFreePlatform, StepFreeNone:
+		default:
+			return nil, fmt.Errorf("accessibility row %d: unknown step-free level '%s'", i+1, record[2])
+		}
+		index.stations[record[0]] = StationAccess{Name: record[1], StepFree: record[2], Stairs: record[3] == "yes"}
+	}
+	return index, nil
+}
+
+func (a *AccessibilityIndex) Lookup(naptan string) (StationAccess, bool) {
+	access, ok := a.stations[NormalizeStopID(naptan)]
+	return access, ok
+}
+
+// Outage returns the description of a lift outage at a station, if there is one.
+func (a *AccessibilityIndex) Outage(naptan string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	msg, ok := a.outages[NormalizeStopID(naptan)]
+	return msg, ok
+}
+
+// SetOutages replaces the current lift outages and returns the stations whose outage is new.
+func (a *AccessibilityIndex) SetOutages(outages map[string]string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var started []string
+	for naptan := range outages {
+		if _, known := a.outages[naptan]; !known {
+			started = append(started, naptan)
+		}
+	}
+	a.outages = outages
+	return started
+}
+
+// IsLiftOutage reports whether a stop point disruption is about a lift.
+func IsLiftOutage(point common.DisruptedPoint) bool {
+	for _, word := range strings.FieldsFunc(strings.ToLower(point.Description), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	}) {
+		if word == "lift" || word == "lifts" {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessibilityPolicy applies a user's accessibility profile to candidate journeys.
+type AccessibilityPolicy struct {
+	profile common.Accessibility
+	index   *AccessibilityIndex
+}
+
+// NewAccessibilityPolicy returns nil when the profile asks for nothing.
+func NewAccessibilityPolicy(profile common.Accessibility, index *AccessibilityIndex) (*AccessibilityPolicy, error) {
+	switch profile.Profile {
+	case "", common.AccessStepFree, common.AccessNoStairs, common.AccessExtraInterchange:
+	default:
+		return nil, fmt.Errorf("unknown accessibility profile '%s'", profile.Profile)
+	}
+	if profile.ExtraInterchangeMins < 0 {
+		return nil, fmt.Errorf("extra interchange time must not be negative")
+	}
+	if profile.Profile == "" && profile.ExtraInterchangeMins == 0 {
+		return nil, nil
+	}
+	if profile.Profile == common.AccessExtraInterchange && profile.ExtraInterchangeMins == 0 {
+		profile.ExtraInterchangeMins = interchangePenalty
+	}
+	return &AccessibilityPolicy{profile: profile, index: index}, nil
+}
+
+// TfLPreferences returns the Journey Planner accessibility preferences for the profile.
+func (p *AccessibilityPolicy) TfLPreferences() []string {
+	if p == nil {
+		return nil
+	}
+	switch p.profile.Profile {
+	case common.AccessStepFree:
+		return []string{tfl.StepFreeToVehicle}
+	case common.AccessNoStairs:
+		return []string{tfl.NoSolidStairs}
+	}
+	return nil
+}
+
+// barrier explains why a station cannot be used with the profile, or returns "" if it can.
+// Stations missing from the metadata are assumed to be usable.
+func (p *AccessibilityPolicy) barrier(naptan string) string {
+	if p == nil || p.index == nil {
+		return ""
+	}
+	switch p.profile.Profile {
+	case common.AccessStepFree, common.AccessNoStairs:
+	default:
+		return ""
+	}
+
+	access, ok := p.index.Lookup(naptan)
+	if msg, out := p.index.Outage(naptan); out {
+		return fmt.Sprintf("lift out of service (%s)", msg)
+	}
+	if !ok {
+		return ""
+	}
+	if p.profile.Profile == common.AccessStepFree && access.StepFree == StepFreeNone {
+		return "not step-free"
+	}
+	if p.profile.Profile == common.AccessNoStairs && access.Stairs {
+		return "has stairs"
+	}
+	return ""
+}
+
+// stationUse is a place where a journey boards or alights a vehicle.
+type stationUse struct {
+	naptan      string
+	name        string
+	interchange bool
+}
+
+func stationUses(journey common.TFLJourney) []stationUse {
+	var uses []stationUse
+	for _, leg := range journey.Legs {
+		if leg.Mode.Name == "walking" {
+			continue
+		}
+		uses = append(uses,
+			stationUse{naptan: leg.DeparturePoint.NaptanID, name: leg.DeparturePoint.CommonName, interchange: true},
+			stationUse{naptan: leg.ArrivalPoint.NaptanID, name: leg.ArrivalPoint.CommonName, interchange: true})
+	}
+	if len(uses) > 0 {
+		uses[0].interchange = false
+		uses[len(uses)-1].interchange = false
+	}
+	return uses
+}
+
+// Violations vetoes journeys that change at a station the user cannot use.
+func (p *AccessibilityPolicy) Violations(journey common.TFLJourney) []string {
+	var reasons []string
+	seen := make(map[string]bool)
+	for _, use := range stationUses(journey) {
+		if !use.interchange || seen[use.naptan] {
+			continue
+		}
+		seen[use.naptan] = true
+		if why := p.barrier(use.naptan); why != "" {
+			reasons = append(reasons, fmt.Sprintf("changes at %s, which is %s", use.name, why))
+		}
+	}
+	return reasons
+}
+
+// Cost penalises what the profile makes harder without ruling the journey out: starting or
+// ending at an inaccessible station, stepping over a platform gap and changing at all.
+func (p *AccessibilityPolicy) Cost(journey common.TFLJourney) float64 {
+	if p == nil {
+		return 0
+	}
+
+	var cost float64
+	for _, use := range stationUses(journey) {
+		if !use.interchange && p.barrier(use.naptan) != "" {
+			cost += inaccessibleEndpointPenalty
+		}
+		if p.profile.Profile == common.AccessStepFree && p.index != nil {
+			if access, ok := p.index.Lookup(use.naptan); ok && access.StepFree == StepFreePlatform {
+				cost += platformGapPenalty
+			}
+		}
+	}
+	return cost + float64(CountChanges(journey)*p.profile.ExtraInterchangeMins)
+}
+
+// RouteUsesStation reports whether a chosen route boards, alights or changes at a station.
+func RouteUsesStation(route common.ChosenRoute, naptan string) bool {
+	target := NormalizeStopID(naptan)
+	for _, leg := range route.Legs {
+		if NormalizeStopID(leg.FromID) == target || NormalizeStopID(leg.ToID) == target {
+			return true
+		}
+	}
+	return false
+}
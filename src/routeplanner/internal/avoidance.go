@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/lines"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"strings"
+	"time"
+)
+
+// AvoidancePolicy is a user's Avoidance with station names resolved to NaPTAN codes, ready to
+// be checked against candidate journeys.
+type AvoidancePolicy struct {
+	lines     map[string]bool
+	peakLines map[string]bool
+	modes     map[string]bool
+	stations  map[string]string // NaPTAN -> display name
+}
+
+// NewAvoidancePolicy resolves the lines and stations of an Avoidance. It returns nil when
+// nothing is avoided.
+func NewAvoidancePolicy(avoid common.Avoidance, stations *StationResolver, registry *lines.Registry) (*AvoidancePolicy, error) {
+	if len(avoid.Lines)+len(avoid.PeakLines)+len(avoid.Modes)+len(avoid.Stations) == 0 {
+		return nil, nil
+	}
+
+	lineIDs, err := registry.Canonical(avoid.Lines)
+	if err != nil {
+		return nil, fmt.Errorf("avoided line: %w", err)
+	}
+	peakLineIDs, err := registry.Canonical(avoid.PeakLines)
+	if err != nil {
+		return nil, fmt.Errorf("line avoided at peak time: %w", err)
+	}
+	p := &AvoidancePolicy{
+		lines:     lowerSet(lineIDs),
+		peakLines: lowerSet(peakLineIDs),
+		modes:     lowerSet(avoid.Modes),
+		stations:  make(map[string]string, len(avoid.Stations)),
+	}
+	for _, name := range avoid.Stations {
+		st, err := stations.Resolve(NormalizeStopID(name))
+		if err != nil {
+			return nil, fmt.Errorf("avoided station: %w", err)
+		}
+		p.stations[st.Naptan] = st.Name
+	}
+	return p, nil
+}
+
+func lowerSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToLower(strings.TrimSpace(v))] = true
+	}
+	return set
+}
+
+// TfLModes returns the modes the Journey Planner should be restricted to, or nil for its defaults.
+func (p *AvoidancePolicy) TfLModes() []string {
+	if p == nil || len(p.modes) == 0 {
+		return nil
+	}
+	var modes []string
+	for _, mode := range tfl.Modes {
+		if !p.modes[mode] {
+			modes = append(modes, mode)
+		}
+	}
+	return modes
+}
+
+// Violations lists every reason a journey conflicts with the policy; empty means it is acceptable.
+func (p *AvoidancePolicy) Violations(journey common.TFLJourney) []string {
+	if p == nil {
+		return nil
+	}
+
+	var reasons []string
+	seen := make(map[string]bool)
+	add := func(reason string) {
+		if !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+
+	for _, leg := range journey.Legs {
+		if p.modes[strings.ToLower(leg.Mode.Name)] {
+			add(fmt.Sprintf("uses %s, an avoided mode", leg.Mode.Name))
+		}
+
+		for _, option := range leg.RouteOptions {
+			lineID := strings.ToLower(option.LineIdentifier.ID)
+			name := option.LineIdentifier.Name
+			if name == "" {
+				name = lineID
+			}
+			if p.lines[lineID] {
+				add(fmt.Sprintf("uses the %s line, which you avoid", name))
+			}
+			if p.peakLines[lineID] {
+				if dep, err := ParseTfLTime(leg.DepartureTime); err == nil && IsPeak(dep) {
+					add(fmt.Sprintf("uses the %s line at peak time (%s)", name, dep.Format("15:04")))
+				}
+			}
+		}
+
+		for _, point := range []common.StopPoint{leg.DeparturePoint, leg.ArrivalPoint} {
+			if name, ok := p.stations[NormalizeStopID(point.NaptanID)]; ok {
+				add(fmt.Sprintf("boards, alights or changes at %s, which you avoid", name))
+			}
+		}
+	}
+	return reasons
+}
+
+// IsPeak reports whether a time falls in TfL's weekday peak: 06:30-09:30 and 16:00-19:00.
+func IsPeak(t time.Time) bool {
+	t = t.In(tfl.London)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	minutes := t.Hour()*60 + t.Minute()
+	return (minutes >= 6*60+30 && minutes < 9*60+30) || (minutes >= 16*60 && minutes < 19*60)
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/patrickmn/go-cache"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CrowdingKey identifies the crowding profile of one stop on one weekday.
+type CrowdingKey struct {
+	Naptan  string
+	Weekday string
+}
+
+func (k CrowdingKey) cacheKey() string {
+	return fmt.Sprintf("%s/%s", k.Naptan, k.Weekday)
+}
+
+// CrowdingStats is a snapshot of the crowding cache counters. Misses are the lookups that went
+// to TfL; Shared are those that waited for another lookup of the same stop instead.
+type CrowdingStats struct {
+	Hits    uint64
+	Shared  uint64
+	Misses  uint64
+	Errors  uint64
+	HitRate float64 // share of the lookups that did not go to TfL
+}
+
+// CrowdingCache fetches TfL crowding profiles with a bounded number of concurrent requests
+// and keeps them for a TTL, since a (naptan, weekday) profile does not change during the day.
+type CrowdingCache struct {
+	cache   *cache.Cache
+	fetch   func(naptan, weekday string) (*common.CrowdingResp, error)
+	workers int
+
+	mu       sync.Mutex
+	inflight map[string]*inflightFetch
+
+	hits   atomic.Uint64
+	shared atomic.Uint64
+	misses atomic.Uint64
+	errors atomic.Uint64
+}
+
+type inflightFetch struct {
+	done chan struct{}
+	resp *common.CrowdingResp
+	err  error
+}
+
+func NewCrowdingCache(ttl time.Duration, workers int, fetch func(naptan, weekday string) (*common.CrowdingResp, error)) *CrowdingCache {
+	if workers < 1 {
+		workers = 1
+	}
+	return &CrowdingCache{
+		cache:    cache.New(ttl, 2*ttl),
+		fetch:    fetch,
+		workers:  workers,
+		inflight: make(map[string]*inflightFetch),
+	}
+}
+
+// Get returns the crowding profile for a single stop, hitting TfL only on a cache miss.
+// Concurrent misses for the same key share a single request.
+func (c *CrowdingCache) Get(naptan, weekday string) (*common.CrowdingResp, error) {
+	key := CrowdingKey{Naptan: naptan, Weekday: weekday}.cacheKey()
+
+	if cached, found := c.cache.Get(key); found {
+		c.hits.Add(1)
+		return cached.(*common.CrowdingResp), nil
+	}
+
+	c.mu.Lock()
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		c.shared.Add(1)
+		<-call.done
+		return call.resp, call.err
+	}
+	// The request for the key may have completed since the cache was read.
+	if cached, found := c.cache.Get(key); found {
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return cached.(*common.CrowdingResp), nil
+	}
+	call := &inflightFetch{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	call.resp, call.err = c.fetch(naptan, weekday)
+	if call.err != nil {
+		c.errors.Add(1)
+	} else {
+		c.cache.SetDefault(key, call.resp)
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.resp, call.err
+}
+
+// GetMany resolves all keys using at most c.workers concurrent requests.
+// Keys whose lookup failed are logged and left out of the result.
+func (c *CrowdingCache) GetMany(keys []CrowdingKey) map[CrowdingKey]*common.CrowdingResp {
+	results := make(map[CrowdingKey]*common.CrowdingResp, len(keys))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	jobs := make(chan CrowdingKey)
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range jobs {
+				resp, err := c.Get(key.Naptan, key.Weekday)
+				if err != nil {
+					log.Printf("Could not fetch crowding for stop %s: %v", key.Naptan, err)
+					continue
+				}
+				mu.Lock()
+				results[key] = resp
+				mu.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[CrowdingKey]struct{}, len(keys))
+	for _, key := range keys {
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		jobs <- key
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func (c *CrowdingCache) Stats() CrowdingStats {
+	stats := CrowdingStats{
+		Hits:   c.hits.Load(),
+		Shared: c.shared.Load(),
+		Misses: c.misses.Load(),
+		Errors: c.errors.Load(),
+	}
+	if total := stats.Hits + stats.Shared + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits+stats.Shared) / float64(total)
+	}
+	return stats
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingFetch is a fake TfL crowding request that waits to be released, counting the calls and
+// how many run at once.
+type blockingFetch struct {
+	release    chan struct{}
+	started    chan string
+	calls      atomic.Int64
+	running    atomic.Int64
+	maxRunning atomic.Int64
+}
+
+func newBlockingFetch() *blockingFetch {
+	return &blockingFetch{release: make(chan struct{}), started: make(chan string, 100)}
+}
+
+func (f *blockingFetch) fetch(naptan, weekday string) (*common.CrowdingResp, error) {
+	f.calls.Add(1)
+	running := f.running.Add(1)
+	defer f.running.Add(-1)
+	for {
+		highest := f.maxRunning.Load()
+		if running <= highest || f.maxRunning.CompareAndSwap(highest, running) {
+			break
+		}
+	}
+	f.started <- naptan
+	<-f.release
+	if naptan == "broken" {
+		return nil, errors.New("TfL is down")
+	}
+	return &common.CrowdingResp{Naptan: naptan, DayOfWeek: weekday}, nil
+}
+
+func TestCrowdingGetCoalesces(t *testing.T) {
+	fetch := newBlockingFetch()
+	c := NewCrowdingCache(time.Hour, 1, fetch.fetch)
+
+	const callers = 5
+	var wg sync.WaitGroup
+	responses := make([]*common.CrowdingResp, callers)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], _ = c.Get("940GZZLUBXN", "MON")
+		}()
+	}
+	<-fetch.started
+	for c.Stats().Shared < callers-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(fetch.release)
+	wg.Wait()
+
+	if calls := fetch.calls.Load(); calls != 1 {
+		t.Errorf("%d requests to TfL, want 1", calls)
+	}
+	for i, resp := range responses {
+		if resp == nil || resp != responses[0] {
+			t.Errorf("caller %d got %+v", i, resp)
+		}
+	}
+	if resp, err := c.Get("940GZZLUBXN", "MON"); err != nil || resp != responses[0] {
+		t.Errorf("cached Get = %+v, %v", resp, err)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Shared != callers-1 || stats.Misses != 1 || stats.Errors != 0 {
+		t.Errorf("stats %+v, want 1 hit, %d shared and 1 miss", stats, callers-1)
+	}
+	if want := float64(callers) / float64(callers+1); stats.HitRate != want {
+		t.Errorf("hit rate %v, want %v", stats.HitRate, want)
+	}
+}
+
+func TestCrowdingGetManyBoundsWorkers(t *testing.T) {
+	fetch := newBlockingFetch()
+	const workers = 3
+	c := NewCrowdingCache(time.Hour, workers, fetch.fetch)
+
+	var keys []CrowdingKey
+	for i := range 10 {
+		keys = append(keys, CrowdingKey{Naptan: fmt.Sprintf("stop%d", i), Weekday: "MON"})
+	}
+	keys = append(keys, keys[0], CrowdingKey{Naptan: "broken", Weekday: "MON"})
+
+	done := make(chan map[CrowdingKey]*common.CrowdingResp)
+	go func() { done <- c.GetMany(keys) }()
+
+	for range workers {
+		<-fetch.started
+	}
+	// With every worker busy, no other request may start.
+	select {
+	case naptan := <-fetch.started:
+		t.Errorf("request for %s started beyond the %d workers", naptan, workers)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(fetch.release)
+	results := <-done
+
+	if len(results) != 10 {
+		t.Errorf("%d results, want the 10 stops that did not fail", len(results))
+	}
+	if _, ok := results[CrowdingKey{Naptan: "broken", Weekday: "MON"}]; ok {
+		t.Error("failed lookup in the results")
+	}
+	if calls := fetch.calls.Load(); calls != 11 {
+		t.Errorf("%d requests to TfL, want one per distinct stop", calls)
+	}
+	if highest := fetch.maxRunning.Load(); highest != workers {
+		t.Errorf("%d requests ran at once, want %d", highest, workers)
+	}
+	if stats := c.Stats(); stats.Misses != 11 || stats.Errors != 1 {
+		t.Errorf("stats %+v, want 11 misses and 1 error", stats)
+	}
+}
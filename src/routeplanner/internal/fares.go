@@ -0,0 +1,464 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fares are estimated the way pay as you go charges them: consecutive legs on zonal modes
+// (tube, DLR, Overground, Elizabeth line) make one journey priced by the range of zones it
+// touches, flat-fare modes are priced per leg with bus and tram transfers free within the
+// hopper window, and the day's total never exceeds the daily cap.
+
+const (
+	fareKindZonal = "zonal"
+	fareKindFlat  = "flat"
+	fareKindCap   = "cap"
+
+	// hopperWindow is how long after a first bus or tram the next ones are free.
+	hopperWindow = time.Hour
+	// fareMinutesPerPound turns a fare into minute-equivalents for scoring, i.e. a value of time of £10 an hour.
+	fareMinutesPerPound = 6
+)
+
+var hopperModes = map[string]bool{"bus": true, "tram": true}
+
+type fare struct {
+	peak, offPeak int // pence
+}
+
+func (f fare) at(t time.Time) int {
+	if IsPeak(t) {
+		return f.peak
+	}
+	return f.offPeak
+}
+
+type zoneRange struct {
+	from, to int
+}
+
+type modeCap struct {
+	modes map[string]bool
+	pence int
+}
+
+// FareQuote is the estimated fare of one journey.
+type FareQuote struct {
+	Pence     int // what the journey adds to the day's spending, after capping
+	FullPence int // what it would cost without the daily cap
+	Capped    bool
+	// Known is false when the journey uses a mode or stations the fare tables do not cover.
+	Known bool
+}
+
+// Pounds returns the capped fare in pounds.
+func (q FareQuote) Pounds() float64 {
+	return float64(q.Pence) / 100
+}
+
+// fareTrip is a priced journey, as remembered for daily capping.
+type fareTrip struct {
+	pence int
+	zones zoneRange // zero when no zonal mode was used
+	modes map[string]bool
+}
+
+// FareEngine prices journeys from the local fare tables and keeps each user's spending of the
+// day so that later journeys are capped. With a ledger store, the spending is saved whenever a
+// journey is recorded and read back the first time a user is priced, so it survives restarts.
+type FareEngine struct {
+	zonalModes map[string]bool
+	zonal      map[zoneRange]fare
+	flat       map[string]fare
+	zoneCaps   map[zoneRange]int
+	modeCaps   []modeCap
+	zones      map[string][]int // NaPTAN -> zones, two for boundary stations
+
+	mu      sync.Mutex
+	ledgers map[string]fareLedger
+	loaded  map[string]bool // users whose ledger was read from the store
+	store   store.FareLedgerRepository
+	saveMu  sync.Mutex // keeps the saves of a ledger in the order it changed
+}
+
+type fareLedger struct {
+	day   string
+	trips []fareTrip
+}
+
+// NewFareEngine builds the engine from fares.csv and stationZones.csv records, headers included.
+//
+// fares.csv is "kind,modes,zone_from,zone_to,peak_pence,off_peak_pence", where kind is zonal,
+// flat or cap and modes is a "|"-separated list. Caps with zones apply to any day with zonal
+// travel in that range, whatever their modes; caps without zones apply to days spent only on
+// the listed modes.
+// stationZones.csv is "naptan,name,zone" with boundary zones written as "2/3".
+func NewFareEngine(fareRecords, zoneRecords [][]string) (*FareEngine, error) {
+	e := &FareEngine{
+		zonalModes: make(map[string]bool),
+		zonal:      make(map[zoneRange]fare),
+		flat:       make(map[string]fare),
+		zoneCaps:   make(map[zoneRange]int),
+		zones:      make(map[string][]int),
+		ledgers:    make(map[string]fareLedger),
+		loaded:     make(map[string]bool),
+	}
+
+	for i, record := range fareRecords {
+		if i == 0 {
+			continue
+		}
+		if len(record) < 6 {
+			return nil, fmt.Errorf("fare row %d: expected 6 columns, got %d", i+1, len(record))
+		}
+		peak, errPeak := strconv.Atoi(record[4])
+		offPeak, errOffPeak := strconv.Atoi(record[5])
+		if errPeak != nil || errOffPeak != nil {
+			return nil, fmt.Errorf("fare row %d: invalid amount", i+1)
+		}
+		modes := strings.Split(record[1], "|")
+
+		var zones zoneRange
+		if record[2] != "" || record[3] != "" {
+			from, errFrom := strconv.Atoi(record[2])
+			to, errTo := strconv.Atoi(record[3])
+			if errFrom != nil || errTo != nil || from > to {
+				return nil, fmt.Errorf("fare row %d: invalid zone range", i+1)
+			}
+			zones = zoneRange{from: from, to: to}
+		}
+
+		switch record[0] {
+		case fareKindZonal:
+			if zones.from == 0 {
+				return nil, fmt.Errorf("fare row %d: zonal fares need a zone range", i+1)
+			}
+			for _, mode := range modes {
+				e.zonalModes[mode] = true
+			}
+			e.zonal[zones] = fare{peak: peak, offPeak: offPeak}
+		case fareKindFlat:
+			for _, mode := range modes {
+				e.flat[mode] = fare{peak: peak, offPeak: offPeak}
+			}
+		case fareKindCap:
+			if zones.from != 0 {
+				e.zoneCaps[zones] = peak
+			} else {
+				e.modeCaps = append(e.modeCaps, modeCap{modes: lowerSet(modes), pence: peak})
+			}
+		default:
+			return nil, fmt.Errorf("fare row %d: unknown kind '%s'", i+1, record[0])
+		}
+	}
+
+	for i, record := range zoneRecords {
+		if i == 0 {
+			continue
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("zone row %d: expected 3 columns, got %d", i+1, len(record))
+		}
+		var zones []int
+		for _, part := range strings.Split(record[2], "/") {
+			zone, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("zone row %d: invalid zone '%s'", i+1, record[2])
+			}
+			zones = append(zones, zone)
+		}
+		sort.Ints(zones)
+		e.zones[record[0]] = zones
+	}
+	return e, nil
+}
+
+// price estimates the fare of a journey before capping.
+func (e *FareEngine) price(journey common.TFLJourney) (fareTrip, bool) {
+	trip := fareTrip{modes: make(map[string]bool)}
+	known := true
+
+	var chunk []common.TFLLeg
+	flush := func() {
+		if len(chunk) == 0 {
+			return
+		}
+		zones, ok := e.zoneRange(chunk)
+		if !ok {
+			known = false
+			zones = zoneRange{from: 1, to: 1}
+		}
+		if trip.zones.from == 0 || zones.from < trip.zones.from {
+			trip.zones.from = zones.from
+		}
+		if zones.to > trip.zones.to {
+			trip.zones.to = zones.to
+		}
+		dep, _ := ParseTfLTime(chunk[0].DepartureTime)
+		trip.pence += e.zonal[zones].at(dep)
+		chunk = nil
+	}
+
+	var hopperStart time.Time
+	for _, leg := range journey.Legs {
+		mode := strings.ToLower(leg.Mode.Name)
+		trip.modes[mode] = true
+		if e.zonalModes[mode] {
+			chunk = append(chunk, leg)
+			continue
+		}
+		if mode != "walking" {
+			// Leaving the zonal network ends the journey, e.g. a bus between two tube rides.
+			flush()
+		}
+
+		flat, ok := e.flat[mode]
+		if !ok {
+			known = false
+			continue
+		}
+		dep, _ := ParseTfLTime(leg.DepartureTime)
+		if hopperModes[mode] {
+			if !hopperStart.IsZero() && dep.Sub(hopperStart) < hopperWindow {
+				continue
+			}
+			hopperStart = dep
+		}
+		trip.pence += flat.at(dep)
+	}
+	flush()
+
+	return trip, known
+}
+
+// zoneRange returns the cheapest range of zones covering every stop of a zonal journey. A
+// boundary station counts in whichever of its zones keeps the range narrowest.
+func (e *FareEngine) zoneRange(legs []common.TFLLeg) (zoneRange, bool) {
+	var stations [][]int
+	visit := func(naptan string) {
+		if zones, ok := e.zones[NormalizeStopID(naptan)]; ok {
+			stations = append(stations, zones)
+		}
+	}
+	for _, leg := range legs {
+		visit(leg.DeparturePoint.NaptanID)
+		for _, stop := range leg.Path.StopPoints {
+			visit(stop.ID)
+		}
+		visit(leg.ArrivalPoint.NaptanID)
+	}
+	if len(stations) == 0 {
+		return zoneRange{}, false
+	}
+
+	r := zoneRange{from: stations[0][len(stations[0])-1], to: stations[0][0]}
+	for _, zones := range stations {
+		if highest := zones[len(zones)-1]; highest < r.from {
+			r.from = highest
+		}
+		if lowest := zones[0]; lowest > r.to {
+			r.to = lowest
+		}
+	}
+	if r.from > r.to {
+		r.to = r.from
+	}
+	if _, ok := e.zonal[r]; !ok {
+		return r, false
+	}
+	return r, true
+}
+
+// dailyCap returns the cap for a day made of the given trips, or false if none applies.
+func (e *FareEngine) dailyCap(trips []fareTrip) (int, bool) {
+	var zones zoneRange
+	modes := make(map[string]bool)
+	for _, trip := range trips {
+		if trip.zones.from != 0 && (zones.from == 0 || trip.zones.from < zones.from) {
+			zones.from = trip.zones.from
+		}
+		if trip.zones.to > zones.to {
+			zones.to = trip.zones.to
+		}
+		for mode := range trip.modes {
+			if mode != "walking" {
+				modes[mode] = true
+			}
+		}
+	}
+
+	if zones.from != 0 {
+		limit, ok := e.zoneCaps[zones]
+		return limit, ok
+	}
+	for _, mc := range e.modeCaps {
+		covered := true
+		for mode := range modes {
+			if !mc.modes[mode] {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return mc.pence, true
+		}
+	}
+	return 0, false
+}
+
+func fareDay(journey common.TFLJourney) string {
+	start, _, ok := JourneyWindow(journey)
+	if !ok {
+		start = time.Now()
+	}
+	return start.In(tfl.London).Format("2006-01-02")
+}
+
+// SetLedgerStore makes the engine keep the users' spending in a store.
+func (e *FareEngine) SetLedgerStore(repo store.FareLedgerRepository) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.store = repo
+}
+
+// ledgerTimeout bounds reading a ledger from the store while a journey is priced.
+const ledgerTimeout = 2 * time.Second
+
+// load reads the ledger of a user from the store, the first time they are priced. A failed read
+// is logged and tried again next time, pricing without the earlier journeys meanwhile.
+func (e *FareEngine) load(userID string) {
+	e.mu.Lock()
+	repo, done := e.store, e.loaded[userID]
+	e.mu.Unlock()
+	if repo == nil || done {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ledgerTimeout)
+	defer cancel()
+	stored, err := repo.GetFareLedger(ctx, userID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Pricing without the earlier journeys of %s: %v", userID, err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.loaded[userID] {
+		return
+	}
+	e.loaded[userID] = true
+	if stored == nil {
+		return
+	}
+	if ledger, ok := e.ledgers[userID]; ok && ledger.day >= stored.Day {
+		return // recorded while the store was unreachable
+	}
+	ledger := fareLedger{day: stored.Day}
+	for _, t := range stored.Trips {
+		trip := fareTrip{pence: t.Pence, zones: zoneRange{from: t.ZoneFrom, to: t.ZoneTo}, modes: make(map[string]bool)}
+		for _, mode := range t.Modes {
+			trip.modes[mode] = true
+		}
+		ledger.trips = append(ledger.trips, trip)
+	}
+	e.ledgers[userID] = ledger
+}
+
+// tripsOn returns the trips a user already made on a day. The caller must hold the lock.
+func (e *FareEngine) tripsOn(userID, day string) []fareTrip {
+	ledger, ok := e.ledgers[userID]
+	if !ok || ledger.day != day {
+		return nil
+	}
+	return ledger.trips
+}
+
+func (e *FareEngine) quote(userID string, journey common.TFLJourney) (FareQuote, fareTrip) {
+	trip, known := e.price(journey)
+	quote := FareQuote{Pence: trip.pence, FullPence: trip.pence, Known: known}
+
+	earlier := e.tripsOn(userID, fareDay(journey))
+	if len(earlier) == 0 {
+		return quote, trip
+	}
+	var spent int
+	for _, t := range earlier {
+		spent += t.pence
+	}
+	if limit, ok := e.dailyCap(append(append([]fareTrip(nil), earlier...), trip)); ok && spent+trip.pence > limit {
+		quote.Pence = limit - spent
+		if quote.Pence < 0 {
+			quote.Pence = 0
+		}
+		quote.Capped = true
+	}
+	trip.pence = quote.Pence
+	return quote, trip
+}
+
+// Quote estimates what a journey would cost the user, given the journeys they already made that day.
+func (e *FareEngine) Quote(userID string, journey common.TFLJourney) FareQuote {
+	if e == nil {
+		return FareQuote{}
+	}
+	e.load(userID)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	quote, _ := e.quote(userID, journey)
+	return quote
+}
+
+// Record adds a journey the user committed to to their spending of the day, and saves it to the
+// ledger store if there is one. A failed save is returned; the journey still counts until restart.
+func (e *FareEngine) Record(ctx context.Context, userID string, journey common.TFLJourney) (FareQuote, error) {
+	if e == nil {
+		return FareQuote{}, nil
+	}
+	e.load(userID)
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
+
+	e.mu.Lock()
+	quote, trip := e.quote(userID, journey)
+	day := fareDay(journey)
+	ledger := e.ledgers[userID]
+	if ledger.day != day {
+		ledger = fareLedger{day: day}
+	}
+	ledger.trips = append(ledger.trips, trip)
+	e.ledgers[userID] = ledger
+	repo := e.store
+	e.mu.Unlock()
+
+	if repo == nil {
+		return quote, nil
+	}
+	stored := common.FareLedger{UserID: userID, Day: ledger.day}
+	for _, t := range ledger.trips {
+		modes := make([]string, 0, len(t.modes))
+		for mode := range t.modes {
+			modes = append(modes, mode)
+		}
+		sort.Strings(modes)
+		stored.Trips = append(stored.Trips, common.FareTrip{Pence: t.pence, ZoneFrom: t.zones.from, ZoneTo: t.zones.to, Modes: modes})
+	}
+	if err := repo.SaveFareLedger(ctx, stored); err != nil {
+		return quote, fmt.Errorf("failed to save fare ledger of %s: %w", userID, err)
+	}
+	return quote, nil
+}
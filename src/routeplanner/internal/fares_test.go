@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFares = `kind,modes,zone_from,zone_to,peak_pence,off_peak_pence
+zonal,tube|dlr,1,1,290,280
+zonal,tube|dlr,1,2,350,290
+zonal,tube|dlr,2,2,210,200
+flat,bus|tram,,,175,175
+flat,walking,,,0,0
+cap,any,1,1,890,890
+cap,any,1,2,890,890
+cap,any,2,2,800,800
+cap,bus|tram,,,525,525`
+
+// Bank is in zone 1, Angel and Holloway Road in zone 2 and Highbury & Islington on the 2/3
+// boundary; zone 3 has no fares, so it never widens a range here.
+const testZones = `naptan,name,zone
+9400ZZLUBNK,Bank,1
+9400ZZLUAGL,Angel,2
+9400ZZLUHWY,Holloway Road,2
+9400ZZLUHAI,Highbury & Islington,2/3`
+
+func testFareEngine(t *testing.T) *FareEngine {
+	t.Helper()
+	fares, err := csv.NewReader(strings.NewReader(testFares)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones, err := csv.NewReader(strings.NewReader(testZones)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewFareEngine(fares, zones)
+	if err != nil {
+		t.Fatalf("NewFareEngine: %v", err)
+	}
+	return engine
+}
+
+// fareLeg is a twenty-minute leg departing at a London time such as "2026-10-19T10:00:00".
+func fareLeg(mode, from, to, departure string) common.TFLLeg {
+	dep, err := ParseTfLTime(departure)
+	if err != nil {
+		panic(err)
+	}
+	return common.TFLLeg{
+		DepartureTime:  departure,
+		ArrivalTime:    dep.Add(20 * time.Minute).Format(tflTimeLayout),
+		DeparturePoint: common.StopPoint{NaptanID: from},
+		ArrivalPoint:   common.StopPoint{NaptanID: to},
+		Mode:           common.Mode{Name: mode},
+	}
+}
+
+func fareJourney(legs ...common.TFLLeg) common.TFLJourney {
+	return common.TFLJourney{Legs: legs}
+}
+
+func TestFareEngineRecord(t *testing.T) {
+	// Monday 19 October 2026: 08:00 is peak, 10:00 off-peak.
+	type trip struct {
+		journey    common.TFLJourney
+		wantPence  int
+		wantCapped bool
+	}
+	tests := []struct {
+		name  string
+		trips []trip
+	}{
+		{"zone 1 to 2 at peak", []trip{
+			{fareJourney(fareLeg("tube", "940GZZLUBNK", "940GZZLUAGL", "2026-10-19T08:00:00")), 350, false},
+		}},
+		{"boundary station keeps the range narrow", []trip{
+			{fareJourney(fareLeg("tube", "940GZZLUAGL", "940GZZLUHAI", "2026-10-19T10:00:00")), 200, false},
+		}},
+		{"walking between tube legs is one journey", []trip{
+			{fareJourney(
+				fareLeg("tube", "940GZZLUBNK", "940GZZLUAGL", "2026-10-19T10:00:00"),
+				fareLeg("walking", "940GZZLUAGL", "940GZZLUAGL", "2026-10-19T10:20:00"),
+				fareLeg("tube", "940GZZLUAGL", "940GZZLUHWY", "2026-10-19T10:40:00"),
+			), 290, false},
+		}},
+		{"a bus between tube legs splits the journey", []trip{
+			{fareJourney(
+				fareLeg("tube", "940GZZLUBNK", "940GZZLUBNK", "2026-10-19T10:00:00"),
+				fareLeg("bus", "", "", "2026-10-19T10:20:00"),
+				fareLeg("tube", "940GZZLUAGL", "940GZZLUHWY", "2026-10-19T10:40:00"),
+			), 280 + 175 + 200, false},
+		}},
+		{"bus hopper", []trip{
+			{fareJourney(
+				fareLeg("bus", "", "", "2026-10-19T10:00:00"),
+				fareLeg("bus", "", "", "2026-10-19T10:50:00"),
+				fareLeg("bus", "", "", "2026-10-19T11:10:00"),
+			), 350, false},
+		}},
+		{"a bus-only day has the bus cap", []trip{
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T08:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T10:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T12:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T14:00:00")), 0, true},
+		}},
+		{"a bus after the tube has the zone cap", []trip{
+			{fareJourney(fareLeg("tube", "940GZZLUAGL", "940GZZLUHWY", "2026-10-19T08:00:00")), 210, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T10:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T12:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T14:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T16:00:00")), 65, true},
+		}},
+		{"partly capped", []trip{
+			{fareJourney(fareLeg("tube", "940GZZLUBNK", "940GZZLUBNK", "2026-10-19T08:00:00")), 290, false},
+			{fareJourney(fareLeg("tube", "940GZZLUBNK", "940GZZLUBNK", "2026-10-19T09:00:00")), 290, false},
+			{fareJourney(fareLeg("tube", "940GZZLUBNK", "940GZZLUBNK", "2026-10-19T17:00:00")), 290, false},
+			{fareJourney(fareLeg("tube", "940GZZLUBNK", "940GZZLUBNK", "2026-10-19T18:00:00")), 20, true},
+			{fareJourney(fareLeg("tube", "940GZZLUBNK", "940GZZLUBNK", "2026-10-19T18:30:00")), 0, true},
+		}},
+		{"widening the zones raises the cap", []trip{
+			{fareJourney(fareLeg("tube", "940GZZLUAGL", "940GZZLUHWY", "2026-10-19T08:00:00")), 210, false},
+			{fareJourney(fareLeg("tube", "940GZZLUAGL", "940GZZLUHWY", "2026-10-19T08:30:00")), 210, false},
+			{fareJourney(fareLeg("tube", "940GZZLUAGL", "940GZZLUHWY", "2026-10-19T09:00:00")), 210, false},
+			{fareJourney(fareLeg("tube", "940GZZLUAGL", "940GZZLUHWY", "2026-10-19T16:00:00")), 170, true},
+			{fareJourney(fareLeg("tube", "940GZZLUBNK", "940GZZLUAGL", "2026-10-19T17:00:00")), 90, true},
+		}},
+		{"the cap resets at midnight, London time", []trip{
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T20:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T21:30:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-19T23:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-20T00:30:00")), 175, false},
+		}},
+		{"the cap resets across the end of summer time", []trip{
+			{fareJourney(fareLeg("bus", "", "", "2026-10-24T20:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-24T21:30:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-24T23:00:00")), 175, false},
+			{fareJourney(fareLeg("bus", "", "", "2026-10-25T00:30:00")), 175, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := testFareEngine(t)
+			repo := store.NewMemory()
+			engine.SetLedgerStore(repo)
+
+			var spent int
+			for i, trip := range tt.trips {
+				if quote := engine.Quote("alice", trip.journey); quote.Pence != trip.wantPence || quote.Capped != trip.wantCapped {
+					t.Errorf("trip %d: Quote = %d (capped %v), want %d (capped %v)", i+1, quote.Pence, quote.Capped, trip.wantPence, trip.wantCapped)
+				}
+				quote, err := engine.Record(context.Background(), "alice", trip.journey)
+				if err != nil {
+					t.Fatalf("trip %d: Record: %v", i+1, err)
+				}
+				if !quote.Known || quote.Pence != trip.wantPence || quote.Capped != trip.wantCapped {
+					t.Errorf("trip %d: Record = %+v, want %d (capped %v)", i+1, quote, trip.wantPence, trip.wantCapped)
+				}
+				spent += quote.Pence
+			}
+
+			ledger, err := repo.GetFareLedger(context.Background(), "alice")
+			if err != nil {
+				t.Fatalf("GetFareLedger: %v", err)
+			}
+			if want := fareDay(tt.trips[len(tt.trips)-1].journey); ledger.Day != want {
+				t.Errorf("stored day %s, want %s", ledger.Day, want)
+			}
+			if ledger.Day == fareDay(tt.trips[0].journey) {
+				var stored int
+				for _, trip := range ledger.Trips {
+					stored += trip.Pence
+				}
+				if stored != spent {
+					t.Errorf("stored %d pence, recorded %d", stored, spent)
+				}
+			}
+		})
+	}
+}
+
+func TestFareEngineUnknownFare(t *testing.T) {
+	engine := testFareEngine(t)
+	if quote := engine.Quote("alice", fareJourney(fareLeg("cable-car", "", "", "2026-10-19T10:00:00"))); quote.Known {
+		t.Errorf("cable car quoted as known: %+v", quote)
+	}
+	if quote := engine.Quote("alice", fareJourney(fareLeg("tube", "940GZZLUXXX", "940GZZLUYYY", "2026-10-19T10:00:00"))); quote.Known {
+		t.Errorf("stations without zones quoted as known: %+v", quote)
+	}
+}
+
+// flakyLedgers fails to read ledgers while down.
+type flakyLedgers struct {
+	store.FareLedgerRepository
+	down bool
+}
+
+func (f *flakyLedgers) GetFareLedger(ctx context.Context, userID string) (*common.FareLedger, error) {
+	if f.down {
+		return nil, errors.New("store down")
+	}
+	return f.FareLedgerRepository.GetFareLedger(ctx, userID)
+}
+
+func TestFareEngineReloadsAfterStoreFailure(t *testing.T) {
+	repo := store.NewMemory()
+	before := testFareEngine(t)
+	before.SetLedgerStore(repo)
+	for _, departure := range []string{"2026-10-19T08:00:00", "2026-10-19T09:00:00", "2026-10-19T17:00:00"} {
+		if _, err := before.Record(context.Background(), "alice", fareJourney(fareLeg("tube", "940GZZLUBNK", "940GZZLUBNK", departure))); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	// After a restart, the ledger cannot be read at first: the journey is priced in full.
+	flaky := &flakyLedgers{FareLedgerRepository: repo, down: true}
+	after := testFareEngine(t)
+	after.SetLedgerStore(flaky)
+	journey := fareJourney(fareLeg("tube", "940GZZLUBNK", "940GZZLUBNK", "2026-10-19T18:00:00"))
+	if quote := after.Quote("alice", journey); quote.Pence != 290 || quote.Capped {
+		t.Errorf("while the store is down, Quote = %+v, want 290 uncapped", quote)
+	}
+
+	flaky.down = false
+	if quote := after.Quote("alice", journey); quote.Pence != 20 || !quote.Capped {
+		t.Errorf("once the store is back, Quote = %+v, want 20 capped", quote)
+	}
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"math"
+	"time"
+)
+
+// completionSlack is how long before its planned arrival a terminated journey still counts as completed.
+const completionSlack = 5 * time.Minute
+
+// NewJourneyID returns a random identifier for a journey.
+func NewJourneyID() string {
+	return randomID()
+}
+
+// RouteArrival returns when a route is timetabled to arrive, if its legs carry times.
+func RouteArrival(route common.ChosenRoute) (time.Time, bool) {
+	if len(route.Legs) == 0 {
+		return time.Time{}, false
+	}
+	arrival, err := ParseTfLTime(route.Legs[len(route.Legs)-1].EndTime)
+	return arrival, err == nil
+}
+
+// StartJourney marks a chosen route as the start of a new journey.
+func StartJourney(route *common.ChosenRoute, now time.Time) {
+	route.JourneyID = NewJourneyID()
+	route.StartedAt = now
+	route.PlannedArrival, _ = RouteArrival(*route)
+	route.Recalculations = nil
+}
+
+// ContinueJourney carries the journey of a route over to the route replacing it, and records the switch.
+func ContinueJourney(previous common.ChosenRoute, next *common.ChosenRoute, reason string, lines []string, now time.Time) {
+	if previous.JourneyID == "" {
+		// A route chosen before journeys were recorded starts its journey now.
+		StartJourney(&previous, now)
+	}
+	next.JourneyID = previous.JourneyID
+	next.StartedAt = previous.StartedAt
+	next.PlannedArrival = previous.PlannedArrival
+
+	event := common.RecalculationEvent{At: now, Reason: reason, Lines: lines, Previous: previous.Description}
+	before, okBefore := RouteArrival(previous)
+	after, okAfter := RouteArrival(*next)
+	if okBefore && okAfter && after.After(before) {
+		event.MinutesLost = after.Sub(before).Minutes()
+	}
+	next.Recalculations = append(append([]common.RecalculationEvent(nil), previous.Recalculations...), event)
+}
+
+// ArchiveJourney turns the route a journey ended on into its history record. The journey is
+// completed if the rider got close to the destination, going by their latest check-in.
+func ArchiveJourney(route common.ChosenRoute, reason string, checkIn *CheckInRecord, now time.Time) common.JourneyRecord {
+	record := common.JourneyRecord{
+		JourneyID:      route.JourneyID,
+		UserID:         route.UserID,
+		Outcome:        common.JourneyCompleted,
+		Reason:         reason,
+		StartedAt:      route.StartedAt,
+		EndedAt:        now,
+		PlannedArrival: route.PlannedArrival,
+		Legs:           route.Legs,
+		Recalculations: route.Recalculations,
+	}
+	if record.JourneyID == "" {
+		record.JourneyID = NewJourneyID()
+	}
+	if record.StartedAt.IsZero() {
+		record.StartedAt = now
+	}
+	if len(route.Legs) > 0 {
+		record.From = route.Legs[0].From
+		record.To = route.Legs[len(route.Legs)-1].To
+	}
+
+	seen := make(map[string]bool)
+	for _, leg := range route.Legs {
+		if leg.LineName != "" && !seen[leg.LineName] {
+			seen[leg.LineName] = true
+			record.Lines = append(record.Lines, leg.LineName)
+		}
+	}
+	for _, event := range route.Recalculations {
+		record.TimeLostMins += event.MinutesLost
+	}
+
+	if arrival, ok := RouteArrival(route); ok {
+		if checkIn != nil {
+			arrival = arrival.Add(checkIn.Delay())
+		}
+		if now.Before(arrival.Add(-completionSlack)) {
+			record.Outcome = common.JourneyAborted
+		}
+		if !route.PlannedArrival.IsZero() {
+			record.DelayMins = math.Max(0, arrival.Sub(route.PlannedArrival).Minutes())
+		}
+	}
+	return record
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LineStatusModes are the modes whose live status is kept for planning.
+var LineStatusModes = []string{"tube", "dlr", "overground", "elizabeth-line", "tram"}
+
+// SeverityLevel maps a TfL status severity to a disruption level between 0 (good service) and 1 (closed).
+func SeverityLevel(severity int) float64 {
+	switch severity {
+	case 1, 2, 4, 16, 20: // Closed, Suspended, Planned Closure, Not Running, Service Closed
+		return 1
+	case 3, 5, 11: // Part Suspended, Part Closure, Part Closed
+		return 0.8
+	case 6: // Severe Delays
+		return 0.75
+	case 7: // Reduced Service
+		return 0.4
+	case 8, 15, 17: // Bus Service, Diverted, Issues Reported
+		return 0.3
+	case 9: // Minor Delays
+		return 0.25
+	case 14: // Change of frequency
+		return 0.2
+	case 12: // Exit Only
+		return 0.1
+	}
+	return 0
+}
+
+type lineState struct {
+	level       float64
+	description string
+}
+
+// LineStatusView is the latest status of every line, as polled from TfL. It implements
+// LineStatusSource; a view older than maxAge is ignored rather than trusted.
+type LineStatusView struct {
+	mu        sync.RWMutex
+	maxAge    time.Duration
+	lines     map[string]lineState
+	updatedAt time.Time
+}
+
+func NewLineStatusView(maxAge time.Duration) *LineStatusView {
+	return &LineStatusView{
+		maxAge: maxAge,
+		lines:  make(map[string]lineState),
+	}
+}
+
+// Update replaces the view with a TfL line status response. A line with several statuses
+// keeps the worst one in effect now.
+func (v *LineStatusView) Update(resp common.TfLLineStatusResponse) {
+	lines := make(map[string]lineState, len(resp))
+	for _, line := range resp {
+		state := lineState{description: "Good Service"}
+		for _, status := range line.LineStatuses {
+			if !inEffect(status) {
+				continue
+			}
+			if level := SeverityLevel(status.StatusSeverity); level > state.level || state.level == 0 {
+				state = lineState{level: level, description: status.StatusSeverityDescription}
+			}
+		}
+		lines[strings.ToLower(line.ID)] = state
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.lines = lines
+	v.updatedAt = time.Now()
+}
+
+// inEffect reports whether a status applies now; statuses without validity periods always do.
+func inEffect(status common.LineStatus) bool {
+	if len(status.ValidityPeriods) == 0 {
+		return true
+	}
+	for _, period := range status.ValidityPeriods {
+		if period.IsNow {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *LineStatusView) lookup(lineID string) (lineState, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.updatedAt.IsZero() || time.Since(v.updatedAt) > v.maxAge {
+		return lineState{}, false
+	}
+	state, ok := v.lines[strings.ToLower(lineID)]
+	return state, ok
+}
+
+func (v *LineStatusView) Disruption(lineID string) float64 {
+	state, _ := v.lookup(lineID)
+	return state.level
+}
+
+// Status returns the current status description of a line, e.g. "Severe Delays".
+func (v *LineStatusView) Status(lineID string) (string, bool) {
+	state, ok := v.lookup(lineID)
+	return state.description, ok
+}
+
+// WorstOf combines line status sources, reporting the worst level any of them knows of.
+type WorstOf []LineStatusSource
+
+func (w WorstOf) Disruption(lineID string) float64 {
+	var worst float64
+	for _, source := range w {
+		if level := source.Disruption(lineID); level > worst {
+			worst = level
+		}
+	}
+	return worst
+}
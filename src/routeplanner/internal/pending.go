@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"sync"
+	"time"
+)
+
+// RankedJourney is a candidate TfL journey together with the score it was ranked by.
+type RankedJourney struct {
+	Journey   common.TFLJourney
+	Score     float64
+	Breakdown []common.ScoreComponent
+	Fare      FareQuote
+	// Set for multi-stop trips: the leg count of each segment and the waypoints between them.
+	Segments  []int
+	Waypoints []common.RouteWaypoint
+}
+
+type pendingEntry struct {
+	journeys  []RankedJourney
+	expiresAt time.Time
+}
+
+// PendingStore keeps the ranked journeys offered to each user until one of them is confirmed.
+type PendingStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]pendingEntry
+}
+
+func NewPendingStore(ttl time.Duration) *PendingStore {
+	return &PendingStore{
+		ttl:     ttl,
+		entries: make(map[string]pendingEntry),
+	}
+}
+
+// Put replaces the alternatives offered to a user. Journeys must already be sorted by rank.
+func (p *PendingStore) Put(userID string, journeys []RankedJourney) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.entries[userID] = pendingEntry{
+		journeys:  journeys,
+		expiresAt: time.Now().Add(p.ttl),
+	}
+}
+
+// Take returns the journey with the given 1-based rank and forgets the other alternatives.
+func (p *PendingStore) Take(userID string, rank int) (*RankedJourney, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[userID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(p.entries, userID)
+		return nil, fmt.Errorf("no pending alternatives for user %s", userID)
+	}
+
+	if rank < 1 || rank > len(entry.journeys) {
+		return nil, fmt.Errorf("invalid alternative %d: %d alternatives available", rank, len(entry.journeys))
+	}
+
+	delete(p.entries, userID)
+	selected := entry.journeys[rank-1]
+	return &selected, nil
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/lines"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"sync"
+	"time"
+)
+
+// PlanningProfile gathers the per-user policies that shape a journey search: what to avoid and
+// which stations the user can physically use. A nil profile plans with TfL's defaults.
+type PlanningProfile struct {
+	Avoid  *AvoidancePolicy
+	Access *AccessibilityPolicy
+}
+
+// NewPlanningProfile builds the policies of a user's preferences. It returns nil when none apply.
+func NewPlanningProfile(prefs common.UserPreferences, stations *StationResolver, registry *lines.Registry, index *AccessibilityIndex) (*PlanningProfile, error) {
+	avoid, err := NewAvoidancePolicy(prefs.Avoid, stations, registry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid avoidance preferences: %w", err)
+	}
+	access, err := NewAccessibilityPolicy(prefs.Access, index)
+	if err != nil {
+		return nil, fmt.Errorf("invalid accessibility preferences: %w", err)
+	}
+	if avoid == nil && access == nil {
+		return nil, nil
+	}
+	return &PlanningProfile{Avoid: avoid, Access: access}, nil
+}
+
+// Apply restricts a Journey Planner query to what the profile allows.
+func (p *PlanningProfile) Apply(q *tfl.JourneyQuery) {
+	if p == nil {
+		return
+	}
+	q.Modes = p.Avoid.TfLModes()
+	q.Accessibility = p.Access.TfLPreferences()
+}
+
+// Violations lists every reason a journey conflicts with the profile; empty means it is acceptable.
+func (p *PlanningProfile) Violations(journey common.TFLJourney) []string {
+	if p == nil {
+		return nil
+	}
+	return append(p.Avoid.Violations(journey), p.Access.Violations(journey)...)
+}
+
+// Accessibility returns the accessibility policy of the profile, nil if there is none.
+func (p *PlanningProfile) Accessibility() *AccessibilityPolicy {
+	if p == nil {
+		return nil
+	}
+	return p.Access
+}
+
+// PreferenceStore caches the preferences users saved with the user service, so that
+// recalculations and alerts made on their behalf respect them. A user's preferences are fetched
+// with the loader on first use and fetched again once they are older than the store's maximum
+// age. The one-off preferences sent with a planning request are never stored.
+type PreferenceStore struct {
+	mu     sync.Mutex
+	users  map[string]storedPreferences
+	load   func(userID string) (common.UserPreferences, error)
+	maxAge time.Duration
+}
+
+type storedPreferences struct {
+	prefs  common.UserPreferences
+	stored time.Time
+}
+
+func NewPreferenceStore() *PreferenceStore {
+	return &PreferenceStore{users: make(map[string]storedPreferences)}
+}
+
+// SetLoader makes the store fetch the preferences it is missing, or holds for longer than maxAge.
+func (s *PreferenceStore) SetLoader(load func(userID string) (common.UserPreferences, error), maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.load, s.maxAge = load, maxAge
+}
+
+// Get returns the preferences of a user. If they cannot be fetched, it returns those it holds,
+// if any, along with the error.
+func (s *PreferenceStore) Get(userID string) (common.UserPreferences, error) {
+	s.mu.Lock()
+	stored, ok := s.users[userID]
+	load, maxAge := s.load, s.maxAge
+	s.mu.Unlock()
+
+	if load == nil || (ok && time.Since(stored.stored) < maxAge) {
+		return stored.prefs, nil
+	}
+	prefs, err := load(userID)
+	if err != nil {
+		return stored.prefs, fmt.Errorf("failed to fetch preferences of %s: %w", userID, err)
+	}
+	prefs.UserID = userID
+	s.mu.Lock()
+	s.users[userID] = storedPreferences{prefs: prefs, stored: time.Now()}
+	s.mu.Unlock()
+	return prefs, nil
+}
@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"math"
+	"sync"
+	"time"
+)
+
+// Journey progress is read off the chosen route timetable: at any moment the rider is either
+// riding a leg, walking one, or waiting for the next leg to depart. A check-in tells us how far
+// behind (or ahead of) the timetable the rider is, and shifts the whole estimate by that delay.
+
+const (
+	waitConfidence = 0.9
+	walkConfidence = 0.7
+	// A ride estimate is most reliable at the ends of a leg and least reliable half way.
+	rideConfidenceMax = 0.9
+	rideConfidenceMin = 0.5
+	// checkInFreshness is how long a check-in keeps raising the confidence of an estimate.
+	checkInFreshness = 30 * time.Minute
+)
+
+// CheckInRecord is a check-in matched to the point of the active route where it was scheduled.
+type CheckInRecord struct {
+	Naptan    string
+	Scheduled time.Time
+	At        time.Time
+}
+
+// Delay is how late the rider is compared to the timetable of their route.
+func (c CheckInRecord) Delay() time.Duration {
+	return c.At.Sub(c.Scheduled)
+}
+
+type checkInEntry struct {
+	record    CheckInRecord
+	expiresAt time.Time
+}
+
+// CheckInStore keeps the latest check-in of each rider while their journey is active.
+type CheckInStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]checkInEntry
+}
+
+func NewCheckInStore(ttl time.Duration) *CheckInStore {
+	return &CheckInStore{
+		ttl:     ttl,
+		entries: make(map[string]checkInEntry),
+	}
+}
+
+func (s *CheckInStore) Put(userID string, record CheckInRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[userID] = checkInEntry{record: record, expiresAt: time.Now().Add(s.ttl)}
+}
+
+// Latest returns the most recent check-in of a user, or nil if there is none.
+func (s *CheckInStore) Latest(userID string) *CheckInRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[userID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(s.entries, userID)
+		return nil
+	}
+	record := entry.record
+	return &record
+}
+
+// Forget drops the check-in of a user, e.g. because their route was replaced.
+func (s *CheckInStore) Forget(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, userID)
+}
+
+type legWindow struct {
+	dep, arr time.Time
+}
+
+func legWindows(route common.ChosenRoute) ([]legWindow, error) {
+	windows := make([]legWindow, len(route.Legs))
+	for i, leg := range route.Legs {
+		dep, err := ParseTfLTime(leg.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("leg %d has an invalid start time: %w", i, err)
+		}
+		arr, err := ParseTfLTime(leg.EndTime)
+		if err != nil {
+			return nil, fmt.Errorf("leg %d has an invalid end time: %w", i, err)
+		}
+		windows[i] = legWindow{dep: dep, arr: arr}
+	}
+	return windows, nil
+}
+
+// scheduledAt spreads the stops of a leg evenly over its running time.
+func (w legWindow) scheduledAt(stopIndex, stops int) time.Time {
+	if stops < 2 {
+		return w.dep
+	}
+	return w.dep.Add(w.arr.Sub(w.dep) * time.Duration(stopIndex) / time.Duration(stops-1))
+}
+
+// MatchCheckIn finds where a station appears on the route. When it appears more than once the
+// occurrence scheduled closest to the check-in time wins.
+func MatchCheckIn(route common.ChosenRoute, naptan string, at time.Time) (CheckInRecord, error) {
+	windows, err := legWindows(route)
+	if err != nil {
+		return CheckInRecord{}, err
+	}
+
+	target := NormalizeStopID(naptan)
+	var best *CheckInRecord
+	consider := func(stopID string, scheduled time.Time) {
+		if NormalizeStopID(stopID) != target {
+			return
+		}
+		if best == nil || absDuration(at.Sub(scheduled)) < absDuration(at.Sub(best.Scheduled)) {
+			best = &CheckInRecord{Naptan: target, Scheduled: scheduled, At: at}
+		}
+	}
+
+	for i, leg := range route.Legs {
+		consider(leg.FromID, windows[i].dep)
+		for j, stopID := range leg.StopIDs {
+			consider(stopID, windows[i].scheduledAt(j, len(leg.StopIDs)))
+		}
+		consider(leg.ToID, windows[i].arr)
+	}
+
+	if best == nil {
+		return CheckInRecord{}, fmt.Errorf("station %s is not on the active route", naptan)
+	}
+	return *best, nil
+}
+
+// EstimatePosition places the rider on their route at the given time, shifted by the delay
+// revealed by their latest check-in if there is one.
+func EstimatePosition(route common.ChosenRoute, now time.Time, checkIn *CheckInRecord) (common.JourneyPosition, error) {
+	if len(route.Legs) == 0 {
+		return common.JourneyPosition{}, fmt.Errorf("no legs in the route")
+	}
+	windows, err := legWindows(route)
+	if err != nil {
+		return common.JourneyPosition{}, err
+	}
+
+	// The point of the timetable the rider has reached.
+	t := now
+	if checkIn != nil {
+		t = now.Add(-checkIn.Delay())
+	}
+
+	if !t.Before(windows[len(windows)-1].arr) {
+		return common.JourneyPosition{}, fmt.Errorf("journey already completed")
+	}
+
+	var position common.JourneyPosition
+	for i, leg := range route.Legs {
+		w := windows[i]
+		if t.Before(w.dep) {
+			position = waitingAt(i, leg)
+			break
+		}
+		if t.Before(w.arr) {
+			if isWalking(leg) {
+				position = walking(i, leg)
+			} else {
+				progress := t.Sub(w.dep).Seconds() / w.arr.Sub(w.dep).Seconds()
+				position = riding(i, leg, progress)
+			}
+			break
+		}
+	}
+
+	if checkIn != nil {
+		position.CheckedIn = true
+		position.DelayMins = checkIn.Delay().Minutes()
+		if freshness := 1 - now.Sub(checkIn.At).Seconds()/checkInFreshness.Seconds(); freshness > position.Confidence {
+			position.Confidence = freshness
+		}
+	}
+	position.EstimateAt = now
+	return position, nil
+}
+
+func isWalking(leg common.RouteLeg) bool {
+	return leg.Mode == "walking" || len(leg.StopIDs) == 0
+}
+
+// waitingAt places the rider at the start of a leg that has not departed yet.
+func waitingAt(legIndex int, leg common.RouteLeg) common.JourneyPosition {
+	stopIndex := 0
+	if isWalking(leg) {
+		stopIndex = -1
+	}
+	return common.JourneyPosition{
+		LegIndex:   legIndex,
+		StopIndex:  stopIndex,
+		StopID:     leg.FromID,
+		StopName:   leg.From,
+		Segment:    common.SegmentWait,
+		Confidence: waitConfidence,
+	}
+}
+
+// walking re-plans from the end of the walk, since that is where the rider is heading.
+func walking(legIndex int, leg common.RouteLeg) common.JourneyPosition {
+	return common.JourneyPosition{
+		LegIndex:   legIndex,
+		StopIndex:  -1,
+		StopID:     leg.ToID,
+		StopName:   leg.To,
+		Segment:    common.SegmentWalk,
+		Confidence: walkConfidence,
+	}
+}
+
+// riding returns the next stop the vehicle calls at, the first place the rider can get off.
+func riding(legIndex int, leg common.RouteLeg, progress float64) common.JourneyPosition {
+	stops := len(leg.StopIDs)
+	index := int(math.Ceil(progress * float64(stops-1)))
+	if index >= stops {
+		index = stops - 1
+	}
+
+	name := leg.StopIDs[index]
+	if index < len(leg.Stops) {
+		name = leg.Stops[index]
+	}
+
+	// 4p(1-p) is 0 at either end of the leg and 1 half way through it.
+	uncertainty := 4 * progress * (1 - progress)
+	return common.JourneyPosition{
+		LegIndex:   legIndex,
+		StopIndex:  index,
+		StopID:     leg.StopIDs[index],
+		StopName:   name,
+		Segment:    common.SegmentRide,
+		Confidence: rideConfidenceMax - (rideConfidenceMax-rideConfidenceMin)*uncertainty,
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
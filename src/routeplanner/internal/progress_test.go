@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"math"
+	"testing"
+	"time"
+)
+
+// lateRoute runs across midnight: a Victoria line ride over five stops from 23:50 to 00:10, a
+// walk until 00:20, and a bus back from Brixton at 00:30.
+var lateRoute = common.ChosenRoute{Legs: []common.RouteLeg{
+	{
+		From: "Brixton", FromID: "940GZZLUBXN", To: "Green Park", ToID: "940GZZLUGPK", Mode: "tube",
+		StartTime: "2026-10-19T23:50:00", EndTime: "2026-10-20T00:10:00",
+		Stops:   []string{"Brixton", "Stockwell", "Vauxhall", "Pimlico", "Green Park"},
+		StopIDs: []string{"940GZZLUBXN", "940GZZLUSKW", "940GZZLUVXL", "940GZZLUPCO", "940GZZLUGPK"},
+	},
+	{
+		From: "Green Park", FromID: "940GZZLUGPK", To: "Piccadilly", ToID: "490000179A", Mode: "walking",
+		StartTime: "2026-10-20T00:10:00", EndTime: "2026-10-20T00:20:00",
+	},
+	{
+		From: "Brixton", FromID: "940GZZLUBXN", To: "Oval", ToID: "940GZZLUOVL", Mode: "bus",
+		StartTime: "2026-10-20T00:30:00", EndTime: "2026-10-20T00:40:00",
+		Stops:   []string{"Brixton", "Oval"},
+		StopIDs: []string{"940GZZLUBXN", "940GZZLUOVL"},
+	},
+}}
+
+func london(t *testing.T, value string) time.Time {
+	t.Helper()
+	at, err := ParseTfLTime(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func TestEstimatePosition(t *testing.T) {
+	tests := []struct {
+		now            string
+		wantLeg        int
+		wantStop       int
+		wantStopID     string
+		wantSegment    string
+		wantConfidence float64
+	}{
+		{"2026-10-19T23:40:00", 0, 0, "940GZZLUBXN", common.SegmentWait, waitConfidence},
+		{"2026-10-19T23:50:00", 0, 0, "940GZZLUBXN", common.SegmentRide, rideConfidenceMax},
+		{"2026-10-20T00:00:00", 0, 2, "940GZZLUVXL", common.SegmentRide, rideConfidenceMin},
+		{"2026-10-20T00:01:00", 0, 3, "940GZZLUPCO", common.SegmentRide, 0.9 - 0.4*4*0.55*0.45},
+		{"2026-10-20T00:10:00", 1, -1, "490000179A", common.SegmentWalk, walkConfidence},
+		{"2026-10-20T00:25:00", 2, 0, "940GZZLUBXN", common.SegmentWait, waitConfidence},
+	}
+	for _, tt := range tests {
+		t.Run(tt.now, func(t *testing.T) {
+			now := london(t, tt.now)
+			position, err := EstimatePosition(lateRoute, now, nil)
+			if err != nil {
+				t.Fatalf("EstimatePosition: %v", err)
+			}
+			if position.LegIndex != tt.wantLeg || position.StopIndex != tt.wantStop || position.StopID != tt.wantStopID || position.Segment != tt.wantSegment {
+				t.Errorf("position leg %d stop %d (%s) %s, want leg %d stop %d (%s) %s",
+					position.LegIndex, position.StopIndex, position.StopID, position.Segment, tt.wantLeg, tt.wantStop, tt.wantStopID, tt.wantSegment)
+			}
+			if math.Abs(position.Confidence-tt.wantConfidence) > 1e-9 {
+				t.Errorf("confidence %v, want %v", position.Confidence, tt.wantConfidence)
+			}
+			if !position.EstimateAt.Equal(now) || position.CheckedIn {
+				t.Errorf("estimate at %v, checked in %v", position.EstimateAt, position.CheckedIn)
+			}
+		})
+	}
+
+	if _, err := EstimatePosition(lateRoute, london(t, "2026-10-20T00:40:00"), nil); err == nil {
+		t.Error("EstimatePosition placed the rider on a completed journey")
+	}
+}
+
+func TestEstimatePositionAfterCheckIn(t *testing.T) {
+	// Checked in at Stockwell, scheduled at 23:55, at midnight: five minutes late.
+	checkIn, err := MatchCheckIn(lateRoute, "9400ZZLUSKW", london(t, "2026-10-20T00:00:00"))
+	if err != nil {
+		t.Fatalf("MatchCheckIn: %v", err)
+	}
+	if checkIn.Delay() != 5*time.Minute {
+		t.Fatalf("delay %v, want 5m", checkIn.Delay())
+	}
+
+	position, err := EstimatePosition(lateRoute, checkIn.At, &checkIn)
+	if err != nil {
+		t.Fatalf("EstimatePosition: %v", err)
+	}
+	if position.StopID != "940GZZLUSKW" || !position.CheckedIn || position.DelayMins != 5 || position.Confidence != 1 {
+		t.Errorf("just after the check-in: %+v", position)
+	}
+
+	// A quarter of an hour on, the delay still holds but the check-in counts for half as much.
+	position, err = EstimatePosition(lateRoute, london(t, "2026-10-20T00:15:00"), &checkIn)
+	if err != nil {
+		t.Fatalf("EstimatePosition: %v", err)
+	}
+	if position.Segment != common.SegmentWalk || position.Confidence != walkConfidence {
+		t.Errorf("15 minutes later: %+v, want walking with the walk's confidence", position)
+	}
+
+	// The delay carries the journey past its planned end.
+	position, err = EstimatePosition(lateRoute, london(t, "2026-10-20T00:42:00"), &checkIn)
+	if err != nil || position.LegIndex != 2 || position.Segment != common.SegmentRide {
+		t.Errorf("after the planned end: %+v, %v, want riding the bus", position, err)
+	}
+}
+
+// Summer time starts at 01:00 GMT on 29 March 2026, so a ride from 00:50 to 02:10 lasts twenty
+// minutes; it ends at 01:00 GMT on 25 October, so one from 00:30 to 02:30 lasts three hours.
+func TestEstimatePositionAcrossChangeOfTime(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		now        time.Time
+	}{
+		{"spring", "2026-03-29T00:50:00", "2026-03-29T02:10:00", time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC)},
+		{"autumn", "2026-10-25T00:30:00", "2026-10-25T02:30:00", time.Date(2026, 10, 25, 1, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := common.ChosenRoute{Legs: []common.RouteLeg{{
+				From: "Brixton", FromID: "940GZZLUBXN", To: "Pimlico", ToID: "940GZZLUPCO", Mode: "tube",
+				StartTime: tt.start, EndTime: tt.end,
+				StopIDs: []string{"940GZZLUBXN", "940GZZLUSKW", "940GZZLUVXL", "940GZZLUPCO"},
+			}}}
+			position, err := EstimatePosition(route, tt.now, nil)
+			if err != nil {
+				t.Fatalf("EstimatePosition: %v", err)
+			}
+			// Half way: the next stop is the third, at the least confidence.
+			if position.StopIndex != 2 || math.Abs(position.Confidence-rideConfidenceMin) > 1e-9 {
+				t.Errorf("at %s: stop %d with confidence %v, want half way", tt.now.In(tfl.London).Format(time.Kitchen), position.StopIndex, position.Confidence)
+			}
+		})
+	}
+}
+
+func TestMatchCheckIn(t *testing.T) {
+	// Brixton starts the ride at 23:50 and the bus at 00:30: the closest occurrence wins.
+	record, err := MatchCheckIn(lateRoute, "940GZZLUBXN", london(t, "2026-10-20T00:28:00"))
+	if err != nil {
+		t.Fatalf("MatchCheckIn: %v", err)
+	}
+	if !record.Scheduled.Equal(london(t, "2026-10-20T00:30:00")) || record.Delay() != -2*time.Minute || record.Naptan != "9400ZZLUBXN" {
+		t.Errorf("check-in %+v, want the bus two minutes early", record)
+	}
+
+	record, err = MatchCheckIn(lateRoute, "9400ZZLUBXN", london(t, "2026-10-19T23:52:00"))
+	if err != nil || !record.Scheduled.Equal(london(t, "2026-10-19T23:50:00")) {
+		t.Errorf("check-in %+v, %v, want the ride", record, err)
+	}
+
+	if _, err := MatchCheckIn(lateRoute, "940GZZLUKSX", time.Now()); err == nil {
+		t.Error("MatchCheckIn matched a station off the route")
+	}
+}
+
+func TestCheckInStore(t *testing.T) {
+	store := NewCheckInStore(time.Hour)
+	if store.Latest("alice") != nil {
+		t.Fatal("check-in of an unknown user")
+	}
+	store.Put("alice", CheckInRecord{Naptan: "9400ZZLUBXN"})
+	store.Put("alice", CheckInRecord{Naptan: "9400ZZLUSKW"})
+	if latest := store.Latest("alice"); latest == nil || latest.Naptan != "9400ZZLUSKW" {
+		t.Errorf("Latest = %+v, want Stockwell", latest)
+	}
+	store.Forget("alice")
+	if store.Latest("alice") != nil {
+		t.Error("check-in kept after Forget")
+	}
+
+	expired := NewCheckInStore(-time.Second)
+	expired.Put("alice", CheckInRecord{Naptan: "9400ZZLUBXN"})
+	if expired.Latest("alice") != nil {
+		t.Error("expired check-in returned")
+	}
+}
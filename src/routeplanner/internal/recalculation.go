@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Disruption levels recorded from delay events.
+const (
+	CriticalDisruption = 1.0
+	SuddenDisruption   = 0.5
+)
+
+type reportedDisruption struct {
+	level     float64
+	expiresAt time.Time
+}
+
+// DisruptionReports remembers the lines named in recent delay events, so that re-planning
+// scores routes under the disruption that triggered it. It implements LineStatusSource.
+type DisruptionReports struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	lines map[string]reportedDisruption
+}
+
+func NewDisruptionReports(ttl time.Duration) *DisruptionReports {
+	return &DisruptionReports{
+		ttl:   ttl,
+		lines: make(map[string]reportedDisruption),
+	}
+}
+
+// Report records a disruption on a line, keeping the worst level while it lasts.
+func (d *DisruptionReports) Report(lineID string, level float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	lineID = strings.ToLower(lineID)
+	if current, ok := d.lines[lineID]; ok && time.Now().Before(current.expiresAt) && current.level > level {
+		level = current.level
+	}
+	d.lines[lineID] = reportedDisruption{level: level, expiresAt: time.Now().Add(d.ttl)}
+}
+
+func (d *DisruptionReports) Disruption(lineID string) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	lineID = strings.ToLower(lineID)
+	reported, ok := d.lines[lineID]
+	if !ok {
+		return 0
+	}
+	if time.Now().After(reported.expiresAt) {
+		delete(d.lines, lineID)
+		return 0
+	}
+	return reported.level
+}
+
+// RemainingJourney rebuilds the part of a chosen route the rider still has ahead of them,
+// starting from their estimated position, so it can be scored like any TfL alternative.
+func RemainingJourney(route common.ChosenRoute, position common.JourneyPosition, now time.Time) (common.TFLJourney, error) {
+	if position.LegIndex < 0 || position.LegIndex >= len(route.Legs) {
+		return common.TFLJourney{}, fmt.Errorf("position is outside of the route")
+	}
+	windows, err := legWindows(route)
+	if err != nil {
+		return common.TFLJourney{}, err
+	}
+	delay := time.Duration(position.DelayMins * float64(time.Minute))
+
+	var journey common.TFLJourney
+	for i := position.LegIndex; i < len(route.Legs); i++ {
+		leg := route.Legs[i]
+		dep, arr := windows[i].dep.Add(delay), windows[i].arr.Add(delay)
+
+		if i == position.LegIndex && position.Segment == common.SegmentRide && position.StopIndex > 0 {
+			dep = windows[i].scheduledAt(position.StopIndex, len(leg.StopIDs)).Add(delay)
+			leg.From, leg.FromID = position.StopName, position.StopID
+			leg.StopIDs = leg.StopIDs[position.StopIndex:]
+			if position.StopIndex < len(leg.Stops) {
+				leg.Stops = leg.Stops[position.StopIndex:]
+			}
+		}
+		if i == position.LegIndex && position.Segment == common.SegmentWalk {
+			// The walk is already under way: only its arrival matters.
+			dep = now
+		}
+
+		journey.Legs = append(journey.Legs, routeLegToTfL(leg, dep, arr))
+	}
+
+	start := now
+	end := windows[len(windows)-1].arr.Add(delay)
+	if end.Before(start) {
+		end = start
+	}
+	journey.StartDateTime = start.Format(tflTimeLayout)
+	journey.ArrivalDateTime = end.Format(tflTimeLayout)
+	journey.Duration = int(math.Round(end.Sub(start).Minutes()))
+	return journey, nil
+}
+
+func routeLegToTfL(leg common.RouteLeg, dep, arr time.Time) common.TFLLeg {
+	tflLeg := common.TFLLeg{
+		Duration:       int(math.Round(arr.Sub(dep).Minutes())),
+		DepartureTime:  dep.Format(tflTimeLayout),
+		ArrivalTime:    arr.Format(tflTimeLayout),
+		DeparturePoint: common.StopPoint{CommonName: leg.From, NaptanID: leg.FromID},
+		ArrivalPoint:   common.StopPoint{CommonName: leg.To, NaptanID: leg.ToID},
+		Instruction:    common.Instruction{Summary: leg.Description, Detailed: leg.Description},
+		Mode:           common.Mode{Name: leg.Mode},
+	}
+	if leg.LineID != "" || leg.LineName != "" {
+		tflLeg.RouteOptions = []common.RouteOption{{
+			Name:           leg.LineName,
+			LineIdentifier: common.LineIdentifier{ID: leg.LineID, Name: leg.LineName},
+		}}
+	}
+	for j, id := range leg.StopIDs {
+		ref := common.StopPointRef{ID: id}
+		if j < len(leg.Stops) {
+			ref.Name = leg.Stops[j]
+		}
+		tflLeg.Path.StopPoints = append(tflLeg.Path.StopPoints, ref)
+	}
+	return tflLeg
+}
+
+// RecalculationPolicy decides when an alternative is worth leaving the current route for.
+// Both thresholds must be met: a relative and an absolute score improvement.
+type RecalculationPolicy struct {
+	MinImprovement float64 // fraction of the current score, e.g. 0.1 for 10%
+	MinMinutes     float64 // minute-equivalents
+}
+
+// RecalculationDecision is the outcome of comparing the current route with the best alternative.
+type RecalculationDecision struct {
+	Switch       bool
+	CurrentScore float64
+	BestScore    float64
+	Reason       string
+}
+
+func (p RecalculationPolicy) Decide(current, best RankedJourney) RecalculationDecision {
+	decision := RecalculationDecision{CurrentScore: current.Score, BestScore: best.Score}
+	gain := current.Score - best.Score
+	var relative float64
+	if current.Score > 0 {
+		relative = gain / current.Score
+	}
+
+	if gain >= p.MinMinutes && relative >= p.MinImprovement {
+		decision.Switch = true
+		decision.Reason = fmt.Sprintf("Switching route: the new route scores %.1f against %.1f for staying on your route (%.0f%% better), mainly thanks to %s.",
+			best.Score, current.Score, relative*100, biggestDifference(current.Breakdown, best.Breakdown))
+		return decision
+	}
+
+	if gain <= 0 {
+		decision.Reason = fmt.Sprintf("Stay on your route: it still scores %.1f, better than any alternative (best %.1f).", current.Score, best.Score)
+		return decision
+	}
+	decision.Reason = fmt.Sprintf("Stay on your route: the best alternative scores %.1f against %.1f, only %.1f (%.0f%%) better, below the %.1f (%.0f%%) needed to switch.",
+		best.Score, current.Score, gain, relative*100, p.MinMinutes, p.MinImprovement*100)
+	return decision
+}
+
+// biggestDifference names the criterion in which the alternative gains the most.
+func biggestDifference(current, best []common.ScoreComponent) string {
+	contributions := make(map[string]float64, len(best))
+	for _, c := range best {
+		contributions[c.Criterion] = c.Contribution
+	}
+
+	name, largest := "overall score", 0.0
+	for _, c := range current {
+		if diff := c.Contribution - contributions[c.Criterion]; diff > largest {
+			name, largest = c.Criterion, diff
+		}
+	}
+	return fmt.Sprintf("%s (%.1f lower)", name, largest)
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecalculationPolicyDecide(t *testing.T) {
+	policy := RecalculationPolicy{MinImprovement: 0.1, MinMinutes: 10}
+	breakdown := func(crowding, time float64) []common.ScoreComponent {
+		return []common.ScoreComponent{
+			{Criterion: "crowding", Contribution: crowding},
+			{Criterion: "time", Contribution: time},
+		}
+	}
+	tests := []struct {
+		name       string
+		current    float64
+		best       float64
+		wantSwitch bool
+		wantReason string
+	}{
+		{"both thresholds met exactly", 100, 90, true, "10% better"},
+		{"both thresholds passed", 60, 40, true, "33% better"},
+		{"absolute gain too small", 50, 41, false, "below the 10.0 (10%) needed"},
+		{"relative gain too small", 200, 185, false, "only 15.0 (8%) better"},
+		{"alternative no better", 50, 50, false, "still scores 50.0"},
+		{"alternative worse", 50, 55, false, "still scores 50.0"},
+		{"current route scores nothing", 0, -20, false, "only 20.0 (0%) better"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := RankedJourney{Score: tt.current, Breakdown: breakdown(tt.current*0.25, tt.current*0.75)}
+			best := RankedJourney{Score: tt.best, Breakdown: breakdown(tt.best*0.25, tt.best*0.75)}
+			decision := policy.Decide(current, best)
+			if decision.Switch != tt.wantSwitch {
+				t.Errorf("Switch = %v, want %v: %s", decision.Switch, tt.wantSwitch, decision.Reason)
+			}
+			if !strings.Contains(decision.Reason, tt.wantReason) {
+				t.Errorf("Reason %q lacks %q", decision.Reason, tt.wantReason)
+			}
+			if decision.CurrentScore != tt.current || decision.BestScore != tt.best {
+				t.Errorf("scores %.1f and %.1f, want %.1f and %.1f", decision.CurrentScore, decision.BestScore, tt.current, tt.best)
+			}
+		})
+	}
+}
+
+func TestRecalculationReasonNamesBiggestGain(t *testing.T) {
+	current := RankedJourney{Score: 60, Breakdown: []common.ScoreComponent{
+		{Criterion: "crowding", Contribution: 15},
+		{Criterion: "time", Contribution: 30},
+		{Criterion: "reliability", Contribution: 15},
+	}}
+	best := RankedJourney{Score: 40, Breakdown: []common.ScoreComponent{
+		{Criterion: "crowding", Contribution: 10},
+		{Criterion: "time", Contribution: 28},
+		{Criterion: "reliability", Contribution: 2},
+	}}
+	decision := RecalculationPolicy{MinImprovement: 0.1, MinMinutes: 10}.Decide(current, best)
+	if !strings.Contains(decision.Reason, "mainly thanks to reliability (13.0 lower)") {
+		t.Errorf("Reason %q does not name reliability", decision.Reason)
+	}
+}
+
+func TestDisruptionReports(t *testing.T) {
+	reports := NewDisruptionReports(time.Hour)
+	reports.Report("Victoria", CriticalDisruption)
+	reports.Report("victoria", SuddenDisruption)
+	if got := reports.Disruption("VICTORIA"); got != CriticalDisruption {
+		t.Errorf("after a milder report, Disruption = %v, want the worst", got)
+	}
+	if got := reports.Disruption("central"); got != 0 {
+		t.Errorf("unreported line: Disruption = %v", got)
+	}
+
+	expired := NewDisruptionReports(-time.Second)
+	expired.Report("victoria", CriticalDisruption)
+	if got := expired.Disruption("victoria"); got != 0 {
+		t.Errorf("expired report: Disruption = %v", got)
+	}
+	// An expired report no longer holds a milder one up.
+	expired.ttl = time.Hour
+	expired.Report("victoria", SuddenDisruption)
+	if got := expired.Disruption("victoria"); got != SuddenDisruption {
+		t.Errorf("after expiry, Disruption = %v, want the new level", got)
+	}
+}
+
+// recalculationRoute is a Victoria line ride from Brixton to Green Park over five stops,
+// then a walk.
+var recalculationRoute = common.ChosenRoute{Legs: []common.RouteLeg{
+	{
+		From: "Brixton", FromID: "940GZZLUBXN", To: "Green Park", ToID: "940GZZLUGPK", Mode: "tube",
+		StartTime: "2026-10-19T10:00:00", EndTime: "2026-10-19T10:20:00", LineID: "victoria", LineName: "Victoria",
+		Stops:   []string{"Brixton", "Stockwell", "Vauxhall", "Pimlico", "Green Park"},
+		StopIDs: []string{"940GZZLUBXN", "940GZZLUSKW", "940GZZLUVXL", "940GZZLUPCO", "940GZZLUGPK"},
+	},
+	{
+		From: "Green Park", FromID: "940GZZLUGPK", To: "Piccadilly", Mode: "walking",
+		StartTime: "2026-10-19T10:20:00", EndTime: "2026-10-19T10:30:00",
+	},
+}}
+
+func TestRemainingJourney(t *testing.T) {
+	now, _ := ParseTfLTime("2026-10-19T10:14:00")
+
+	// Five minutes late, between Vauxhall and Pimlico.
+	position := common.JourneyPosition{LegIndex: 0, StopIndex: 2, StopID: "940GZZLUVXL", StopName: "Vauxhall", Segment: common.SegmentRide, DelayMins: 5}
+	journey, err := RemainingJourney(recalculationRoute, position, now)
+	if err != nil {
+		t.Fatalf("RemainingJourney: %v", err)
+	}
+	if len(journey.Legs) != 2 {
+		t.Fatalf("%d legs, want 2", len(journey.Legs))
+	}
+	ride := journey.Legs[0]
+	if ride.DeparturePoint.NaptanID != "940GZZLUVXL" || ride.DepartureTime != "2026-10-19T10:15:00" || ride.ArrivalTime != "2026-10-19T10:25:00" {
+		t.Errorf("ride from %s at %s to %s, want from Vauxhall 10:15 to 10:25", ride.DeparturePoint.NaptanID, ride.DepartureTime, ride.ArrivalTime)
+	}
+	if len(ride.Path.StopPoints) != 3 || ride.Path.StopPoints[0].Name != "Vauxhall" {
+		t.Errorf("ride stops %+v, want the three from Vauxhall", ride.Path.StopPoints)
+	}
+	if len(ride.RouteOptions) != 1 || ride.RouteOptions[0].LineIdentifier.ID != "victoria" {
+		t.Errorf("ride route options %+v", ride.RouteOptions)
+	}
+	if journey.StartDateTime != "2026-10-19T10:14:00" || journey.ArrivalDateTime != "2026-10-19T10:35:00" || journey.Duration != 21 {
+		t.Errorf("journey %s to %s (%d min), want 10:14 to 10:35 (21 min)", journey.StartDateTime, journey.ArrivalDateTime, journey.Duration)
+	}
+
+	// Already walking: the walk starts now and ends on time.
+	now, _ = ParseTfLTime("2026-10-19T10:24:00")
+	position = common.JourneyPosition{LegIndex: 1, StopIndex: -1, Segment: common.SegmentWalk}
+	journey, err = RemainingJourney(recalculationRoute, position, now)
+	if err != nil {
+		t.Fatalf("RemainingJourney: %v", err)
+	}
+	if len(journey.Legs) != 1 || journey.Legs[0].DepartureTime != "2026-10-19T10:24:00" || journey.Legs[0].Duration != 6 {
+		t.Errorf("walk %+v, want 6 minutes from 10:24", journey.Legs)
+	}
+
+	// Past the planned arrival, the journey ends when it starts.
+	now, _ = ParseTfLTime("2026-10-19T10:40:00")
+	journey, err = RemainingJourney(recalculationRoute, position, now)
+	if err != nil || journey.ArrivalDateTime != journey.StartDateTime || journey.Duration != 0 {
+		t.Errorf("late journey %s to %s (%d min), %v", journey.StartDateTime, journey.ArrivalDateTime, journey.Duration, err)
+	}
+
+	if _, err := RemainingJourney(recalculationRoute, common.JourneyPosition{LegIndex: 2}, now); err == nil {
+		t.Error("RemainingJourney accepted a position outside of the route")
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReliabilityTable is the route planner's copy of the reliability report computed by the traffic
+// service. It implements ReliabilitySource; lines and bands it knows nothing about count as reliable.
+type ReliabilityTable struct {
+	mu          sync.RWMutex
+	bands       map[string]float64 // "line|weekday|band" -> probability
+	generatedAt time.Time
+}
+
+func NewReliabilityTable() *ReliabilityTable {
+	return &ReliabilityTable{bands: make(map[string]float64)}
+}
+
+func reliabilityKey(lineID, weekday, band string) string {
+	return strings.ToLower(lineID) + "|" + weekday + "|" + band
+}
+
+// Update replaces the table with a newer report.
+func (t *ReliabilityTable) Update(report common.ReliabilityReport) {
+	bands := make(map[string]float64, len(report.Bands))
+	for _, band := range report.Bands {
+		bands[reliabilityKey(band.LineID, band.Weekday, band.TimeBand)] = band.Probability
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.bands = bands
+	t.generatedAt = report.GeneratedAt
+}
+
+// GeneratedAt returns when the report in use was computed, zero if there is none yet.
+func (t *ReliabilityTable) GeneratedAt() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.generatedAt
+}
+
+func (t *ReliabilityTable) DisruptionProbability(lineID string, at time.Time) float64 {
+	at = at.In(tfl.London)
+	weekday := strings.ToUpper(at.Weekday().String()[:3])
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.bands[reliabilityKey(lineID, weekday, TimeToTfLTimeBand(at))]
+}
@@ -0,0 +1,505 @@
+package internal
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultInterchangeMinutes is used at stations without an interchange row in the topology file.
+	defaultInterchangeMinutes = 5
+	// boardingWaitMinutes approximates the average wait for the next train.
+	boardingWaitMinutes = 2
+)
+
+// The offline router works on a graph with one node per (station, line) pair plus one "hub"
+// node per station. Riding moves between nodes of the same line, boarding goes from the hub
+// to a line node and alighting goes back to the hub, paying the station's interchange penalty.
+// Walking interchanges between different stations connect their hubs.
+
+type edgeKind int
+
+const (
+	edgeRide edgeKind = iota
+	edgeBoard
+	edgeAlight
+	edgeWalk
+	edgeArrive
+)
+
+type graphEdge struct {
+	to      int
+	minutes float64
+	kind    edgeKind
+}
+
+type graphNode struct {
+	station string
+	line    string // empty for hub nodes and the sink
+}
+
+type lineInfo struct {
+	name string
+	mode string
+}
+
+// Router is the offline fallback used when the TfL Journey API is unavailable.
+type Router struct {
+	nodes       []graphNode
+	edges       [][]graphEdge
+	index       map[graphNode]int
+	lines       map[string]lineInfo
+	interchange map[string]float64
+	stationName func(naptan string) string
+}
+
+// NewRouter builds the graph from stationTopology.csv records, header included. Each row is
+// either "link,line_id,line_name,mode,from_naptan,to_naptan,minutes" for two adjacent stops
+// of a line, or "interchange,,,mode,from_naptan,to_naptan,minutes" for the time needed to
+// change at a station (from == to) or to walk between two stations.
+func NewRouter(records [][]string, stationName func(naptan string) string) (*Router, error) {
+	r := &Router{
+		index:       make(map[graphNode]int),
+		lines:       make(map[string]lineInfo),
+		interchange: make(map[string]float64),
+		stationName: stationName,
+	}
+
+	type walk struct {
+		from, to string
+		minutes  float64
+	}
+	var walks []walk
+
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+		if len(record) < 7 {
+			return nil, fmt.Errorf("topology row %d: expected 7 columns, got %d", i+1, len(record))
+		}
+		minutes, err := strconv.ParseFloat(record[6], 64)
+		if err != nil {
+			return nil, fmt.Errorf("topology row %d: invalid minutes: %w", i+1, err)
+		}
+		from, to := record[4], record[5]
+
+		switch record[0] {
+		case "link":
+			lineID := record[1]
+			r.lines[lineID] = lineInfo{name: record[2], mode: record[3]}
+			a := r.node(graphNode{station: from, line: lineID})
+			b := r.node(graphNode{station: to, line: lineID})
+			r.addEdge(a, b, minutes, edgeRide)
+			r.addEdge(b, a, minutes, edgeRide)
+		case "interchange":
+			if from == to {
+				r.interchange[from] = minutes
+			} else {
+				walks = append(walks, walk{from: from, to: to, minutes: minutes})
+			}
+		default:
+			return nil, fmt.Errorf("topology row %d: unknown row type '%s'", i+1, record[0])
+		}
+	}
+
+	// Connect every line node to its station hub.
+	lineNodes := len(r.nodes)
+	for i := 0; i < lineNodes; i++ {
+		station := r.nodes[i].station
+		hub := r.node(graphNode{station: station})
+		r.addEdge(hub, i, boardingWaitMinutes, edgeBoard)
+		r.addEdge(i, hub, r.interchangeMinutes(station), edgeAlight)
+	}
+
+	for _, w := range walks {
+		a := r.node(graphNode{station: w.from})
+		b := r.node(graphNode{station: w.to})
+		r.addEdge(a, b, w.minutes, edgeWalk)
+		r.addEdge(b, a, w.minutes, edgeWalk)
+	}
+
+	return r, nil
+}
+
+func (r *Router) node(n graphNode) int {
+	if id, ok := r.index[n]; ok {
+		return id
+	}
+	id := len(r.nodes)
+	r.nodes = append(r.nodes, n)
+	r.edges = append(r.edges, nil)
+	r.index[n] = id
+	return id
+}
+
+func (r *Router) addEdge(from, to int, minutes float64, kind edgeKind) {
+	r.edges[from] = append(r.edges[from], graphEdge{to: to, minutes: minutes, kind: kind})
+}
+
+func (r *Router) interchangeMinutes(station string) float64 {
+	if m, ok := r.interchange[station]; ok {
+		return m
+	}
+	return defaultInterchangeMinutes
+}
+
+// Journeys returns up to k journeys between two stop points in the same shape as the TfL
+// Journey API, so they can be scored and converted like any other candidate.
+func (r *Router) Journeys(q tfl.JourneyQuery, k int) (*common.TFLJourneyResponse, error) {
+	from, to := NormalizeStopID(q.From), NormalizeStopID(q.To)
+	src, ok := r.index[graphNode{station: from}]
+	if !ok {
+		return nil, fmt.Errorf("station %s is not part of the offline network", q.From)
+	}
+	hubTo, ok := r.index[graphNode{station: to}]
+	if !ok {
+		return nil, fmt.Errorf("station %s is not part of the offline network", q.To)
+	}
+
+	// A temporary sink reachable from every node of the destination station.
+	sink := len(r.nodes)
+	edges := make([][]graphEdge, len(r.edges)+1)
+	copy(edges, r.edges)
+	for id, n := range r.nodes {
+		if n.station == to && id != hubTo {
+			edges[id] = append(append([]graphEdge(nil), edges[id]...), graphEdge{to: sink, kind: edgeArrive})
+		}
+	}
+	edges[hubTo] = append(append([]graphEdge(nil), edges[hubTo]...), graphEdge{to: sink, kind: edgeArrive})
+
+	g := &searchGraph{edges: edges, excluded: r.excludedNodes(q.Modes)}
+	paths := g.kShortest(src, sink, 2*k)
+
+	var result common.TFLJourneyResponse
+	seen := make(map[string]struct{})
+	for _, p := range paths {
+		journey := r.toJourney(edges, p.nodes, q)
+		sig := journeySignature(journey)
+		if _, dup := seen[sig]; dup || len(journey.Legs) == 0 {
+			continue
+		}
+		seen[sig] = struct{}{}
+		result.Journeys = append(result.Journeys, journey)
+		if len(result.Journeys) == k {
+			break
+		}
+	}
+
+	if len(result.Journeys) == 0 {
+		return nil, fmt.Errorf("no offline route between %s and %s", q.From, q.To)
+	}
+	return &result, nil
+}
+
+// excludedNodes returns the line nodes whose mode is not among the allowed ones.
+func (r *Router) excludedNodes(modes []string) map[int]bool {
+	if len(modes) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool, len(modes))
+	for _, mode := range modes {
+		allowed[mode] = true
+	}
+
+	excluded := make(map[int]bool)
+	for id, n := range r.nodes {
+		if n.line != "" && !allowed[r.lines[n.line].mode] {
+			excluded[id] = true
+		}
+	}
+	return excluded
+}
+
+func (r *Router) toJourney(edges [][]graphEdge, path []int, q tfl.JourneyQuery) common.TFLJourney {
+	type timedLeg struct {
+		leg      common.TFLLeg
+		dep, arr float64 // minutes from the start of the journey
+	}
+	var legs []timedLeg
+	var current *timedLeg
+	var elapsed float64
+
+	closeLeg := func() {
+		if current != nil {
+			current.arr = elapsed
+			legs = append(legs, *current)
+			current = nil
+		}
+	}
+
+	for i := 0; i+1 < len(path); i++ {
+		e := edgeBetween(edges, path[i], path[i+1])
+		from, to := r.nodeAt(path[i]), r.nodeAt(path[i+1])
+
+		switch e.kind {
+		case edgeRide:
+			if current == nil {
+				info := r.lines[from.line]
+				current = &timedLeg{dep: elapsed, leg: common.TFLLeg{
+					DeparturePoint: r.stopPoint(from.station),
+					RouteOptions: []common.RouteOption{{
+						Name:           info.name,
+						LineIdentifier: common.LineIdentifier{ID: from.line, Name: info.name},
+					}},
+					Mode: common.Mode{Name: info.mode},
+					Path: common.Path{StopPoints: []common.StopPointRef{r.stopRef(from.station)}},
+				}}
+			}
+			elapsed += e.minutes
+			current.leg.ArrivalPoint = r.stopPoint(to.station)
+			current.leg.Path.StopPoints = append(current.leg.Path.StopPoints, r.stopRef(to.station))
+		case edgeWalk:
+			closeLeg()
+			current = &timedLeg{dep: elapsed, leg: common.TFLLeg{
+				DeparturePoint: r.stopPoint(from.station),
+				ArrivalPoint:   r.stopPoint(to.station),
+				Mode:           common.Mode{Name: "walking"},
+			}}
+			elapsed += e.minutes
+			closeLeg()
+		default:
+			// Boarding waits and interchanges count towards the journey time but not to a leg.
+			closeLeg()
+			elapsed += e.minutes
+		}
+	}
+	closeLeg()
+
+	start := q.Time.In(tfl.London)
+	if q.TimeIs == tfl.Arriving {
+		start = start.Add(-minutesToDuration(elapsed))
+	}
+
+	journey := common.TFLJourney{
+		StartDateTime:   start.Format(tflTimeLayout),
+		ArrivalDateTime: start.Add(minutesToDuration(elapsed)).Format(tflTimeLayout),
+		Duration:        int(elapsed + 0.5),
+	}
+	for _, tl := range legs {
+		leg := tl.leg
+		leg.Duration = int(tl.arr - tl.dep + 0.5)
+		leg.DepartureTime = start.Add(minutesToDuration(tl.dep)).Format(tflTimeLayout)
+		leg.ArrivalTime = start.Add(minutesToDuration(tl.arr)).Format(tflTimeLayout)
+		leg.Instruction = legInstruction(leg)
+		journey.Legs = append(journey.Legs, leg)
+	}
+	return journey
+}
+
+func (r *Router) nodeAt(id int) graphNode {
+	if id < len(r.nodes) {
+		return r.nodes[id]
+	}
+	return graphNode{}
+}
+
+func (r *Router) stopPoint(naptan string) common.StopPoint {
+	return common.StopPoint{CommonName: r.stationName(naptan), NaptanID: ToTfLStopID(naptan)}
+}
+
+func (r *Router) stopRef(naptan string) common.StopPointRef {
+	return common.StopPointRef{ID: ToTfLStopID(naptan), Name: r.stationName(naptan)}
+}
+
+func legInstruction(leg common.TFLLeg) common.Instruction {
+	if leg.Mode.Name == "walking" {
+		summary := fmt.Sprintf("Walk to %s", leg.ArrivalPoint.CommonName)
+		return common.Instruction{Summary: summary, Detailed: summary}
+	}
+	line := leg.RouteOptions[0].Name
+	return common.Instruction{
+		Summary:  fmt.Sprintf("%s line to %s", line, leg.ArrivalPoint.CommonName),
+		Detailed: fmt.Sprintf("%s line from %s to %s", line, leg.DeparturePoint.CommonName, leg.ArrivalPoint.CommonName),
+	}
+}
+
+func minutesToDuration(minutes float64) time.Duration {
+	return time.Duration(minutes * float64(time.Minute))
+}
+
+func journeySignature(journey common.TFLJourney) string {
+	var parts []string
+	for _, leg := range journey.Legs {
+		line := leg.Mode.Name
+		if len(leg.RouteOptions) > 0 {
+			line = leg.RouteOptions[0].LineIdentifier.ID
+		}
+		parts = append(parts, line+":"+leg.DeparturePoint.NaptanID+">"+leg.ArrivalPoint.NaptanID)
+	}
+	return strings.Join(parts, "|")
+}
+
+// ToTfLStopID converts a 9400 station code into the 940G hub form used in TfL journey results.
+func ToTfLStopID(naptan string) string {
+	if strings.HasPrefix(naptan, "9400") {
+		return "940G" + naptan[4:]
+	}
+	return naptan
+}
+
+// searchGraph runs Dijkstra and Yen's k-shortest paths over an adjacency list.
+type searchGraph struct {
+	edges    [][]graphEdge
+	excluded map[int]bool // nodes no path may use
+}
+
+type graphPath struct {
+	nodes []int
+	cost  float64
+}
+
+func edgeBetween(edges [][]graphEdge, from, to int) graphEdge {
+	for _, e := range edges[from] {
+		if e.to == to {
+			return e
+		}
+	}
+	return graphEdge{to: to}
+}
+
+func (g *searchGraph) shortest(src, dst int, blockedNodes map[int]bool, blockedEdges map[[2]int]bool) (graphPath, bool) {
+	dist := make(map[int]float64)
+	prev := make(map[int]int)
+	dist[src] = 0
+
+	pq := &nodeQueue{{node: src}}
+	for pq.Len() > 0 {
+		item := heap.Pop(pq).(queueItem)
+		if item.dist > dist[item.node] {
+			continue
+		}
+		if item.node == dst {
+			break
+		}
+		for _, e := range g.edges[item.node] {
+			if blockedNodes[e.to] || g.excluded[e.to] || blockedEdges[[2]int{item.node, e.to}] {
+				continue
+			}
+			nd := item.dist + e.minutes
+			if d, ok := dist[e.to]; !ok || nd < d {
+				dist[e.to] = nd
+				prev[e.to] = item.node
+				heap.Push(pq, queueItem{node: e.to, dist: nd})
+			}
+		}
+	}
+
+	cost, ok := dist[dst]
+	if !ok {
+		return graphPath{}, false
+	}
+	nodes := []int{dst}
+	for n := dst; n != src; {
+		n = prev[n]
+		nodes = append([]int{n}, nodes...)
+	}
+	return graphPath{nodes: nodes, cost: cost}, true
+}
+
+func (g *searchGraph) pathCost(nodes []int) float64 {
+	var cost float64
+	for i := 0; i+1 < len(nodes); i++ {
+		cost += edgeBetween(g.edges, nodes[i], nodes[i+1]).minutes
+	}
+	return cost
+}
+
+// kShortest implements Yen's algorithm for loopless k-shortest paths.
+func (g *searchGraph) kShortest(src, dst, k int) []graphPath {
+	first, ok := g.shortest(src, dst, nil, nil)
+	if !ok {
+		return nil
+	}
+	found := []graphPath{first}
+	var candidates []graphPath
+	known := map[string]bool{pathKey(first.nodes): true}
+
+	for len(found) < k {
+		last := found[len(found)-1]
+		for i := 0; i < len(last.nodes)-1; i++ {
+			spur := last.nodes[i]
+			root := last.nodes[:i+1]
+
+			blockedEdges := make(map[[2]int]bool)
+			for _, p := range found {
+				if len(p.nodes) > i && equalPrefix(p.nodes, root) {
+					blockedEdges[[2]int{p.nodes[i], p.nodes[i+1]}] = true
+				}
+			}
+			blockedNodes := make(map[int]bool)
+			for _, n := range root[:len(root)-1] {
+				blockedNodes[n] = true
+			}
+
+			spurPath, ok := g.shortest(spur, dst, blockedNodes, blockedEdges)
+			if !ok {
+				continue
+			}
+			nodes := append(append([]int(nil), root[:len(root)-1]...), spurPath.nodes...)
+			key := pathKey(nodes)
+			if known[key] {
+				continue
+			}
+			known[key] = true
+			candidates = append(candidates, graphPath{nodes: nodes, cost: g.pathCost(nodes)})
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+		best := 0
+		for i, c := range candidates {
+			if c.cost < candidates[best].cost {
+				best = i
+			}
+		}
+		found = append(found, candidates[best])
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
+
+	return found
+}
+
+func equalPrefix(nodes, prefix []int) bool {
+	if len(nodes) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if nodes[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func pathKey(nodes []int) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+type queueItem struct {
+	node int
+	dist float64
+}
+
+type nodeQueue []queueItem
+
+func (q nodeQueue) Len() int            { return len(q) }
+func (q nodeQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q nodeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x interface{}) { *q = append(*q, x.(queueItem)) }
+func (q *nodeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"strings"
+	"testing"
+	"time"
+)
+
+// routerTopology: the Victoria line runs A-B-C in 4 minutes, the Jubilee line A-D-C in 6, a DLR
+// line A-C in 10 and the Central line B-F. E is a 4-minute walk from C; changing at B takes a
+// minute and everywhere else the default.
+var routerTopology = [][]string{
+	{"type", "line_id", "line_name", "mode", "from_naptan", "to_naptan", "minutes"},
+	{"link", "victoria", "Victoria", "tube", "9400ZZLUAAA", "9400ZZLUBBB", "2"},
+	{"link", "victoria", "Victoria", "tube", "9400ZZLUBBB", "9400ZZLUCCC", "2"},
+	{"link", "jubilee", "Jubilee", "tube", "9400ZZLUAAA", "9400ZZLUDDD", "3"},
+	{"link", "jubilee", "Jubilee", "tube", "9400ZZLUDDD", "9400ZZLUCCC", "3"},
+	{"link", "dlr", "DLR", "dlr", "9400ZZLUAAA", "9400ZZLUCCC", "10"},
+	{"link", "central", "Central", "tube", "9400ZZLUBBB", "9400ZZLUFFF", "3"},
+	{"interchange", "", "", "tube", "9400ZZLUBBB", "9400ZZLUBBB", "1"},
+	{"interchange", "", "", "walking", "9400ZZLUCCC", "9400ZZLUEEE", "4"},
+	{"link", "orphan", "Orphan", "tube", "9400ZZLUXXX", "9400ZZLUYYY", "1"},
+}
+
+func testRouter(t *testing.T) *Router {
+	t.Helper()
+	r, err := NewRouter(routerTopology, func(naptan string) string { return "Station " + naptan[len(naptan)-3:] })
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	return r
+}
+
+func journeyLines(r *Router, t *testing.T, q tfl.JourneyQuery, k int) []string {
+	t.Helper()
+	result, err := r.Journeys(q, k)
+	if err != nil {
+		t.Fatalf("Journeys: %v", err)
+	}
+	var lines []string
+	for _, journey := range result.Journeys {
+		lines = append(lines, journeySignature(journey))
+	}
+	return lines
+}
+
+func TestRouterJourneys(t *testing.T) {
+	r := testRouter(t)
+	departure := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC) // 10:00 in London
+	q := tfl.JourneyQuery{From: "940GZZLUAAA", To: "940GZZLUCCC", Time: departure}
+
+	got := journeyLines(r, t, q, 3)
+	want := []string{
+		"victoria:940GZZLUAAA>940GZZLUCCC",
+		"jubilee:940GZZLUAAA>940GZZLUCCC",
+		"dlr:940GZZLUAAA>940GZZLUCCC",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("journeys %v, want %v", got, want)
+	}
+
+	result, _ := r.Journeys(q, 1)
+	journey := result.Journeys[0]
+	// Two minutes to board, four to ride.
+	if journey.StartDateTime != "2026-10-19T10:00:00" || journey.ArrivalDateTime != "2026-10-19T10:06:00" || journey.Duration != 6 {
+		t.Errorf("journey %s to %s (%d min)", journey.StartDateTime, journey.ArrivalDateTime, journey.Duration)
+	}
+	leg := journey.Legs[0]
+	if leg.DepartureTime != "2026-10-19T10:02:00" || leg.Duration != 4 || len(leg.Path.StopPoints) != 3 {
+		t.Errorf("leg at %s for %d min over %d stops", leg.DepartureTime, leg.Duration, len(leg.Path.StopPoints))
+	}
+	if leg.Instruction.Summary != "Victoria line to Station CCC" {
+		t.Errorf("instruction %q", leg.Instruction.Summary)
+	}
+
+	q.Modes = []string{"dlr"}
+	if got := journeyLines(r, t, q, 3); len(got) != 1 || got[0] != "dlr:940GZZLUAAA>940GZZLUCCC" {
+		t.Errorf("DLR only: journeys %v", got)
+	}
+}
+
+func TestRouterInterchangesAndWalks(t *testing.T) {
+	r := testRouter(t)
+	departure := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+	// Changing at B costs its one-minute interchange and a boarding wait.
+	result, err := r.Journeys(tfl.JourneyQuery{From: "9400ZZLUAAA", To: "9400ZZLUFFF", Time: departure}, 1)
+	if err != nil {
+		t.Fatalf("Journeys: %v", err)
+	}
+	journey := result.Journeys[0]
+	if sig := journeySignature(journey); sig != "victoria:940GZZLUAAA>940GZZLUBBB|central:940GZZLUBBB>940GZZLUFFF" {
+		t.Errorf("A to F: %s", sig)
+	}
+	if journey.Duration != 2+2+1+2+3 {
+		t.Errorf("A to F takes %d min", journey.Duration)
+	}
+
+	// Walking on from C costs C's default interchange first.
+	result, err = r.Journeys(tfl.JourneyQuery{From: "9400ZZLUAAA", To: "9400ZZLUEEE", Time: departure}, 1)
+	if err != nil {
+		t.Fatalf("Journeys: %v", err)
+	}
+	journey = result.Journeys[0]
+	if sig := journeySignature(journey); sig != "victoria:940GZZLUAAA>940GZZLUCCC|walking:940GZZLUCCC>940GZZLUEEE" {
+		t.Errorf("A to E: %s", sig)
+	}
+	if journey.Duration != 2+4+defaultInterchangeMinutes+4 {
+		t.Errorf("A to E takes %d min", journey.Duration)
+	}
+	if walk := journey.Legs[1]; walk.Instruction.Summary != "Walk to Station EEE" || walk.Duration != 4 {
+		t.Errorf("walk %q for %d min", walk.Instruction.Summary, walk.Duration)
+	}
+}
+
+func TestRouterArriveBy(t *testing.T) {
+	r := testRouter(t)
+	arrival := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	result, err := r.Journeys(tfl.JourneyQuery{From: "9400ZZLUAAA", To: "9400ZZLUCCC", Time: arrival, TimeIs: tfl.Arriving}, 1)
+	if err != nil {
+		t.Fatalf("Journeys: %v", err)
+	}
+	journey := result.Journeys[0]
+	if journey.StartDateTime != "2026-10-19T09:54:00" || journey.ArrivalDateTime != "2026-10-19T10:00:00" {
+		t.Errorf("journey %s to %s, want to arrive at 10:00", journey.StartDateTime, journey.ArrivalDateTime)
+	}
+
+	// Summer time ends at 02:00 BST on 25 October 2026: arriving at 01:03 GMT means leaving at
+	// 01:57 BST.
+	arrival = time.Date(2026, 10, 25, 1, 3, 0, 0, time.UTC)
+	result, err = r.Journeys(tfl.JourneyQuery{From: "9400ZZLUAAA", To: "9400ZZLUCCC", Time: arrival, TimeIs: tfl.Arriving}, 1)
+	if err != nil {
+		t.Fatalf("Journeys: %v", err)
+	}
+	journey = result.Journeys[0]
+	if journey.StartDateTime != "2026-10-25T01:57:00" || journey.ArrivalDateTime != "2026-10-25T01:03:00" || journey.Duration != 6 {
+		t.Errorf("journey %s to %s (%d min) across the change of time", journey.StartDateTime, journey.ArrivalDateTime, journey.Duration)
+	}
+}
+
+func TestRouterErrors(t *testing.T) {
+	r := testRouter(t)
+	if _, err := r.Journeys(tfl.JourneyQuery{From: "9400ZZLUAAA", To: "9400ZZLUZZZ"}, 1); err == nil {
+		t.Error("Journeys to an unknown station succeeded")
+	}
+	if _, err := r.Journeys(tfl.JourneyQuery{From: "9400ZZLUAAA", To: "9400ZZLUXXX"}, 1); err == nil {
+		t.Error("Journeys between unconnected stations succeeded")
+	}
+	if _, err := r.Journeys(tfl.JourneyQuery{From: "9400ZZLUAAA", To: "9400ZZLUFFF", Modes: []string{"dlr"}}, 1); err == nil {
+		t.Error("Journeys by a mode that does not go there succeeded")
+	}
+
+	for _, row := range [][]string{
+		{"link", "victoria", "Victoria", "tube", "9400ZZLUAAA", "9400ZZLUBBB", "two"},
+		{"bridge", "", "", "tube", "9400ZZLUAAA", "9400ZZLUBBB", "2"},
+		{"link", "victoria", "Victoria", "tube", "9400ZZLUAAA"},
+	} {
+		if _, err := NewRouter([][]string{routerTopology[0], row}, nil); err == nil {
+			t.Errorf("NewRouter accepted %v", row)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A scheduled journey is planned when it is booked, re-planned with live conditions a lead
+// time before the rider has to leave, and then re-checked until they leave. Whenever the time
+// to leave moves by more than a threshold, the rider is told to leave earlier or later.
+
+// SchedulePolicy configures how scheduled journeys are monitored.
+type SchedulePolicy struct {
+	Lead     time.Duration // how long before leaving the final recommendation is pushed
+	Recheck  time.Duration // how often a journey is re-planned once monitored
+	MinShift time.Duration // smallest change of leaving time worth an alert
+}
+
+// NewScheduleID returns a random identifier for a scheduled journey.
+func NewScheduleID() string {
+	return randomID()
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// ScheduleStore keeps the journeys booked for later, by schedule ID.
+type ScheduleStore struct {
+	mu       sync.Mutex
+	journeys map[string]common.ScheduledJourney
+}
+
+func NewScheduleStore() *ScheduleStore {
+	return &ScheduleStore{journeys: make(map[string]common.ScheduledJourney)}
+}
+
+func (s *ScheduleStore) Put(journey common.ScheduledJourney) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journeys[journey.ScheduleID] = journey
+}
+
+// Get returns a scheduled journey, provided it belongs to the user.
+func (s *ScheduleStore) Get(userID, scheduleID string) (common.ScheduledJourney, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	journey, ok := s.journeys[scheduleID]
+	if !ok || journey.UserID != userID {
+		return common.ScheduledJourney{}, fmt.Errorf("no scheduled journey %s for user %s", scheduleID, userID)
+	}
+	return journey, nil
+}
+
+func (s *ScheduleStore) Delete(scheduleID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.journeys, scheduleID)
+}
+
+// ForUser returns a user's scheduled journeys, soonest first.
+func (s *ScheduleStore) ForUser(userID string) []common.ScheduledJourney {
+	var journeys []common.ScheduledJourney
+	for _, journey := range s.All() {
+		if journey.UserID == userID {
+			journeys = append(journeys, journey)
+		}
+	}
+	return journeys
+}
+
+// All returns every scheduled journey, soonest first.
+func (s *ScheduleStore) All() []common.ScheduledJourney {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	journeys := make([]common.ScheduledJourney, 0, len(s.journeys))
+	for _, journey := range s.journeys {
+		journeys = append(journeys, journey)
+	}
+	sort.Slice(journeys, func(i, j int) bool {
+		return journeys[i].LeaveAt.Before(journeys[j].LeaveAt)
+	})
+	return journeys
+}
+
+// BookedTime is the time the rider asked for: the arrival deadline or the departure.
+func BookedTime(journey common.ScheduledJourney) time.Time {
+	if !journey.Request.ArriveBy.IsZero() {
+		return journey.Request.ArriveBy
+	}
+	return journey.Request.Departure
+}
+
+// ScheduleAction is what a scheduled journey needs at a given moment.
+type ScheduleAction int
+
+const (
+	ScheduleIdle     ScheduleAction = iota // nothing to do yet
+	ScheduleFinalise                       // push the final recommendation
+	ScheduleRecheck                        // re-plan and alert if the time to leave moved
+	ScheduleExpire                         // the rider has left: stop monitoring
+)
+
+// Next returns what a scheduled journey needs now.
+func (p SchedulePolicy) Next(journey common.ScheduledJourney, now time.Time) ScheduleAction {
+	leaveAt := journey.LeaveAt
+	if leaveAt.IsZero() {
+		leaveAt = BookedTime(journey)
+	}
+	switch {
+	case now.After(leaveAt):
+		return ScheduleExpire
+	case journey.Status == common.ScheduleWaiting && !now.Before(leaveAt.Add(-p.Lead)):
+		return ScheduleFinalise
+	case journey.Status == common.ScheduleMonitoring && !now.Before(journey.PlannedAt.Add(p.Recheck)):
+		return ScheduleRecheck
+	}
+	return ScheduleIdle
+}
+
+// LeaveShift compares the time to leave of two recommendations and describes the change when
+// it is worth telling the rider about.
+func (p SchedulePolicy) LeaveShift(previous, next time.Time) (string, bool) {
+	shift := next.Sub(previous)
+	if previous.IsZero() || next.IsZero() || (shift < p.MinShift && -shift < p.MinShift) {
+		return "", false
+	}
+	if shift < 0 {
+		return fmt.Sprintf("Leave %.0f min earlier, at %s", -shift.Minutes(), next.In(tfl.London).Format("15:04")), true
+	}
+	return fmt.Sprintf("You can leave %.0f min later, at %s", shift.Minutes(), next.In(tfl.London).Format("15:04")), true
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"testing"
+	"time"
+)
+
+var testSchedulePolicy = SchedulePolicy{Lead: 30 * time.Minute, Recheck: 5 * time.Minute, MinShift: 3 * time.Minute}
+
+func TestScheduleNext(t *testing.T) {
+	// The rider leaves at 00:10 London time on 25 October 2026, an hour before summer time ends.
+	leaveAt := time.Date(2026, 10, 25, 0, 10, 0, 0, tfl.London)
+	waiting := common.ScheduledJourney{Status: common.ScheduleWaiting, LeaveAt: leaveAt}
+	monitoring := common.ScheduledJourney{Status: common.ScheduleMonitoring, LeaveAt: leaveAt, PlannedAt: leaveAt.Add(-20 * time.Minute)}
+	unplanned := common.ScheduledJourney{Status: common.ScheduleWaiting, Request: common.UserRequest{ArriveBy: leaveAt}}
+
+	tests := []struct {
+		name    string
+		journey common.ScheduledJourney
+		now     time.Time
+		want    ScheduleAction
+	}{
+		{"waiting, before the lead", waiting, leaveAt.Add(-30*time.Minute - time.Second), ScheduleIdle},
+		{"waiting, at the lead, the day before", waiting, leaveAt.Add(-30 * time.Minute), ScheduleFinalise},
+		{"waiting, at the time to leave", waiting, leaveAt, ScheduleFinalise},
+		{"waiting, after the time to leave", waiting, leaveAt.Add(time.Second), ScheduleExpire},
+		{"monitoring, before the recheck", monitoring, leaveAt.Add(-15*time.Minute - time.Second), ScheduleIdle},
+		{"monitoring, at the recheck", monitoring, leaveAt.Add(-15 * time.Minute), ScheduleRecheck},
+		{"monitoring, after the time to leave", monitoring, leaveAt.Add(time.Second), ScheduleExpire},
+		{"not planned, from the booked time", unplanned, leaveAt.Add(-10 * time.Minute), ScheduleFinalise},
+		{"not planned, after the booked time", unplanned, leaveAt.Add(time.Minute), ScheduleExpire},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testSchedulePolicy.Next(tt.journey, tt.now); got != tt.want {
+				t.Errorf("Next at %s = %v, want %v", tt.now.In(tfl.London).Format(time.DateTime), got, tt.want)
+			}
+		})
+	}
+}
+
+// The lead is counted in elapsed time, not on the clock: half an hour before 01:10 GMT, on the
+// night summer time ends, is 01:40 BST.
+func TestScheduleNextAcrossChangeOfTime(t *testing.T) {
+	leaveAt := time.Date(2026, 10, 25, 1, 10, 0, 0, time.UTC)
+	journey := common.ScheduledJourney{Status: common.ScheduleWaiting, LeaveAt: leaveAt}
+
+	finalise := time.Date(2026, 10, 25, 0, 40, 0, 0, time.UTC)
+	if got := finalise.In(tfl.London).Format("15:04 MST"); got != "01:40 BST" {
+		t.Fatalf("the lead starts at %s", got)
+	}
+	if got := testSchedulePolicy.Next(journey, finalise.Add(-time.Second)); got != ScheduleIdle {
+		t.Errorf("just before the lead: %v", got)
+	}
+	if got := testSchedulePolicy.Next(journey, finalise); got != ScheduleFinalise {
+		t.Errorf("at the lead: %v", got)
+	}
+}
+
+func TestLeaveShift(t *testing.T) {
+	previous := time.Date(2026, 10, 19, 23, 58, 0, 0, tfl.London)
+	tests := []struct {
+		name      string
+		previous  time.Time
+		next      time.Time
+		want      string
+		wantAlert bool
+	}{
+		{"below the threshold", previous, previous.Add(2*time.Minute + 59*time.Second), "", false},
+		{"below the threshold, earlier", previous, previous.Add(-2 * time.Minute), "", false},
+		{"at the threshold, into the next day", previous, previous.Add(3 * time.Minute), "You can leave 3 min later, at 00:01", true},
+		{"earlier", previous, previous.Add(-10 * time.Minute), "Leave 10 min earlier, at 23:48", true},
+		{"no earlier recommendation", time.Time{}, previous, "", false},
+		{"no new recommendation", previous, time.Time{}, "", false},
+		{"across the start of summer time", time.Date(2026, 3, 29, 0, 55, 0, 0, time.UTC), time.Date(2026, 3, 29, 1, 5, 0, 0, time.UTC), "You can leave 10 min later, at 02:05", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, alert := testSchedulePolicy.LeaveShift(tt.previous, tt.next)
+			if got != tt.want || alert != tt.wantAlert {
+				t.Errorf("LeaveShift = %q, %v, want %q, %v", got, alert, tt.want, tt.wantAlert)
+			}
+		})
+	}
+}
+
+func TestScheduleStore(t *testing.T) {
+	s := NewScheduleStore()
+	base := time.Date(2026, 10, 19, 8, 0, 0, 0, tfl.London)
+	s.Put(common.ScheduledJourney{ScheduleID: "late", UserID: "alice", LeaveAt: base.Add(time.Hour)})
+	s.Put(common.ScheduledJourney{ScheduleID: "early", UserID: "alice", LeaveAt: base})
+	s.Put(common.ScheduledJourney{ScheduleID: "other", UserID: "bob", LeaveAt: base.Add(30 * time.Minute)})
+
+	journeys := s.ForUser("alice")
+	if len(journeys) != 2 || journeys[0].ScheduleID != "early" || journeys[1].ScheduleID != "late" {
+		t.Errorf("ForUser = %+v, want early then late", journeys)
+	}
+	if _, err := s.Get("bob", "early"); err == nil {
+		t.Error("Get returned the journey of another user")
+	}
+	s.Delete("early")
+	if _, err := s.Get("alice", "early"); err == nil {
+		t.Error("Get returned a deleted journey")
+	}
+	if len(s.All()) != 2 {
+		t.Errorf("All = %+v", s.All())
+	}
+}
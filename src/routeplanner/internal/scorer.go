@@ -0,0 +1,275 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CriterionDuration     = "duration"
+	CriterionCrowding     = "crowding"
+	CriterionInterchanges = "interchanges"
+	CriterionWalking      = "walking"
+	CriterionDisruption   = "disruption"
+	CriterionReliability  = "reliability"
+	CriterionEarlyArrival = "early_arrival"
+	CriterionAccess       = "accessibility"
+	CriterionCost         = "cost"
+
+	// interchangePenalty is the number of minutes one change is considered to cost.
+	interchangePenalty = 5
+)
+
+// JourneyFacts carries everything a criterion may need to evaluate one candidate journey.
+type JourneyFacts struct {
+	Journey     common.TFLJourney
+	AvgCrowding float64
+	Departure   time.Time
+	ArriveBy    time.Time            // zero unless the user asked to arrive by a given time
+	Access      *AccessibilityPolicy // nil unless the user has an accessibility profile
+	Fare        FareQuote            // after the user's daily cap
+}
+
+// Criterion turns a candidate journey into a cost expressed in minute-equivalents. Lower is better.
+type Criterion interface {
+	Name() string
+	Cost(facts JourneyFacts) float64
+}
+
+// LineStatusSource reports how disrupted a line currently is, from 0 (good service) to 1 (closed).
+type LineStatusSource interface {
+	Disruption(lineID string) float64
+}
+
+// ReliabilitySource reports the historical probability that a line is disrupted at a given time.
+type ReliabilitySource interface {
+	DisruptionProbability(lineID string, at time.Time) float64
+}
+
+type noStatus struct{}
+
+func (noStatus) Disruption(string) float64 { return 0 }
+
+type noReliability struct{}
+
+func (noReliability) DisruptionProbability(string, time.Time) float64 { return 0 }
+
+type criterionFunc struct {
+	name string
+	cost func(facts JourneyFacts) float64
+}
+
+func (c criterionFunc) Name() string                    { return c.name }
+func (c criterionFunc) Cost(facts JourneyFacts) float64 { return c.cost(facts) }
+
+// NewCriterion wraps a plain function as a Criterion.
+func NewCriterion(name string, cost func(facts JourneyFacts) float64) Criterion {
+	return criterionFunc{name: name, cost: cost}
+}
+
+// DefaultCriteria returns the built-in criteria. Nil sources are treated as "no information".
+func DefaultCriteria(status LineStatusSource, reliability ReliabilitySource) []Criterion {
+	if status == nil {
+		status = noStatus{}
+	}
+	if reliability == nil {
+		reliability = noReliability{}
+	}
+
+	return []Criterion{
+		NewCriterion(CriterionDuration, func(f JourneyFacts) float64 {
+			return float64(f.Journey.Duration)
+		}),
+		NewCriterion(CriterionCrowding, func(f JourneyFacts) float64 {
+			return float64(f.Journey.Duration) * f.AvgCrowding
+		}),
+		NewCriterion(CriterionInterchanges, func(f JourneyFacts) float64 {
+			return float64(CountChanges(f.Journey) * interchangePenalty)
+		}),
+		NewCriterion(CriterionWalking, func(f JourneyFacts) float64 {
+			return float64(WalkingMinutes(f.Journey))
+		}),
+		NewCriterion(CriterionDisruption, func(f JourneyFacts) float64 {
+			return float64(f.Journey.Duration) * worstLine(f.Journey, func(lineID string, _ common.TFLLeg) float64 {
+				return status.Disruption(lineID)
+			})
+		}),
+		NewCriterion(CriterionReliability, func(f JourneyFacts) float64 {
+			// Each line is judged at the time the journey actually rides it.
+			return float64(f.Journey.Duration) * worstLine(f.Journey, func(lineID string, leg common.TFLLeg) float64 {
+				at := f.Departure
+				if dep, err := ParseTfLTime(leg.DepartureTime); err == nil {
+					at = dep
+				}
+				return reliability.DisruptionProbability(lineID, at)
+			})
+		}),
+		NewCriterion(CriterionEarlyArrival, func(f JourneyFacts) float64 {
+			if f.ArriveBy.IsZero() {
+				return 0
+			}
+			_, arrival, ok := JourneyWindow(f.Journey)
+			if !ok || !arrival.Before(f.ArriveBy) {
+				return 0
+			}
+			return f.ArriveBy.Sub(arrival).Minutes()
+		}),
+		NewCriterion(CriterionAccess, func(f JourneyFacts) float64 {
+			return f.Access.Cost(f.Journey)
+		}),
+		NewCriterion(CriterionCost, func(f JourneyFacts) float64 {
+			return f.Fare.Pounds() * fareMinutesPerPound
+		}),
+	}
+}
+
+// DefaultWeights reproduces the original duration * (1 + crowding) score and adds the new criteria on top.
+func DefaultWeights() map[string]float64 {
+	return map[string]float64{
+		CriterionDuration:     1,
+		CriterionCrowding:     1,
+		CriterionInterchanges: 1,
+		CriterionWalking:      0.5,
+		CriterionDisruption:   1,
+		CriterionReliability:  1,
+		CriterionEarlyArrival: 0.5,
+		CriterionAccess:       1,
+		CriterionCost:         1,
+	}
+}
+
+// Scorer combines a set of criteria into a single weighted score.
+type Scorer struct {
+	criteria []Criterion
+	weights  map[string]float64
+}
+
+func NewScorer(criteria []Criterion, weights map[string]float64) (*Scorer, error) {
+	s := &Scorer{
+		criteria: criteria,
+		weights:  make(map[string]float64, len(criteria)),
+	}
+	for _, c := range criteria {
+		s.weights[c.Name()] = 0
+	}
+	if err := s.apply(weights); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WithOverrides returns a copy of the scorer with some weights replaced, e.g. by a user's preferences.
+func (s *Scorer) WithOverrides(overrides map[string]float64) (*Scorer, error) {
+	if len(overrides) == 0 {
+		return s, nil
+	}
+
+	clone := &Scorer{
+		criteria: s.criteria,
+		weights:  make(map[string]float64, len(s.weights)),
+	}
+	for name, w := range s.weights {
+		clone.weights[name] = w
+	}
+	if err := clone.apply(overrides); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+func (s *Scorer) apply(weights map[string]float64) error {
+	for name, w := range weights {
+		if _, ok := s.weights[name]; !ok {
+			return fmt.Errorf("unknown scoring criterion '%s'", name)
+		}
+		if w < 0 {
+			return fmt.Errorf("weight for criterion '%s' must not be negative", name)
+		}
+		s.weights[name] = w
+	}
+	return nil
+}
+
+// Score evaluates every criterion and returns the total together with its per-criterion breakdown.
+func (s *Scorer) Score(facts JourneyFacts) (float64, []common.ScoreComponent) {
+	var total float64
+	breakdown := make([]common.ScoreComponent, 0, len(s.criteria))
+
+	for _, c := range s.criteria {
+		weight := s.weights[c.Name()]
+		value := c.Cost(facts)
+		contribution := weight * value
+		total += contribution
+
+		breakdown = append(breakdown, common.ScoreComponent{
+			Criterion:    c.Name(),
+			Value:        value,
+			Weight:       weight,
+			Contribution: contribution,
+		})
+	}
+
+	return total, breakdown
+}
+
+// ParseWeights reads a "name=weight,name=weight" list such as the SCORING_WEIGHTS env variable.
+func ParseWeights(spec string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	if strings.TrimSpace(spec) == "" {
+		return weights, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid weight '%s': expected name=value", pair)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight for '%s': %w", name, err)
+		}
+		weights[strings.ToLower(strings.TrimSpace(name))] = w
+	}
+
+	return weights, nil
+}
+
+// Weights returns the effective weights, sorted by criterion name for stable logging.
+func (s *Scorer) Weights() []string {
+	var out []string
+	for name, w := range s.weights {
+		out = append(out, fmt.Sprintf("%s=%.2f", name, w))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// WalkingMinutes sums the duration of all walking legs of a journey.
+func WalkingMinutes(journey common.TFLJourney) int {
+	var minutes int
+	for _, leg := range journey.Legs {
+		if leg.Mode.Name == "walking" {
+			minutes += leg.Duration
+		}
+	}
+	return minutes
+}
+
+func worstLine(journey common.TFLJourney, level func(lineID string, leg common.TFLLeg) float64) float64 {
+	var worst float64
+	for _, leg := range journey.Legs {
+		for _, option := range leg.RouteOptions {
+			if option.LineIdentifier.ID == "" {
+				continue
+			}
+			if l := level(option.LineIdentifier.ID, leg); l > worst {
+				worst = l
+			}
+		}
+	}
+	return worst
+}
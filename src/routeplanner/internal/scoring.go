@@ -3,23 +3,33 @@ package internal
 import (
 	"fmt"
 	"github.com/matteoavallone7/optimaLDN/src/common"
-	"log"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
 	"strings"
 	"time"
 )
 
 func GetNaptan(location string) (string, bool) {
 
-	if NaptanMap == nil {
+	if Stations == nil {
 		return "", false
 	}
 
-	code, ok := NaptanMap[location]
-	return code, ok
+	station, err := Stations.Resolve(location)
+	if err != nil {
+		return "", false
+	}
+	return station.Naptan, true
+}
+
+const tflTimeLayout = "2006-01-02T15:04:05"
+
+// ParseTfLTime parses a TfL timestamp, which is always London local time.
+func ParseTfLTime(value string) (time.Time, error) {
+	return time.ParseInLocation(tflTimeLayout, value, tfl.London)
 }
 
 func TimeStringToTfLTimeBand(timeStr string) (string, error) {
-	t, err := time.Parse("2006-01-02T15:04:05", timeStr)
+	t, err := ParseTfLTime(timeStr)
 	if err != nil {
 		return "", fmt.Errorf("invalid time format: %w", err)
 	}
@@ -27,59 +37,63 @@ func TimeStringToTfLTimeBand(timeStr string) (string, error) {
 	return TimeToTfLTimeBand(t), nil
 }
 
-func TimeToTfLTimeBand(t time.Time) string {
-	minutes := (t.Minute() / 15) * 15
-	start := time.Date(0, 1, 1, t.Hour(), minutes, 0, 0, time.UTC)
-	end := start.Add(15 * time.Minute)
-	return fmt.Sprintf("%02d:%02d-%02d:%02d", start.Hour(), start.Minute(), end.Hour(), end.Minute())
+// StopPass is the moment a journey is expected to pass one of its stop points.
+type StopPass struct {
+	Naptan string
+	At     time.Time
 }
 
-func GetScore(totalCrowding float64, totalStops, duration int) float64 {
-	var avgCrowding float64
-	if totalStops > 0 {
-		avgCrowding = totalCrowding / float64(totalStops)
+// StopPasses spreads the stops of a leg evenly between its departure and arrival times,
+// so crowding can be looked up for the time band in which each stop is actually passed.
+func StopPasses(leg common.TFLLeg) ([]StopPass, error) {
+	dep, err := ParseTfLTime(leg.DepartureTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid departure time %s: %w", leg.DepartureTime, err)
+	}
+	arr, err := ParseTfLTime(leg.ArrivalTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid arrival time %s: %w", leg.ArrivalTime, err)
 	}
 
-	return float64(duration) * (1 + avgCrowding)
+	stops := leg.Path.StopPoints
+	passes := make([]StopPass, len(stops))
+	for i, stop := range stops {
+		at := dep
+		if len(stops) > 1 {
+			at = dep.Add(arr.Sub(dep) * time.Duration(i) / time.Duration(len(stops)-1))
+		}
+		passes[i] = StopPass{Naptan: stop.ID, At: at}
+	}
+	return passes, nil
 }
 
-func EstimateCurrentStop(route common.ChosenRoute) (string, error) {
-	now := time.Now()
-
-	if len(route.Legs) == 0 {
-		return "", fmt.Errorf("no legs in the route")
+// JourneyWindow returns when a journey really starts and ends, whatever time was requested.
+func JourneyWindow(journey common.TFLJourney) (time.Time, time.Time, bool) {
+	start, errStart := ParseTfLTime(journey.StartDateTime)
+	end, errEnd := ParseTfLTime(journey.ArrivalDateTime)
+	if (errStart != nil || errEnd != nil) && len(journey.Legs) > 0 {
+		start, errStart = ParseTfLTime(journey.Legs[0].DepartureTime)
+		end, errEnd = ParseTfLTime(journey.Legs[len(journey.Legs)-1].ArrivalTime)
 	}
-	lastLeg := route.Legs[len(route.Legs)-1]
-	log.Printf("📌 Last leg start: %s, end: %s", lastLeg.StartTime, lastLeg.EndTime)
-	journeyEndTime, err := time.Parse("2006-01-02T15:04:05", lastLeg.EndTime)
-	if err == nil && now.After(journeyEndTime) {
-		return "", fmt.Errorf("journey already completed")
+	if errStart != nil || errEnd != nil {
+		return time.Time{}, time.Time{}, false
 	}
+	return start, end, true
+}
 
-	for _, leg := range route.Legs {
-		loc, _ := time.LoadLocation("Europe/Rome")
-		depTime, err1 := time.ParseInLocation("2006-01-02T15:04:05", leg.StartTime, loc)
-		arrTime, err2 := time.ParseInLocation("2006-01-02T15:04:05", leg.EndTime, loc)
-
-		if err1 != nil || err2 != nil {
-			continue
-		}
-
-		if !now.Before(depTime) && now.Before(arrTime) {
-			if len(leg.StopIDs) == 0 {
-				return "", fmt.Errorf("no stops available in current leg")
-			}
+func TimeToTfLTimeBand(t time.Time) string {
+	t = t.In(tfl.London)
+	minutes := (t.Minute() / 15) * 15
+	start := time.Date(0, 1, 1, t.Hour(), minutes, 0, 0, time.UTC)
+	end := start.Add(15 * time.Minute)
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", start.Hour(), start.Minute(), end.Hour(), end.Minute())
+}
 
-			progress := now.Sub(depTime).Seconds() / arrTime.Sub(depTime).Seconds()
-			index := int(progress * float64(len(leg.StopIDs)))
-			if index >= len(leg.StopIDs) {
-				index = len(leg.StopIDs) - 1
-			}
-			return leg.StopIDs[index], nil
-		}
+func AverageCrowding(totalCrowding float64, totalStops int) float64 {
+	if totalStops == 0 {
+		return 0
 	}
-
-	return "", fmt.Errorf("unable to estimate current stop from time range")
+	return totalCrowding / float64(totalStops)
 }
 
 func ConvertToChosenRoute(userID string, tflJourney common.TFLJourney) common.ChosenRoute {
@@ -124,26 +138,83 @@ func ConvertToChosenRoute(userID string, tflJourney common.TFLJourney) common.Ch
 	}
 }
 
-func ConvertToActiveRoute(userID string, route *common.ChosenRoute) *common.ActiveRoute {
-	lineNameSet := make(map[string]struct{})
-	for _, leg := range route.Legs {
-		lineNameSet[leg.LineName] = struct{}{}
+func ConvertToRouteAlternative(rank int, ranked RankedJourney) common.RouteAlternative {
+	journey := ranked.Journey
+	var summary []string
+	for _, leg := range journey.Legs {
+		summary = append(summary, leg.Instruction.Detailed)
+	}
+
+	return common.RouteAlternative{
+		Rank:      rank,
+		Score:     ranked.Score,
+		Breakdown: ranked.Breakdown,
+		Duration:  journey.Duration,
+		Changes:   CountChanges(journey),
+		Lines:     JourneyLines(journey),
+		Summary:   strings.Join(summary, " → "),
+		Legs:      ranked.ChosenRoute("").Legs,
+		Waypoints: ranked.Waypoints,
+		Fare:      ranked.Fare.Pounds(),
+		Capped:    ranked.Fare.Capped,
 	}
+}
 
-	var lineIDs []string
-	for id := range lineNameSet {
-		lineIDs = append(lineIDs, id)
+// CountChanges returns the number of interchanges between transit legs, ignoring walking legs.
+func CountChanges(journey common.TFLJourney) int {
+	var transitLegs int
+	for _, leg := range journey.Legs {
+		if leg.Mode.Name != "walking" {
+			transitLegs++
+		}
+	}
+	if transitLegs == 0 {
+		return 0
+	}
+	return transitLegs - 1
+}
+
+func JourneyLines(journey common.TFLJourney) []string {
+	var lines []string
+	seen := make(map[string]struct{})
+	for _, leg := range journey.Legs {
+		for _, option := range leg.RouteOptions {
+			name := option.LineIdentifier.Name
+			if name == "" {
+				continue
+			}
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				lines = append(lines, name)
+			}
+		}
 	}
+	return lines
+}
 
+func ConvertToActiveRoute(userID string, route *common.ChosenRoute) (*common.ActiveRoute, error) {
+	lineIDs, err := RouteLineIDs(*route)
 	return &common.ActiveRoute{
 		UserID:  userID,
 		LineIDs: lineIDs,
-	}
+	}, err
 }
 
+// ConvertUserSavedToChosenRoute rebuilds the route a saved route was made from. Routes saved
+// before their legs were stored only have one rough leg per line.
 func ConvertUserSavedToChosenRoute(saved *common.UserSavedRoute) common.ChosenRoute {
 	desc := fmt.Sprintf("%s → %s via %s", saved.StartPoint, saved.EndPoint, strings.Join(saved.LineNames, ", "))
 
+	if len(saved.Legs) > 0 {
+		return common.ChosenRoute{
+			UserID:        saved.UserID,
+			TotalDuration: saved.EstimatedTime,
+			Description:   desc,
+			Legs:          append([]common.RouteLeg(nil), saved.Legs...),
+			Waypoints:     saved.Waypoints,
+		}
+	}
+
 	legs := make([]common.RouteLeg, len(saved.LineNames))
 	for i := range saved.LineNames {
 		leg := common.RouteLeg{
@@ -165,6 +236,63 @@ func ConvertUserSavedToChosenRoute(saved *common.UserSavedRoute) common.ChosenRo
 	}
 }
 
+// RetimeRoute moves the timetable of a route so that it sets off at departure, keeping the
+// time between its legs.
+func RetimeRoute(route *common.ChosenRoute, departure time.Time) error {
+	if len(route.Legs) == 0 {
+		return fmt.Errorf("no legs in the route")
+	}
+	first, err := ParseTfLTime(route.Legs[0].StartTime)
+	if err != nil {
+		return fmt.Errorf("route has no timetable: %w", err)
+	}
+	shift := departure.Truncate(time.Minute).Sub(first)
+
+	legs := make([]common.RouteLeg, len(route.Legs))
+	for i, leg := range route.Legs {
+		start, errStart := ParseTfLTime(leg.StartTime)
+		end, errEnd := ParseTfLTime(leg.EndTime)
+		if errStart != nil || errEnd != nil {
+			return fmt.Errorf("leg %d has no timetable", i+1)
+		}
+		leg.StartTime = start.Add(shift).In(tfl.London).Format(tflTimeLayout)
+		leg.EndTime = end.Add(shift).In(tfl.London).Format(tflTimeLayout)
+		legs[i] = leg
+	}
+	route.Legs = legs
+	return nil
+}
+
+// RouteLineIDs returns the canonical IDs of the lines a route rides on, in order, for its active
+// route. Legs of routes saved without line IDs are resolved by line name. The error names the
+// lines the registry does not know, which are left out.
+func RouteLineIDs(route common.ChosenRoute) ([]string, error) {
+	var refs []string
+	for _, leg := range route.Legs {
+		if leg.LineID != "" {
+			refs = append(refs, leg.LineID)
+		} else {
+			refs = append(refs, leg.LineName)
+		}
+	}
+	return Lines.Canonical(refs)
+}
+
+// JourneyLineIDs is RouteLineIDs for a TfL journey.
+func JourneyLineIDs(journey common.TFLJourney) ([]string, error) {
+	var refs []string
+	for _, leg := range journey.Legs {
+		for _, option := range leg.RouteOptions {
+			if option.LineIdentifier.ID != "" {
+				refs = append(refs, option.LineIdentifier.ID)
+			} else {
+				refs = append(refs, option.LineIdentifier.Name)
+			}
+		}
+	}
+	return Lines.Canonical(refs)
+}
+
 func NormalizeStopID(stopID string) string {
 	if strings.HasPrefix(stopID, "940G") {
 		return "9400" + stopID[4:]
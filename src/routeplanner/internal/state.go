@@ -1,13 +1,31 @@
 package internal
 
 import (
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/matteoavallone7/optimaLDN/src/common/lines"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
 	"github.com/matteoavallone7/optimaLDN/src/rabbitmq"
+	"time"
 )
 
 var (
 	RoutePublisher *rabbitmq.Publisher
-	DBClient       *dynamodb.Client
-	TflAPIKey      string
-	NaptanMap      = make(map[string]string)
+	Store          store.Repository
+	TfL            tfl.Client
+	Stations       *StationResolver
+	Lines          *lines.Registry
+	PendingRoutes  = NewPendingStore(15 * time.Minute)
+	DefaultScorer  *Scorer
+	Crowding       *CrowdingCache
+	Fallback       *Router
+	CheckIns       = NewCheckInStore(6 * time.Hour)
+	Disruptions    = NewDisruptionReports(30 * time.Minute)
+	Recalculation  RecalculationPolicy
+	Preferences    = NewPreferenceStore()
+	Accessibility  *AccessibilityIndex
+	Fares          *FareEngine
+	Reliability    = NewReliabilityTable()
+	LineStatus     = NewLineStatusView(10 * time.Minute)
+	Schedules      = NewScheduleStore()
+	Scheduling     SchedulePolicy
 )
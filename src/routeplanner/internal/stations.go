@@ -0,0 +1,345 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// minMatchScore is the lowest similarity still offered as a candidate.
+	minMatchScore = 0.6
+	// confidentScore and confidentMargin decide when a fuzzy match is unambiguous.
+	confidentScore  = 0.85
+	confidentMargin = 0.1
+)
+
+// stationAliases maps common spellings and abbreviations to a "Timetable Name" in stationCodes.csv;
+// an alias names the hub of that station.
+var stationAliases = map[string]string{
+	"kx":                     "King's Cross",
+	"kings x":                "King's Cross",
+	"st pancras":             "King's Cross",
+	"tcr":                    "Tottenham Court Road",
+	"elephant":               "Elephant & Castle",
+	"highbury":               "Highbury & Islington",
+	"heathrow terminal 2":    "Heathrow Terminals 1 2 3",
+	"heathrow terminal 3":    "Heathrow Terminals 1 2 3",
+	"heathrow terminals 2 3": "Heathrow Terminals 1 2 3",
+	"liverpool st":           "Liverpool Street",
+	"oxford st":              "Oxford Circus",
+	"piccadilly":             "Piccadilly Circus",
+	"st james park":          "St. James's Park",
+}
+
+// Station is one stop point of stationCodes.csv with every name it is known by.
+type Station struct {
+	Name   string
+	Naptan string
+	names  []string // normalised
+	// hub is the main stop point of the station's Timetable Code, e.g. Paddington for the
+	// separate Hammersmith & City platforms. A name shared by the stop points of a hub
+	// resolves to the hub.
+	hub       *Station
+	qualified bool // the NaPTAN name narrows the stop point, as in "Paddington (H&C Line)"
+}
+
+// StationResolver maps free-text station names to NaPTAN codes.
+type StationResolver struct {
+	stations []*Station
+	byNaptan map[string]*Station
+	exact    map[string][]*Station
+}
+
+// AmbiguousStationError is returned when a name matches several stations equally well.
+type AmbiguousStationError struct {
+	Query      string
+	Candidates []common.StationMatch
+}
+
+func (e *AmbiguousStationError) Error() string {
+	names := make([]string, len(e.Candidates))
+	for i, c := range e.Candidates {
+		names[i] = c.Name
+	}
+	return fmt.Sprintf("station '%s' is ambiguous, did you mean: %s", e.Query, strings.Join(names, ", "))
+}
+
+// NewStationResolver builds the resolver from the stationCodes.csv records, header included.
+func NewStationResolver(records [][]string) *StationResolver {
+	r := &StationResolver{
+		byNaptan: make(map[string]*Station),
+		exact:    make(map[string][]*Station),
+	}
+
+	hubs := make(map[string][]*Station) // Timetable Code -> stop points
+	for i, record := range records {
+		if i == 0 || len(record) < 5 || record[4] == "" {
+			continue
+		}
+		naptan := record[4]
+		st, ok := r.byNaptan[naptan]
+		if !ok {
+			st = &Station{Name: record[1], Naptan: naptan}
+			r.byNaptan[naptan] = st
+			r.stations = append(r.stations, st)
+		}
+		if !containsStation(hubs[record[0]], st) {
+			hubs[record[0]] = append(hubs[record[0]], st)
+		}
+		r.addName(st, record[1])
+		r.addName(st, record[3])
+		if len(record) > 5 {
+			r.addName(st, record[5])
+			st.qualified = st.qualified || strings.Contains(record[5], "(")
+		}
+	}
+	for _, stops := range hubs {
+		hub := stops[0]
+		for _, st := range stops {
+			if !st.qualified {
+				hub = st
+				break
+			}
+		}
+		for _, st := range stops {
+			if st.hub == nil {
+				st.hub = hub
+			}
+		}
+	}
+
+	for alias, target := range stationAliases {
+		for _, st := range r.stations {
+			if st.Name == target {
+				r.addName(st.hub, alias)
+				break
+			}
+		}
+	}
+
+	return r
+}
+
+func (r *StationResolver) addName(st *Station, name string) {
+	variants := []string{NormalizeStationName(name), NormalizeStationName(stripParentheses(name))}
+	for _, v := range variants {
+		if v == "" || containsString(st.names, v) {
+			continue
+		}
+		st.names = append(st.names, v)
+		r.exact[v] = append(r.exact[v], st)
+	}
+}
+
+// Resolve returns the NaPTAN code for a station name or code. Ambiguous names yield an
+// *AmbiguousStationError carrying the best candidates.
+func (r *StationResolver) Resolve(query string) (*Station, error) {
+	if st, ok := r.byNaptan[strings.ToUpper(strings.TrimSpace(query))]; ok {
+		return st, nil
+	}
+
+	norm := NormalizeStationName(query)
+	switch matches := r.exact[norm]; {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1 && sameHub(matches):
+		return matches[0].hub, nil
+	}
+
+	candidates := r.Search(query, 5)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("station '%s' not found in station mapping", query)
+	}
+
+	best := candidates[0]
+	if best.Score >= confidentScore && (len(candidates) == 1 || best.Score-candidates[1].Score >= confidentMargin) {
+		return r.byNaptan[best.Naptan], nil
+	}
+
+	return nil, &AmbiguousStationError{Query: query, Candidates: candidates}
+}
+
+// Name returns the display name of a NaPTAN code, or the code itself when it is unknown.
+func (r *StationResolver) Name(naptan string) string {
+	if st, ok := r.byNaptan[naptan]; ok {
+		return st.Name
+	}
+	return naptan
+}
+
+// Search ranks stations by similarity to the query, best first. The stop points of a hub make
+// one candidate, the hub, scored by the best of them.
+func (r *StationResolver) Search(query string, limit int) []common.StationMatch {
+	norm := NormalizeStationName(query)
+	if norm == "" {
+		return nil
+	}
+
+	byHub := make(map[*Station]int) // hub -> index in matches
+	var matches []common.StationMatch
+	for _, st := range r.stations {
+		var best float64
+		for _, name := range st.names {
+			if s := similarity(norm, name); s > best {
+				best = s
+			}
+		}
+		if best < minMatchScore {
+			continue
+		}
+		if i, ok := byHub[st.hub]; ok {
+			matches[i].Score = max(matches[i].Score, best)
+			continue
+		}
+		byHub[st.hub] = len(matches)
+		matches = append(matches, common.StationMatch{Name: st.hub.Name, Naptan: st.hub.Naptan, Score: best})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Name < matches[j].Name
+	})
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// NormalizeStationName lowercases a name, drops apostrophes and punctuation, spells out "&"
+// and removes generic words such as "underground station".
+func NormalizeStationName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "&", " and ")
+	name = strings.NewReplacer("'", "", "’", "", ".", "").Replace(name)
+
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+
+	var tokens []string
+	for _, t := range strings.Fields(b.String()) {
+		switch t {
+		case "underground", "station", "tube", "dlr", "rail":
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	return strings.Join(tokens, " ")
+}
+
+func stripParentheses(name string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range name {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// similarity scores two normalised names between 0 and 1, combining prefix, token and
+// edit-distance matching.
+func similarity(query, name string) float64 {
+	if query == name {
+		return 1
+	}
+	if strings.HasPrefix(name, query) {
+		return 0.9
+	}
+
+	score := 1 - float64(levenshtein(query, name))/float64(max(len([]rune(query)), len([]rune(name))))
+
+	queryTokens := strings.Fields(query)
+	nameTokens := strings.Fields(name)
+	var tokenTotal float64
+	for _, qt := range queryTokens {
+		var best float64
+		for _, nt := range nameTokens {
+			var s float64
+			switch {
+			case qt == nt:
+				s = 1
+			case strings.HasPrefix(nt, qt):
+				s = 0.9
+			default:
+				s = 1 - float64(levenshtein(qt, nt))/float64(max(len([]rune(qt)), len([]rune(nt))))
+			}
+			if s > best {
+				best = s
+			}
+		}
+		tokenTotal += best
+	}
+	// Every query token found in the name, slightly discounted so exact names still win.
+	if tokenScore := 0.85 * tokenTotal / float64(len(queryTokens)); tokenScore > score {
+		score = tokenScore
+	}
+
+	return score
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// sameHub reports whether stations are all stop points of one hub.
+func sameHub(stations []*Station) bool {
+	for _, st := range stations[1:] {
+		if st.hub != stations[0].hub {
+			return false
+		}
+	}
+	return true
+}
+
+func containsStation(list []*Station, st *Station) bool {
+	for _, v := range list {
+		if v == st {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
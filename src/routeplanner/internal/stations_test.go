@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func repoStations(t *testing.T) *StationResolver {
+	t.Helper()
+	file, err := os.Open("../../../stationCodes.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStationResolver(records)
+}
+
+func TestResolveRepoStations(t *testing.T) {
+	r := repoStations(t)
+	tests := []struct {
+		query string
+		want  string // NaPTAN, or "" when the query is ambiguous
+	}{
+		{"kx", "9400ZZLUKSX"},
+		{"Kings Cross", "9400ZZLUKSX"},
+		{"paddington", "9400ZZLUPAC"},
+		{"Paddington (H&C Line)", "9400ZZLUPAH"},
+		{"9400ZZLUPAH", "9400ZZLUPAH"},
+		{"Padington", "9400ZZLUPAC"},
+		{"Oxford Cirkus", "9400ZZLUOXC"},
+		{"Edgware Road", ""},
+		{"Hammersmith", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			st, err := r.Resolve(tt.query)
+			if tt.want == "" {
+				var ambiguous *AmbiguousStationError
+				if !errors.As(err, &ambiguous) || len(ambiguous.Candidates) != 2 {
+					t.Fatalf("Resolve = %v, %v, want two candidates", st, err)
+				}
+				return
+			}
+			if err != nil || st.Naptan != tt.want {
+				t.Errorf("Resolve = %v, %v, want %s", st, err, tt.want)
+			}
+		})
+	}
+}
+
+// hubRecords have the stop points of Paddington's Timetable Code, the qualified one first.
+var hubRecords = [][]string{
+	{"Timetable Code", "Timetable Name", "Trackernet Code", "Trackernet Name", "ATCO Code", "NaPTAN Name"},
+	{"PAD", "Paddington", "PAD", "Paddington", "9400ZZLUPAH", "Paddington (H&C Line)-Underground"},
+	{"PAD", "Paddington", "PADc", "Paddington Circle", "9400ZZLUPAC", "Paddington Underground Station"},
+	{"PAD", "Paddington", "PAD", "Paddington", "9400ZZLUPAC", "Paddington Underground Station"},
+	{"BST", "Baker Street", "BST", "Baker Street", "9400ZZLUBST", "Baker Street Underground Station"},
+}
+
+func TestStationHubs(t *testing.T) {
+	r := NewStationResolver(hubRecords)
+
+	if st, err := r.Resolve("Paddington"); err != nil || st.Naptan != "9400ZZLUPAC" {
+		t.Errorf("Resolve(Paddington) = %v, %v, want the hub", st, err)
+	}
+	if st, err := r.Resolve("Paddington (H&C Line)"); err != nil || st.Naptan != "9400ZZLUPAH" {
+		t.Errorf("Resolve(Paddington (H&C Line)) = %v, %v, want the stop point", st, err)
+	}
+	matches := r.Search("padington", 5)
+	if len(matches) != 1 || matches[0].Naptan != "9400ZZLUPAC" {
+		t.Errorf("Search(padington) = %v, want the hub alone", matches)
+	}
+}
+
+func TestStationAliasNamesHub(t *testing.T) {
+	stationAliases["padd"] = "Paddington"
+	t.Cleanup(func() { delete(stationAliases, "padd") })
+
+	r := NewStationResolver(hubRecords)
+	if st, err := r.Resolve("padd"); err != nil || st.Naptan != "9400ZZLUPAC" {
+		t.Errorf("Resolve(padd) = %v, %v, want the hub", st, err)
+	}
+}
+
+// letterStations are stations named by 20 letters, n of them changed from "a" to "b" at the
+// end; a query of 20 "a"s scores 1 - n/20 against them.
+func letterStations(changed ...int) *StationResolver {
+	records := [][]string{hubRecords[0]}
+	for i, n := range changed {
+		name := strings.Repeat("a", 20-n) + strings.Repeat("b", n)
+		code := string(rune('A' + i))
+		records = append(records, []string{code, name, code, name, "9400ZZLU" + code, name})
+	}
+	return NewStationResolver(records)
+}
+
+func TestResolveThresholds(t *testing.T) {
+	query := strings.Repeat("a", 20)
+	tests := []struct {
+		name    string
+		changed []int
+		want    string // NaPTAN of the match, or "" when none is confident
+		offered int    // candidates of an ambiguous query
+	}{
+		{"confident alone", []int{2}, "9400ZZLUA", 0},
+		{"below the confident score", []int{4}, "", 1},
+		{"clear of the runner-up", []int{1, 4}, "9400ZZLUA", 0},
+		{"too close to the runner-up", []int{1, 2}, "", 2},
+		{"runner-up below the match score", []int{4, 9}, "", 1},
+		{"nothing above the match score", []int{9}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := letterStations(tt.changed...).Resolve(query)
+			if tt.want != "" {
+				if err != nil || st.Naptan != tt.want {
+					t.Errorf("Resolve = %v, %v, want %s", st, err, tt.want)
+				}
+				return
+			}
+			var ambiguous *AmbiguousStationError
+			switch {
+			case tt.offered == 0 && (err == nil || errors.As(err, &ambiguous)):
+				t.Errorf("Resolve = %v, %v, want not found", st, err)
+			case tt.offered > 0 && (!errors.As(err, &ambiguous) || len(ambiguous.Candidates) != tt.offered):
+				t.Errorf("Resolve = %v, %v, want %d candidates", st, err, tt.offered)
+			}
+		})
+	}
+}
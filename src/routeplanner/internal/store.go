@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"context"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"log"
+)
+
+func GetActiveRoute(ctx context.Context, userID string) (*common.ChosenRoute, error) {
+	return Store.GetChosenRoute(ctx, userID)
+}
+
+func SaveChosenRoute(ctx context.Context, route common.ChosenRoute) error {
+	if err := Store.SaveChosenRoute(ctx, route); err != nil {
+		return err
+	}
+
+	log.Printf("Saved chosen route for user %s with %d legs.", route.UserID, len(route.Legs))
+	return nil
+}
+
+// ListActiveRoutes returns the chosen route of every rider currently travelling.
+func ListActiveRoutes(ctx context.Context) ([]common.ChosenRoute, error) {
+	return Store.ListChosenRoutes(ctx)
+}
+
+func DeleteChosenRoute(ctx context.Context, userID string) error {
+	if err := Store.DeleteChosenRoute(ctx, userID); err != nil {
+		return err
+	}
+
+	log.Printf("Deleted chosen route for user %s", userID)
+	return nil
+}
+
+func SaveScheduledJourney(ctx context.Context, journey common.ScheduledJourney) error {
+	return Store.SaveScheduledJourney(ctx, journey)
+}
+
+func DeleteScheduledJourney(ctx context.Context, scheduleID string) error {
+	return Store.DeleteScheduledJourney(ctx, scheduleID)
+}
+
+// LoadScheduledJourneys reads every scheduled journey, e.g. to resume monitoring after a restart.
+func LoadScheduledJourneys(ctx context.Context) ([]common.ScheduledJourney, error) {
+	return Store.ListScheduledJourneys(ctx)
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"math"
+	"strings"
+	"time"
+)
+
+// A multi-stop trip is planned one segment at a time: segment i runs from the start (or
+// waypoint i-1) to waypoint i, and the last segment ends at the destination.
+
+// StitchSegments joins the best journey of each segment into one ranked journey. Scores and
+// breakdowns add up, so waiting at a waypoint is never counted against the trip.
+func StitchSegments(parts []RankedJourney, waypoints []common.RouteWaypoint) RankedJourney {
+	if len(parts) == 1 && len(waypoints) == 0 {
+		return parts[0]
+	}
+
+	stitched := RankedJourney{Fare: FareQuote{Known: true}}
+	journeys := make([]common.TFLJourney, len(parts))
+	for i, part := range parts {
+		journeys[i] = part.Journey
+		stitched.Score += part.Score
+		stitched.Breakdown = addBreakdowns(stitched.Breakdown, part.Breakdown)
+		stitched.Segments = append(stitched.Segments, len(part.Journey.Legs))
+		stitched.Fare.Pence += part.Fare.Pence
+		stitched.Fare.FullPence += part.Fare.FullPence
+		stitched.Fare.Capped = stitched.Fare.Capped || part.Fare.Capped
+		stitched.Fare.Known = stitched.Fare.Known && part.Fare.Known
+	}
+	stitched.Journey = joinJourneys(journeys)
+	stitched.Waypoints = waypoints
+	return stitched
+}
+
+func addBreakdowns(total, part []common.ScoreComponent) []common.ScoreComponent {
+	if total == nil {
+		return append([]common.ScoreComponent(nil), part...)
+	}
+	for i := range total {
+		for _, c := range part {
+			if c.Criterion == total[i].Criterion {
+				total[i].Value += c.Value
+				total[i].Contribution += c.Contribution
+			}
+		}
+	}
+	return total
+}
+
+// joinJourneys concatenates journeys, spanning from the first departure to the last arrival.
+func joinJourneys(journeys []common.TFLJourney) common.TFLJourney {
+	var joined common.TFLJourney
+	for _, journey := range journeys {
+		joined.Legs = append(joined.Legs, journey.Legs...)
+		joined.Duration += journey.Duration
+	}
+	if len(journeys) == 0 {
+		return joined
+	}
+	joined.StartDateTime = journeys[0].StartDateTime
+	joined.ArrivalDateTime = journeys[len(journeys)-1].ArrivalDateTime
+	if start, end, ok := JourneyWindow(joined); ok {
+		// A segment skipped because the trip was already at its waypoint has no times of its own.
+		joined.StartDateTime = start.Format(tflTimeLayout)
+		joined.ArrivalDateTime = end.Format(tflTimeLayout)
+		joined.Duration = int(math.Round(end.Sub(start).Minutes()))
+	}
+	return joined
+}
+
+// SplitSegments cuts a journey back into its segments, given the number of legs in each.
+func SplitSegments(journey common.TFLJourney, segments []int) []common.TFLJourney {
+	if len(segments) < 2 {
+		return []common.TFLJourney{journey}
+	}
+
+	parts := make([]common.TFLJourney, 0, len(segments))
+	offset := 0
+	for i, count := range segments {
+		legs := journey.Legs[offset : offset+count]
+		offset += count
+
+		part := common.TFLJourney{Legs: legs}
+		if len(legs) > 0 {
+			part.StartDateTime = legs[0].DepartureTime
+			part.ArrivalDateTime = legs[len(legs)-1].ArrivalTime
+		}
+		if i == 0 {
+			// The first part keeps the start of the journey, e.g. "now" for a remaining journey.
+			part.StartDateTime = journey.StartDateTime
+		}
+		if start, end, ok := JourneyWindow(part); ok {
+			part.Duration = int(math.Round(end.Sub(start).Minutes()))
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// ChosenRoute converts the journey to a route, marking which segment each leg belongs to.
+func (r RankedJourney) ChosenRoute(userID string) common.ChosenRoute {
+	route := ConvertToChosenRoute(userID, r.Journey)
+	if len(r.Waypoints) == 0 {
+		return route
+	}
+
+	offset := 0
+	for segment, count := range r.Segments {
+		for i := offset; i < offset+count && i < len(route.Legs); i++ {
+			route.Legs[i].Segment = segment
+		}
+		offset += count
+	}
+	route.Waypoints = r.Waypoints
+
+	var names []string
+	for _, waypoint := range r.Waypoints {
+		names = append(names, waypoint.Name)
+	}
+	route.Description = fmt.Sprintf("Journey with %d legs via %s", len(route.Legs), strings.Join(names, ", "))
+	return route
+}
+
+// RemainingSegments returns the leg count of every segment from a leg onwards, together with
+// the waypoints that have not been reached yet.
+func RemainingSegments(route common.ChosenRoute, fromLeg int) ([]int, []common.RouteWaypoint) {
+	if len(route.Waypoints) == 0 || fromLeg < 0 || fromLeg >= len(route.Legs) {
+		return nil, nil
+	}
+
+	var segments []int
+	for i := fromLeg; i < len(route.Legs); i++ {
+		if i == fromLeg || route.Legs[i].Segment != route.Legs[i-1].Segment {
+			segments = append(segments, 0)
+		}
+		segments[len(segments)-1]++
+	}
+
+	current := route.Legs[fromLeg].Segment
+	if current > len(route.Waypoints) {
+		current = len(route.Waypoints)
+	}
+	return segments, route.Waypoints[current:]
+}
+
+// ResumeTime is when the rider sets off again from their estimated position: now, unless they
+// are still spending their dwell time at a waypoint.
+func ResumeTime(route common.ChosenRoute, position common.JourneyPosition, now time.Time) time.Time {
+	i := position.LegIndex
+	if position.Segment != common.SegmentWait || i <= 0 || i >= len(route.Legs) ||
+		route.Legs[i].Segment == route.Legs[i-1].Segment {
+		return now
+	}
+
+	dep, err := ParseTfLTime(route.Legs[i].StartTime)
+	if err != nil {
+		return now
+	}
+	dep = dep.Add(time.Duration(position.DelayMins * float64(time.Minute)))
+	if dep.Before(now) {
+		return now
+	}
+	return dep
+}
@@ -4,19 +4,22 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/lines"
+	"github.com/matteoavallone7/optimaLDN/src/common/store"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
 	"github.com/matteoavallone7/optimaLDN/src/rabbitmq"
 	"github.com/matteoavallone7/optimaLDN/src/routeplanner/internal"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
-	"math"
 	"net"
 	"net/rpc"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -28,13 +31,20 @@ type RoutePlanner struct{}
 const (
 	routeCreated                     = "active.route.created"
 	routeTerminated                  = "active.route.terminated"
+	journeyArchived                  = "journey.archived"
 	routeOutboundNotifications       = "route_planner_exchange"
 	notificationOutboundExchangeName = "notification_outbound_events_exchange"
 	routeExchangeType                = "topic"
 	notificationQueueName            = "notifications_queue"
 	bindingKey                       = "route.update.#"
+	fallbackAlternatives             = 3
+	severeDisruption                 = 0.75 // severe delays or worse
+	scheduleTick                     = time.Minute
 )
 
+// liftOutageModes are the modes whose stations are watched for lift outages.
+var liftOutageModes = []string{"tube", "dlr", "overground", "elizabeth-line"}
+
 func failOnError(err error, msg string) {
 	if err != nil {
 		log.Fatalf("%s: %s", msg, err)
@@ -45,41 +55,412 @@ func (r *RoutePlanner) ServeRequest(args *common.UserRequest, reply *common.Rout
 
 	fmt.Printf("Requested route from '%s' to '%s'\n", args.StartPoint, args.EndPoint)
 	fmt.Println("Acquiring start-point NapTan code..")
-	startPoint, okStart := internal.GetNaptan(args.StartPoint)
-	if !okStart {
-		return fmt.Errorf("start point '%s' not found in station mapping", args.StartPoint)
+	start, startCandidates, err := resolveStation(args.StartPoint)
+	if err != nil {
+		return fmt.Errorf("start point: %w", err)
 	}
-	fmt.Println(startPoint)
 
 	fmt.Println("Acquiring end-point NapTan code..")
-	endPoint, okEnd := internal.GetNaptan(args.EndPoint)
-	if !okEnd {
-		return fmt.Errorf("end point '%s' not found in station mapping", args.EndPoint)
+	end, endCandidates, err := resolveStation(args.EndPoint)
+	if err != nil {
+		return fmt.Errorf("end point: %w", err)
+	}
+
+	if len(startCandidates) > 0 || len(endCandidates) > 0 {
+		log.Printf("Ambiguous stations for user %s, returning candidates.", args.UserID)
+		reply.From = args.StartPoint
+		reply.To = args.EndPoint
+		reply.StartCandidates = startCandidates
+		reply.EndCandidates = endCandidates
+		return nil
+	}
+	fmt.Println(start.Naptan, end.Naptan)
+
+	waypoints, err := resolveWaypoints(args.Waypoints)
+	if err != nil {
+		return err
+	}
+
+	scorer, err := internal.DefaultScorer.WithOverrides(args.Weights)
+	if err != nil {
+		return fmt.Errorf("invalid scoring weights: %w", err)
+	}
+
+	prefs := common.UserPreferences{UserID: args.UserID, Avoid: args.Avoid, Access: args.Access}
+	profile, err := internal.NewPlanningProfile(prefs, internal.Stations, internal.Lines, internal.Accessibility)
+	if err != nil {
+		return err
 	}
-	fmt.Println(endPoint)
 
 	fmt.Println("Fetching available routes..")
-	bestJourney, bestScore, err := findBestJourney(startPoint, endPoint, args.Departure)
+	query := tfl.JourneyQuery{From: start.Naptan, To: end.Naptan, Time: args.Departure}
+	if !args.ArriveBy.IsZero() {
+		query.Time = args.ArriveBy
+		query.TimeIs = tfl.Arriving
+		fmt.Printf("Planning to arrive by %s\n", args.ArriveBy.Format("15:04"))
+	}
+	ranked, rejected, err := planJourneys(scorer, args.UserID, query, waypoints, profile)
+	reply.Rejected = rejected
+	if errors.Is(err, errAllRejected) {
+		log.Printf("All %d routes for user %s conflict with their preferences.", len(rejected), args.UserID)
+		reply.From = start.Name
+		reply.To = end.Name
+		reply.Summary = "No route meets all of your avoidance and accessibility preferences."
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not rank journeys: %s", err)
+	}
+
+	for i, candidate := range ranked {
+		alternative := internal.ConvertToRouteAlternative(i+1, candidate)
+		annotateLineStatus(alternative.Legs)
+		reply.Alternatives = append(reply.Alternatives, alternative)
+	}
+	internal.PendingRoutes.Put(args.UserID, ranked)
+
+	reply.From = start.Name
+	reply.To = end.Name
+	reply.Score = ranked[0].Score
+	reply.Fare = ranked[0].Fare.Pounds()
+	reply.Breakdown = ranked[0].Breakdown
+	reply.Summary = buildSummary(&ranked[0].Journey)
+
+	log.Printf("Offered %d alternatives to user %s, awaiting confirmation.", len(ranked), args.UserID)
+	return nil
+}
+
+func (r *RoutePlanner) SearchStations(args *common.StationQuery, reply *[]common.StationMatch) error {
+	limit := args.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	*reply = internal.Stations.Search(args.Query, limit)
+	return nil
+}
+
+// resolveStation returns the matching station, or the candidates when the name is ambiguous.
+func resolveStation(name string) (*internal.Station, []common.StationMatch, error) {
+	station, err := internal.Stations.Resolve(name)
+	if err != nil {
+		var ambiguous *internal.AmbiguousStationError
+		if errors.As(err, &ambiguous) {
+			return nil, ambiguous.Candidates, nil
+		}
+		return nil, nil, err
+	}
+	return station, nil, nil
+}
+
+// resolveUniqueStation is resolveStation for requests that cannot offer a choice: an ambiguous
+// name is an error naming the candidates.
+func resolveUniqueStation(name string) (*internal.Station, error) {
+	station, candidates, err := resolveStation(name)
 	if err != nil {
-		return fmt.Errorf("could not find best journey: %s", err)
+		return nil, err
+	}
+	if len(candidates) > 0 {
+		var names []string
+		for _, c := range candidates {
+			names = append(names, c.Name)
+		}
+		return nil, fmt.Errorf("'%s' matches several stations: %s", name, strings.Join(names, ", "))
+	}
+	return station, nil
+}
+
+// resolveWaypoints resolves the stations of a multi-stop request. Unlike the start and end
+// points, an ambiguous waypoint is an error naming the candidates.
+func resolveWaypoints(waypoints []common.Waypoint) ([]common.RouteWaypoint, error) {
+	resolved := make([]common.RouteWaypoint, 0, len(waypoints))
+	for i, waypoint := range waypoints {
+		if waypoint.DwellMins < 0 {
+			return nil, fmt.Errorf("waypoint %d: dwell time must not be negative", i+1)
+		}
+		station, err := resolveUniqueStation(waypoint.Station)
+		if err != nil {
+			return nil, fmt.Errorf("waypoint %d: %w", i+1, err)
+		}
+		resolved = append(resolved, common.RouteWaypoint{Name: station.Name, Naptan: station.Naptan, DwellMins: waypoint.DwellMins})
 	}
+	return resolved, nil
+}
+
+func (r *RoutePlanner) ScheduleJourney(args *common.UserRequest, reply *common.ScheduledJourney) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	reply.From = args.StartPoint
-	reply.To = args.EndPoint
-	reply.Score = bestScore
-	reply.Summary = buildSummary(bestJourney)
+	now := time.Now()
+	journey := common.ScheduledJourney{
+		ScheduleID: internal.NewScheduleID(),
+		UserID:     args.UserID,
+		Request:    *args,
+		Status:     common.ScheduleWaiting,
+		CreatedAt:  now,
+	}
+	if !internal.BookedTime(journey).After(now) {
+		return fmt.Errorf("a scheduled journey must depart or arrive in the future")
+	}
+	fmt.Printf("User '%s' scheduled a journey from '%s' to '%s' at %s\n", args.UserID, args.StartPoint, args.EndPoint, internal.BookedTime(journey).Format(time.RFC3339))
+
+	start, err := resolveUniqueStation(args.StartPoint)
+	if err != nil {
+		return fmt.Errorf("start point: %w", err)
+	}
+	end, err := resolveUniqueStation(args.EndPoint)
+	if err != nil {
+		return fmt.Errorf("end point: %w", err)
+	}
+	waypoints, err := resolveWaypoints(args.Waypoints)
+	if err != nil {
+		return err
+	}
+
+	// Keep the resolved stations so that re-planning does not depend on name matching.
+	journey.From, journey.To = start.Name, end.Name
+	journey.Request.StartPoint, journey.Request.EndPoint = start.Naptan, end.Naptan
+	journey.Request.Waypoints = make([]common.Waypoint, len(waypoints))
+	for i, waypoint := range waypoints {
+		journey.Request.Waypoints[i] = common.Waypoint{Station: waypoint.Naptan, DwellMins: waypoint.DwellMins}
+	}
+
+	best, err := planScheduled(journey)
+	if err != nil {
+		return err
+	}
+	applySchedulePlan(&journey, best, now)
+
+	if err = storeScheduled(ctx, journey); err != nil {
+		return err
+	}
+	log.Printf("Scheduled journey %s for user %s, leaving at %s.", journey.ScheduleID, journey.UserID, journey.LeaveAt.Format(time.RFC3339))
+
+	*reply = journey
+	return nil
+}
+
+func (r *RoutePlanner) GetScheduledJourneys(args *common.NewRequest, reply *[]common.ScheduledJourney) error {
+	*reply = internal.Schedules.ForUser(args.UserID)
+	return nil
+}
+
+func (r *RoutePlanner) CancelScheduledJourney(args *common.ScheduleLookup, reply *common.SavedResp) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	journey, err := internal.Schedules.Get(args.UserID, args.ScheduleID)
+	if err != nil {
+		return err
+	}
+	if err = forgetScheduled(ctx, journey); err != nil {
+		return err
+	}
+	log.Printf("User %s cancelled scheduled journey %s.", args.UserID, args.ScheduleID)
+
+	reply.UserID = args.UserID
+	reply.Status = common.StatusDone
+	return nil
+}
+
+// planScheduled plans a scheduled journey with the conditions known now.
+func planScheduled(journey common.ScheduledJourney) (internal.RankedJourney, error) {
+	req := journey.Request
+	scorer, err := internal.DefaultScorer.WithOverrides(req.Weights)
+	if err != nil {
+		return internal.RankedJourney{}, fmt.Errorf("invalid scoring weights: %w", err)
+	}
+	profile, err := internal.NewPlanningProfile(common.UserPreferences{UserID: req.UserID, Avoid: req.Avoid, Access: req.Access}, internal.Stations, internal.Lines, internal.Accessibility)
+	if err != nil {
+		return internal.RankedJourney{}, err
+	}
+	waypoints, err := resolveWaypoints(req.Waypoints)
+	if err != nil {
+		return internal.RankedJourney{}, err
+	}
+
+	query := tfl.JourneyQuery{From: req.StartPoint, To: req.EndPoint, Time: req.Departure}
+	if !req.ArriveBy.IsZero() {
+		query.Time = req.ArriveBy
+		query.TimeIs = tfl.Arriving
+	}
+	ranked, _, err := planJourneys(scorer, req.UserID, query, waypoints, profile)
+	if err != nil {
+		return internal.RankedJourney{}, fmt.Errorf("could not plan scheduled journey %s: %w", journey.ScheduleID, err)
+	}
+	return ranked[0], nil
+}
+
+// applySchedulePlan makes a planned journey the latest recommendation of a scheduled journey.
+func applySchedulePlan(journey *common.ScheduledJourney, best internal.RankedJourney, now time.Time) {
+	alternative := internal.ConvertToRouteAlternative(1, best)
+	annotateLineStatus(alternative.Legs)
+	journey.Plan = &alternative
+	journey.PlannedAt = now
+	if start, arrival, ok := internal.JourneyWindow(best.Journey); ok {
+		journey.LeaveAt, journey.ArriveAt = start, arrival
+	}
+}
+
+func storeScheduled(ctx context.Context, journey common.ScheduledJourney) error {
+	if err := internal.SaveScheduledJourney(ctx, journey); err != nil {
+		return err
+	}
+	internal.Schedules.Put(journey)
+	return nil
+}
+
+func forgetScheduled(ctx context.Context, journey common.ScheduledJourney) error {
+	if err := internal.DeleteScheduledJourney(ctx, journey.ScheduleID); err != nil {
+		return err
+	}
+	internal.Schedules.Delete(journey.ScheduleID)
+	return nil
+}
+
+// watchScheduledJourneys re-plans scheduled journeys before departure, pushes the final
+// recommendation and tells riders when to leave earlier or later.
+func watchScheduledJourneys(ctx context.Context) {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		for _, journey := range internal.Schedules.All() {
+			switch internal.Scheduling.Next(journey, now) {
+			case internal.ScheduleExpire:
+				if err := forgetScheduled(ctx, journey); err != nil {
+					log.Printf("Failed to remove departed journey %s: %v", journey.ScheduleID, err)
+				}
+			case internal.ScheduleFinalise:
+				finaliseScheduled(ctx, journey, now)
+			case internal.ScheduleRecheck:
+				recheckScheduled(ctx, journey, now)
+			}
+		}
+	}
+}
+
+func leaveMessage(journey common.ScheduledJourney) string {
+	return fmt.Sprintf("leave at %s to arrive at %s.\n%s",
+		journey.LeaveAt.In(tfl.London).Format("15:04"), journey.ArriveAt.In(tfl.London).Format("15:04"), journey.Plan.Summary)
+}
+
+// finaliseScheduled re-plans a scheduled journey with live conditions and pushes the result.
+// If planning fails the booked recommendation is pushed instead.
+func finaliseScheduled(ctx context.Context, journey common.ScheduledJourney, now time.Time) {
+	booked := journey.LeaveAt
+	msg := fmt.Sprintf("🗓 Your journey to %s: ", journey.To)
+	if best, err := planScheduled(journey); err != nil {
+		log.Printf("Pushing the booked plan of %s: %v", journey.ScheduleID, err)
+		journey.PlannedAt = now
+		msg += leaveMessage(journey) + "\nLive conditions could not be checked."
+	} else {
+		applySchedulePlan(&journey, best, now)
+		msg += leaveMessage(journey)
+		if shift, ok := internal.Scheduling.LeaveShift(booked, journey.LeaveAt); ok {
+			msg += "\n⏰ Conditions changed since you booked: " + shift + "."
+		}
+	}
+	journey.Status = common.ScheduleMonitoring
+
+	if err := storeScheduled(ctx, journey); err != nil {
+		log.Printf("Failed to save scheduled journey %s: %v", journey.ScheduleID, err)
+	}
+	internal.NotifyUser(journey.UserID, msg)
+}
+
+// recheckScheduled re-plans a monitored journey and alerts the rider if the time to leave moved.
+func recheckScheduled(ctx context.Context, journey common.ScheduledJourney, now time.Time) {
+	previous := journey.LeaveAt
+	best, err := planScheduled(journey)
+	if err != nil {
+		log.Printf("Could not re-check scheduled journey %s: %v", journey.ScheduleID, err)
+		journey.PlannedAt = now
+		internal.Schedules.Put(journey)
+		return
+	}
+	applySchedulePlan(&journey, best, now)
+
+	if err = storeScheduled(ctx, journey); err != nil {
+		log.Printf("Failed to save scheduled journey %s: %v", journey.ScheduleID, err)
+	}
+	if shift, ok := internal.Scheduling.LeaveShift(previous, journey.LeaveAt); ok {
+		log.Printf("Leaving time of %s moved from %s to %s.", journey.ScheduleID, previous.Format(time.RFC3339), journey.LeaveAt.Format(time.RFC3339))
+		internal.NotifyUser(journey.UserID, fmt.Sprintf("⏰ %s: conditions changed on your journey to %s.\n%s", shift, journey.To, journey.Plan.Summary))
+	}
+}
+
+func (r *RoutePlanner) ConfirmRoute(args *common.RouteSelection, reply *common.RouteResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fmt.Printf("User '%s' confirmed alternative %d\n", args.UserID, args.Rank)
+	selected, err := internal.PendingRoutes.Take(args.UserID, args.Rank)
+	if err != nil {
+		return err
+	}
+
+	journey := &selected.Journey
+	chosen := selected.ChosenRoute(args.UserID)
+	internal.StartJourney(&chosen, time.Now())
+	if err = internal.SaveChosenRoute(ctx, chosen); err != nil {
+		return fmt.Errorf("failed to save chosen route: %w", err)
+	}
+	internal.CheckIns.Forget(args.UserID)
+	fare, err := internal.Fares.Record(ctx, args.UserID, *journey)
+	if err != nil {
+		log.Printf("Daily cap may be lost on restart: %v", err)
+	}
 
-	if okNotif := notifyNewRoute(args.UserID, bestJourney); okNotif != nil {
+	if err = notifyNewRoute(args.UserID, journey); err != nil {
 		return fmt.Errorf("failed to publish new active route: %w", err)
 	}
 	log.Println("New active route notification published successfully.")
 
-	chosen := internal.ConvertToChosenRoute(args.UserID, *bestJourney)
-	if err = internal.SaveChosenRoute(ctx, chosen); err != nil {
-		log.Printf("Error saving chosen route: %v", err)
+	reply.From = chosen.Legs[0].From
+	reply.To = chosen.Legs[len(chosen.Legs)-1].To
+	reply.Score = selected.Score
+	reply.Fare = fare.Pounds()
+	reply.Breakdown = selected.Breakdown
+	reply.Summary = buildSummary(journey)
+
+	return nil
+}
+
+func (r *RoutePlanner) CheckIn(args *common.CheckIn, reply *common.JourneyPosition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fmt.Printf("User '%s' checked in at '%s'\n", args.UserID, args.Station)
+	route, err := internal.GetActiveRoute(ctx, args.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get active route for user %s: %w", args.UserID, err)
+	}
+
+	station, err := internal.Stations.Resolve(internal.NormalizeStopID(args.Station))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	record, err := internal.MatchCheckIn(*route, station.Naptan, now)
+	if err != nil {
+		return err
 	}
+	internal.CheckIns.Put(args.UserID, record)
+
+	position, err := internal.EstimatePosition(*route, now, &record)
+	if err != nil {
+		return err
+	}
+	*reply = position
+
 	return nil
 }
 
@@ -94,28 +475,236 @@ func (r *RoutePlanner) GetCurrentRoute(args *common.NewRequest, reply *common.Ch
 	return nil
 }
 
-func findBestJourney(startNaptan, endNaptan string, departure time.Time) (*common.TFLJourney, float64, error) {
-	journeys, err := internal.FetchRoutes(startNaptan, endNaptan, departure)
+// errAllRejected is returned by rankJourneys when every journey conflicts with the planning profile.
+var errAllRejected = errors.New("every journey found conflicts with the user's preferences")
+
+// planJourneys ranks the journeys of a query, or plans a multi-stop trip through waypoints.
+func planJourneys(scorer *internal.Scorer, userID string, query tfl.JourneyQuery, waypoints []common.RouteWaypoint, profile *internal.PlanningProfile) ([]internal.RankedJourney, []common.RejectedAlternative, error) {
+	if len(waypoints) == 0 {
+		return rankJourneys(scorer, userID, query, profile)
+	}
+	trip, rejected, err := planTrip(scorer, userID, query, waypoints, profile)
+	if err != nil {
+		return nil, rejected, err
+	}
+	return []internal.RankedJourney{trip}, rejected, nil
+}
+
+// planTrip plans each segment of a multi-stop trip with the usual ranking and stitches the best
+// journey of each into one. Departing trips are planned forwards, leaving each waypoint after its
+// dwell time; arrive-by trips are planned backwards from the destination.
+func planTrip(scorer *internal.Scorer, userID string, query tfl.JourneyQuery, waypoints []common.RouteWaypoint, profile *internal.PlanningProfile) (internal.RankedJourney, []common.RejectedAlternative, error) {
+	stops := []string{query.From}
+	for _, waypoint := range waypoints {
+		stops = append(stops, waypoint.Naptan)
+	}
+	stops = append(stops, query.To)
+	dwell := func(waypoint int) time.Duration {
+		return time.Duration(waypoints[waypoint].DwellMins) * time.Minute
+	}
+
+	parts := make([]internal.RankedJourney, len(stops)-1)
+	var rejected []common.RejectedAlternative
+	plan := func(segment int, at time.Time) error {
+		from, to := stops[segment], stops[segment+1]
+		if internal.NormalizeStopID(from) == internal.NormalizeStopID(to) {
+			// Already there, e.g. a re-plan from the waypoint itself.
+			return nil
+		}
+		ranked, segmentRejected, err := rankJourneys(scorer, userID, tfl.JourneyQuery{From: from, To: to, Time: at, TimeIs: query.TimeIs}, profile)
+		rejected = append(rejected, segmentRejected...)
+		if err != nil {
+			return fmt.Errorf("segment %d of the trip: %w", segment+1, err)
+		}
+		parts[segment] = ranked[0]
+		return nil
+	}
+
+	at := query.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	if query.TimeIs == tfl.Arriving {
+		for i := len(parts) - 1; i >= 0; i-- {
+			if i < len(parts)-1 {
+				at = at.Add(-dwell(i))
+			}
+			if err := plan(i, at); err != nil {
+				return internal.RankedJourney{}, rejected, err
+			}
+			if start, _, ok := internal.JourneyWindow(parts[i].Journey); ok {
+				at = start
+			}
+		}
+	} else {
+		for i := range parts {
+			if i > 0 {
+				at = at.Add(dwell(i - 1))
+			}
+			if err := plan(i, at); err != nil {
+				return internal.RankedJourney{}, rejected, err
+			}
+			if _, arrival, ok := internal.JourneyWindow(parts[i].Journey); ok {
+				at = arrival
+			}
+		}
+	}
+
+	return internal.StitchSegments(parts, waypoints), rejected, nil
+}
+
+// rankJourneys scores every journey TfL offers that the planning profile allows and returns them
+// best first, together with the journeys it rejected and why.
+func rankJourneys(scorer *internal.Scorer, userID string, query tfl.JourneyQuery, profile *internal.PlanningProfile) ([]internal.RankedJourney, []common.RejectedAlternative, error) {
+	profile.Apply(&query)
+	journeys, err := internal.TfL.Journey(query)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to fetch routes: %w", err)
+		if internal.Fallback == nil {
+			return nil, nil, fmt.Errorf("failed to fetch routes: %w", err)
+		}
+		log.Printf("TfL Journey API unavailable (%v), using offline router", err)
+		journeys, err = internal.Fallback.Journeys(query, fallbackAlternatives)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to compute offline routes: %w", err)
+		}
+	}
+
+	var allowed []common.TFLJourney
+	var rejected []common.RejectedAlternative
+	for _, journey := range journeys.Journeys {
+		if reasons := profile.Violations(journey); len(reasons) > 0 {
+			rejected = append(rejected, common.RejectedAlternative{
+				Duration: journey.Duration,
+				Lines:    internal.JourneyLines(journey),
+				Summary:  buildSummary(&journey),
+				Reasons:  reasons,
+			})
+			continue
+		}
+		allowed = append(allowed, journey)
+	}
+
+	ranked := scoreJourneys(scorer, userID, allowed, query, profile.Accessibility())
+	if len(ranked) == 0 {
+		if len(rejected) > 0 {
+			return nil, rejected, errAllRejected
+		}
+		return nil, nil, fmt.Errorf("no valid journeys found")
 	}
 
-	var bestScore = math.MaxFloat64
-	var bestJourney *common.TFLJourney
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score < ranked[j].Score
+	})
 
-	for _, route := range journeys.Journeys {
-		currentScore := handleCrowding(route, departure.Format("20060102"))
-		if currentScore < bestScore {
-			bestScore = currentScore
-			bestJourney = &route
+	return ranked, rejected, nil
+}
+
+// scoreJourneys looks up crowding for every stop the journeys pass and scores them, in order.
+func scoreJourneys(scorer *internal.Scorer, userID string, journeys []common.TFLJourney, query tfl.JourneyQuery, access *internal.AccessibilityPolicy) []internal.RankedJourney {
+	passes := make([][]internal.StopPass, len(journeys))
+	var keys []internal.CrowdingKey
+	for i, route := range journeys {
+		for _, leg := range route.Legs {
+			legPasses, err := internal.StopPasses(leg)
+			if err != nil {
+				log.Printf("Skipping crowding for leg: %v", err)
+				continue
+			}
+			for _, pass := range legPasses {
+				keys = append(keys, internal.CrowdingKey{Naptan: pass.Naptan, Weekday: findDayOfWeek(pass.At.Format("20060102"))})
+			}
+			passes[i] = append(passes[i], legPasses...)
+		}
+	}
+	profiles := internal.Crowding.GetMany(keys)
+	stats := internal.Crowding.Stats()
+	log.Printf("Crowding cache: %d hits, %d shared, %d misses, %d errors (hit rate %.0f%%)", stats.Hits, stats.Shared, stats.Misses, stats.Errors, stats.HitRate*100)
+
+	ranked := make([]internal.RankedJourney, 0, len(journeys))
+	for i, route := range journeys {
+		facts := internal.JourneyFacts{
+			Journey:     route,
+			AvgCrowding: handleCrowding(passes[i], profiles),
+			Departure:   query.Time,
+			Access:      access,
+			Fare:        internal.Fares.Quote(userID, route),
+		}
+		if start, _, ok := internal.JourneyWindow(route); ok {
+			facts.Departure = start
 		}
+		if query.TimeIs == tfl.Arriving {
+			facts.ArriveBy = query.Time
+		}
+
+		score, breakdown := scorer.Score(facts)
+		ranked = append(ranked, internal.RankedJourney{
+			Journey:   route,
+			Score:     score,
+			Breakdown: breakdown,
+			Fare:      facts.Fare,
+		})
+	}
+
+	return ranked
+}
+
+// replan compares staying on the rider's current route with the best route from where they
+// are now, and returns the journey they should follow together with the reasoning.
+func replan(route *common.ChosenRoute) (*internal.RankedJourney, internal.RecalculationDecision, error) {
+	position, err := currentPosition(route)
+	if err != nil {
+		return nil, internal.RecalculationDecision{}, err
+	}
+
+	prefs, err := internal.Preferences.Get(route.UserID)
+	if err != nil {
+		log.Printf("Planning with the preferences known so far: %v", err)
+	}
+	profile, err := internal.NewPlanningProfile(prefs, internal.Stations, internal.Lines, internal.Accessibility)
+	if err != nil {
+		log.Printf("Ignoring preferences of %s: %v", route.UserID, err)
 	}
 
-	if bestJourney == nil {
-		return nil, 0, fmt.Errorf("no valid journeys found")
+	now := time.Now()
+	lastLeg := route.Legs[len(route.Legs)-1]
+	segments, waypoints := internal.RemainingSegments(*route, position.LegIndex)
+	query := tfl.JourneyQuery{From: internal.NormalizeStopID(position.StopID), To: lastLeg.ToID, Time: internal.ResumeTime(*route, position, now)}
+
+	var current *internal.RankedJourney
+	if remaining, errRemaining := internal.RemainingJourney(*route, position, now); errRemaining == nil {
+		parts := internal.SplitSegments(remaining, segments)
+		stitched := internal.StitchSegments(scoreJourneys(internal.DefaultScorer, route.UserID, parts, query, profile.Accessibility()), waypoints)
+		current = &stitched
+	} else {
+		log.Printf("Could not score the current route of %s: %v", route.UserID, errRemaining)
 	}
 
-	return bestJourney, bestScore, nil
+	ranked, _, err := planJourneys(internal.DefaultScorer, route.UserID, query, waypoints, profile)
+	if errors.Is(err, errAllRejected) && current != nil {
+		return current, internal.RecalculationDecision{
+			CurrentScore: current.Score,
+			Reason:       "Stay on your route: every alternative conflicts with your avoidance or accessibility preferences.",
+		}, nil
+	}
+	if err != nil {
+		return nil, internal.RecalculationDecision{}, fmt.Errorf("failed to find best recalculation journey: %w", err)
+	}
+	best := &ranked[0]
+
+	if current == nil {
+		return best, internal.RecalculationDecision{
+			Switch:    true,
+			BestScore: best.Score,
+			Reason:    "Switching route: your current route could not be evaluated.",
+		}, nil
+	}
+
+	decision := internal.Recalculation.Decide(*current, *best)
+	log.Printf("Recalculation for %s: %s", route.UserID, decision.Reason)
+	if !decision.Switch {
+		return current, decision, nil
+	}
+	return best, decision, nil
 }
 
 func (r *RoutePlanner) RecalculateRoute(args *common.NewRequest, reply *common.RouteResult) error {
@@ -123,51 +712,64 @@ func (r *RoutePlanner) RecalculateRoute(args *common.NewRequest, reply *common.R
 	defer cancel()
 
 	fmt.Printf("Request received from '%s' to '%s'\n", args.UserID, args.Reason)
+	for _, line := range args.Lines {
+		internal.Disruptions.Report(line, internal.SuddenDisruption)
+	}
 	chosenRoute, err := sharedRecalculationLogic(ctx, args.UserID)
 	if err != nil {
 		return err
 	}
 
-	currentStop, err1 := internal.EstimateCurrentStop(*chosenRoute)
-	if err1 != nil {
-		return err1
+	selected, decision, err := replan(chosenRoute)
+	if err != nil {
+		return err
 	}
 
-	currentStopNaptan, ok := internal.GetNaptan(currentStop)
-	if !ok {
-		return fmt.Errorf("could not find Naptan for current stop point")
+	reply.From = chosenRoute.Legs[0].From
+	reply.To = chosenRoute.Legs[len(chosenRoute.Legs)-1].To
+	reply.Score = selected.Score
+	reply.Fare = selected.Fare.Pounds()
+	reply.Breakdown = selected.Breakdown
+	reply.Summary = buildSummary(&selected.Journey)
+	reply.Reason = decision.Reason
+
+	if !decision.Switch {
+		reply.Kept = true
+		return nil
 	}
 
-	lastLeg := chosenRoute.Legs[len(chosenRoute.Legs)-1]
-	endPoint := lastLeg.ToID
-	bestJourney, bestScore, err2 := findBestJourney(currentStopNaptan, endPoint, time.Now())
-	if err2 != nil {
-		return fmt.Errorf("failed to find best recalculation journey: %w", err2)
+	next := selected.ChosenRoute(args.UserID)
+	internal.ContinueJourney(*chosenRoute, &next, decision.Reason, args.Lines, time.Now())
+	if err = internal.SaveChosenRoute(ctx, next); err != nil {
+		return fmt.Errorf("failed to save recalculated route: %w", err)
 	}
+	internal.CheckIns.Forget(args.UserID)
 
-	if okNotif := notifyNewRoute(args.UserID, bestJourney); okNotif != nil {
+	if err = notifyNewRoute(args.UserID, &selected.Journey); err != nil {
 		return fmt.Errorf("failed to publish new active route: %w", err)
 	}
 	log.Println("New active route notification published successfully.")
 
-	if err = internal.SaveChosenRoute(ctx, *chosenRoute); err != nil {
-		log.Printf("Error saving chosen route: %v", err)
-	}
-
-	reply.From = chosenRoute.Legs[0].From
-	reply.To = lastLeg.To
-	reply.Score = bestScore
-	reply.Summary = buildSummary(bestJourney)
-
 	return nil
 }
 
+// currentPosition estimates where the rider is on their route, using their latest check-in.
+func currentPosition(route *common.ChosenRoute) (common.JourneyPosition, error) {
+	position, err := internal.EstimatePosition(*route, time.Now(), internal.CheckIns.Latest(route.UserID))
+	if err != nil {
+		return position, err
+	}
+	log.Printf("Estimated position of %s: leg %d, stop %d (%s, %s), confidence %.2f",
+		route.UserID, position.LegIndex, position.StopIndex, position.StopName, position.Segment, position.Confidence)
+	return position, nil
+}
+
 func sharedRecalculationLogic(ctx context.Context, userID string) (*common.ChosenRoute, error) {
 	chosenRoute, err := internal.GetActiveRoute(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get active route for user %s: %w", userID, err)
 	}
-	/* activeRoute := internal.ConvertToActiveRoute(userID, chosenRoute)
+	/* activeRoute, _ := internal.ConvertToActiveRoute(userID, chosenRoute)
 	data, _ := json.Marshal(activeRoute)
 	if err = internal.RoutePublisher.Publish(routeTerminated, data, amqp.Table{"Event-Type": "Route Aborted"}); err != nil {
 		return nil, fmt.Errorf("no active route found for user %s", userID)
@@ -192,10 +794,19 @@ func (r *RoutePlanner) TerminateRoute(args *common.NewRequest, reply *common.Sav
 	}
 	log.Println("Terminated route notification published successfully.")
 
+	record := internal.ArchiveJourney(*activeRoute, args.Reason, internal.CheckIns.Latest(args.UserID), time.Now())
+	data, _ = json.Marshal(record)
+	if err := internal.RoutePublisher.Publish(journeyArchived, data, amqp.Table{"Event-Type": "Journey Archived"}); err != nil {
+		log.Printf("Failed to archive journey %s of user %s: %v", record.JourneyID, args.UserID, err)
+	} else {
+		log.Printf("Archived %s journey %s of user %s.", record.Outcome, record.JourneyID, args.UserID)
+	}
+
 	err = internal.DeleteChosenRoute(ctx, args.UserID)
 	if err != nil {
 		return fmt.Errorf("failed to delete chosen route: %w", err)
 	}
+	internal.CheckIns.Forget(args.UserID)
 
 	reply.UserID = args.UserID
 	reply.Status = common.StatusDone
@@ -203,20 +814,72 @@ func (r *RoutePlanner) TerminateRoute(args *common.NewRequest, reply *common.Sav
 	return nil
 }
 
-func (r *RoutePlanner) AcceptSavedRouteRequest(args *common.UserSavedRoute, reply *common.SavedResp) error {
+// AcceptSavedRouteRequest starts a saved route, after checking it against today's conditions.
+// If its lines are severely disrupted or TfL offers a clearly better route, nothing is started:
+// the saved route is offered together with today's alternatives, to be confirmed like a new route.
+// Disrupted lines stop the route even when today's routes cannot be planned.
+func (r *RoutePlanner) AcceptSavedRouteRequest(args *common.UserSavedRoute, reply *common.RouteResult) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	fmt.Printf("Requested saved route from '%s' to '%s'\n", args.StartPoint, args.EndPoint)
-	fmt.Println("Registering route...")
 
+	now := time.Now()
 	savedRoute := internal.ConvertUserSavedToChosenRoute(args)
+	if len(savedRoute.Legs) == 0 {
+		return fmt.Errorf("saved route %s has no legs", args.RouteID)
+	}
+	// Lines may have left the registry since the route was saved: they are reported but do not
+	// stop the route, as for a route planned today.
+	lineIDs, err := internal.RouteLineIDs(savedRoute)
+	if err != nil {
+		log.Printf("Validation error in saved route %s of user %s, its unknown lines will not be alerted on: %v", args.RouteID, args.UserID, err)
+	}
+	if err := internal.RetimeRoute(&savedRoute, now); err != nil {
+		log.Printf("Saved route %s of user %s keeps its old timetable: %v", args.RouteID, args.UserID, err)
+	}
+
+	reply.From = args.StartPoint
+	reply.To = args.EndPoint
+	reply.Summary = savedRoute.Description
+
+	fmt.Println("Checking saved route against current conditions...")
+	disrupted := disruptedLines(savedRoute)
+	saved, today, err := revalidateSavedRoute(savedRoute, args, now)
+	if err != nil {
+		log.Printf("Could not re-validate saved route %s of user %s: %v", args.RouteID, args.UserID, err)
+	}
+	// Disrupted lines are known without TfL's journey planner, so they stop the route even when
+	// today's routes could not be planned.
+	if (err == nil || len(disrupted) > 0) && offerTodaysRoutes(args.UserID, saved, today, disrupted, reply) {
+		log.Printf("Saved route %s of user %s is worse today, offered %d alternatives.", args.RouteID, args.UserID, len(reply.Alternatives))
+		return nil
+	}
+
+	fmt.Println("Registering route...")
+	internal.StartJourney(&savedRoute, now)
 	if err := internal.SaveChosenRoute(ctx, savedRoute); err != nil {
 		log.Printf("Error saving chosen route: %v", err)
 	}
+	internal.CheckIns.Forget(args.UserID)
+
+	// The fare counts towards the daily cap like that of a confirmed route.
+	var journey *common.TFLJourney
+	if saved != nil {
+		journey = &saved.Journey
+	} else if remaining, errJourney := internal.RemainingJourney(savedRoute, common.JourneyPosition{}, now); errJourney == nil {
+		journey = &remaining
+	}
+	if journey != nil {
+		fare, errFare := internal.Fares.Record(ctx, args.UserID, *journey)
+		if errFare != nil {
+			log.Printf("Daily cap may be lost on restart: %v", errFare)
+		}
+		reply.Fare = fare.Pounds()
+	}
 
 	var activeRoute = common.ActiveRoute{
 		UserID:  args.UserID,
-		LineIDs: args.LineNames,
+		LineIDs: lineIDs,
 	}
 
 	data, _ := json.Marshal(activeRoute)
@@ -225,25 +888,140 @@ func (r *RoutePlanner) AcceptSavedRouteRequest(args *common.UserSavedRoute, repl
 	}
 
 	log.Println("Saved active route notification published successfully.")
-	reply.UserID = activeRoute.UserID
-	reply.Status = common.StatusDone
+	if saved != nil {
+		reply.Score = saved.Score
+		reply.Breakdown = saved.Breakdown
+	}
 
 	return nil
 }
 
-func notifyNewRoute(user string, journey *common.TFLJourney) error {
+// disruptedLines returns the lines of a route that are severely disrupted now, with their status.
+func disruptedLines(route common.ChosenRoute) []string {
+	legs := append([]common.RouteLeg(nil), route.Legs...)
+	annotateLineStatus(legs)
+	conditions := internal.WorstOf{internal.Disruptions, internal.LineStatus}
 
 	var lines []string
-	var newRoute common.ActiveRoute
+	seen := make(map[string]bool)
+	for _, leg := range legs {
+		if leg.LineID == "" || seen[leg.LineID] {
+			continue
+		}
+		seen[leg.LineID] = true
+		if conditions.Disruption(leg.LineID) >= severeDisruption {
+			lines = append(lines, fmt.Sprintf("%s (%s)", leg.LineName, leg.Status))
+		}
+	}
+	return lines
+}
 
-	for _, leg := range journey.Legs {
-		for _, option := range leg.RouteOptions {
-			lines = append(lines, option.LineIdentifier.ID)
+// revalidateSavedRoute scores a saved route, re-timed to now, and plans the same trip with TfL.
+// The saved route is nil if it has no timetable to be scored by.
+func revalidateSavedRoute(route common.ChosenRoute, args *common.UserSavedRoute, now time.Time) (*internal.RankedJourney, []internal.RankedJourney, error) {
+	prefs, err := internal.Preferences.Get(route.UserID)
+	if err != nil {
+		log.Printf("Planning with the preferences known so far: %v", err)
+	}
+	profile, err := internal.NewPlanningProfile(prefs, internal.Stations, internal.Lines, internal.Accessibility)
+	if err != nil {
+		log.Printf("Ignoring preferences of %s: %v", route.UserID, err)
+	}
+
+	query := tfl.JourneyQuery{From: route.Legs[0].FromID, To: route.Legs[len(route.Legs)-1].ToID, Time: now}
+	if query.From == "" || query.To == "" {
+		// Saved before the legs were stored: only the names of the end points are known.
+		start, errStart := resolveUniqueStation(args.StartPoint)
+		if errStart != nil {
+			return nil, nil, fmt.Errorf("start point: %w", errStart)
+		}
+		end, errEnd := resolveUniqueStation(args.EndPoint)
+		if errEnd != nil {
+			return nil, nil, fmt.Errorf("end point: %w", errEnd)
+		}
+		query.From, query.To = start.Naptan, end.Naptan
+	}
+	segments, waypoints := internal.RemainingSegments(route, 0)
+
+	var saved *internal.RankedJourney
+	if journey, errJourney := internal.RemainingJourney(route, common.JourneyPosition{}, now); errJourney == nil {
+		parts := internal.SplitSegments(journey, segments)
+		stitched := internal.StitchSegments(scoreJourneys(internal.DefaultScorer, route.UserID, parts, query, profile.Accessibility()), waypoints)
+		saved = &stitched
+	} else {
+		log.Printf("Could not score the saved route of %s: %v", route.UserID, errJourney)
+	}
+
+	ranked, _, err := planJourneys(internal.DefaultScorer, route.UserID, query, waypoints, profile)
+	if err != nil {
+		return saved, nil, fmt.Errorf("failed to plan today's routes: %w", err)
+	}
+	return saved, ranked, nil
+}
+
+// offerTodaysRoutes decides whether a saved route is worse than usual today. If it is, the saved
+// route and today's alternatives are ranked together into the reply and kept for confirmation.
+// today is empty when no route could be planned: the saved route is then offered alone, if it
+// could be scored, and otherwise the rider is only warned.
+func offerTodaysRoutes(userID string, saved *internal.RankedJourney, today []internal.RankedJourney, disrupted []string, reply *common.RouteResult) bool {
+	var reasons []string
+	if len(disrupted) > 0 {
+		reasons = append(reasons, fmt.Sprintf("Your saved route uses %s today.", strings.Join(disrupted, ", ")))
+		switch {
+		case len(today) > 0:
+		case saved != nil:
+			reasons = append(reasons, "No alternative could be planned right now: confirm your saved route if you still want to take it, or try again shortly.")
+		default:
+			reasons = append(reasons, "No alternative could be planned right now, try again shortly.")
+		}
+	}
+	if saved != nil && len(today) > 0 {
+		decision := internal.Recalculation.Decide(*saved, today[0])
+		if decision.Switch {
+			reasons = append(reasons, fmt.Sprintf("Your saved route scores %.1f today against %.1f for the best alternative.", decision.CurrentScore, decision.BestScore))
+		} else {
+			reply.Reason = decision.Reason
 		}
+	}
+	if len(reasons) == 0 {
+		return false
+	}
+
+	options := today
+	if saved != nil {
+		rank := sort.Search(len(today), func(i int) bool { return today[i].Score > saved.Score })
+		options = append(append(append([]internal.RankedJourney(nil), today[:rank]...), *saved), today[rank:]...)
+		reply.SavedRank = rank + 1
+	}
+	reply.Reason = strings.Join(reasons, " ")
+	if len(options) == 0 {
+		return true
+	}
+	internal.PendingRoutes.Put(userID, options)
+
+	for i, option := range options {
+		alternative := internal.ConvertToRouteAlternative(i+1, option)
+		annotateLineStatus(alternative.Legs)
+		reply.Alternatives = append(reply.Alternatives, alternative)
+	}
+	reply.Score = options[0].Score
+	reply.Fare = options[0].Fare.Pounds()
+	reply.Breakdown = options[0].Breakdown
+	reply.Summary = buildSummary(&options[0].Journey)
+	return true
+}
+
+func notifyNewRoute(user string, journey *common.TFLJourney) error {
+
+	var newRoute common.ActiveRoute
 
+	// The journey comes from TfL, so an unknown line is reported but does not stop the route.
+	lineIDs, err := internal.JourneyLineIDs(*journey)
+	if err != nil {
+		log.Printf("Validation error in the route of user %s, its unknown lines will not be alerted on: %v", user, err)
 	}
 	newRoute.UserID = user
-	newRoute.LineIDs = lines
+	newRoute.LineIDs = lineIDs
 	data, _ := json.Marshal(newRoute)
 
 	if err := internal.RoutePublisher.Publish(routeCreated, data, amqp.Table{"Event-Type": "Route Created"}); err != nil {
@@ -263,35 +1041,26 @@ func buildSummary(journey *common.TFLJourney) string {
 	return strings.Join(summary, " → ")
 }
 
-func handleCrowding(journey common.TFLJourney, day string) float64 {
+func handleCrowding(passes []internal.StopPass, profiles map[internal.CrowdingKey]*common.CrowdingResp) float64 {
 	var totalCrowding float64
 	var stopCount int
 
-	dayOfWeek := findDayOfWeek(day)
-	for _, leg := range journey.Legs {
-		timeBand, err := internal.TimeStringToTfLTimeBand(leg.ArrivalTime)
-		if err != nil {
-			log.Printf("Invalid arrival time %s: %v", leg.ArrivalTime, err)
+	for _, pass := range passes {
+		crowd, ok := profiles[internal.CrowdingKey{Naptan: pass.Naptan, Weekday: findDayOfWeek(pass.At.Format("20060102"))}]
+		if !ok {
 			continue
 		}
-		for _, stop := range leg.Path.StopPoints {
-			crowd, erro := internal.FetchCrowding(stop.ID, dayOfWeek)
-			if erro != nil {
-				log.Printf("Could not fetch crowding for stop %s: %v", stop.ID, err)
-				continue
-			}
-			for _, tb := range crowd.TimeBands {
-				if timeBand == tb.TimeBand {
-					totalCrowding += tb.PercentageOfBaseLine
-					stopCount++
-					break
-				}
+		timeBand := internal.TimeToTfLTimeBand(pass.At)
+		for _, tb := range crowd.TimeBands {
+			if timeBand == tb.TimeBand {
+				totalCrowding += tb.PercentageOfBaseLine
+				stopCount++
+				break
 			}
 		}
 	}
 
-	score := internal.GetScore(totalCrowding, stopCount, journey.Duration)
-	return score
+	return internal.AverageCrowding(totalCrowding, stopCount)
 }
 
 func findDayOfWeek(day string) string {
@@ -303,7 +1072,23 @@ func findDayOfWeek(day string) string {
 	return strings.ToUpper(shortDay)
 }
 
-func loadNapTanFile(filename string) (map[string]string, error) {
+func loadNapTanFile(filename string) (*internal.StationResolver, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return internal.NewStationResolver(records), nil
+}
+
+func loadTopologyFile(filename string, stations *internal.StationResolver) (*internal.Router, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -316,19 +1101,223 @@ func loadNapTanFile(filename string) (map[string]string, error) {
 		return nil, err
 	}
 
-	for i, record := range records {
-		if i == 0 {
+	return internal.NewRouter(records, stations.Name)
+}
+
+// annotateLineStatus shows the live status of each leg's line, including disruptions only
+// known from recent delay events.
+func annotateLineStatus(legs []common.RouteLeg) {
+	for i := range legs {
+		if legs[i].LineID == "" {
 			continue
 		}
-		if len(record) < 5 {
-			continue
+		status, ok := internal.LineStatus.Status(legs[i].LineID)
+		if internal.Disruptions.Disruption(legs[i].LineID) > internal.LineStatus.Disruption(legs[i].LineID) {
+			status, ok = "Delays reported", true
+		}
+		if ok {
+			legs[i].Status = status
+		}
+	}
+}
+
+// watchLineStatus polls TfL for the status of every line used for planning.
+func watchLineStatus(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		statuses, err := internal.TfL.LineStatus(internal.LineStatusModes...)
+		if err != nil {
+			log.Printf("Failed to fetch line status: %v", err)
+		} else {
+			internal.LineStatus.Update(*statuses)
+			internal.Lines.Learn(*statuses)
+			var disrupted []string
+			for _, line := range *statuses {
+				if internal.LineStatus.Disruption(line.ID) > 0 {
+					disrupted = append(disrupted, line.Name)
+				}
+			}
+			log.Printf("Line status updated for %d lines, disrupted: %s", len(*statuses), strings.Join(disrupted, ", "))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncReliability keeps the reliability table in step with the traffic service, which
+// recomputes it from the line status history.
+func syncReliability(ctx context.Context, addr string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var client *rpc.Client
+	for {
+		var err error
+		if client == nil {
+			client, err = rpc.Dial("tcp", addr)
+		}
+		if err == nil {
+			var report common.ReliabilityReport
+			if err = client.Call("TrafficService.GetReliability", &common.ReliabilityQuery{}, &report); err == nil {
+				internal.Reliability.Update(report)
+				log.Printf("Line reliability updated: %d time bands computed at %s", len(report.Bands), report.GeneratedAt.Format(time.RFC3339))
+			} else if _, remote := err.(rpc.ServerError); !remote {
+				// The connection is gone: dial again next time.
+				client.Close()
+				client = nil
+			}
+		}
+		if err != nil {
+			log.Printf("Could not sync line reliability from %s: %v", addr, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if client != nil {
+				client.Close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// userServicePreferences fetches the preferences a user saved with the user service, dialing it
+// again whenever the connection is lost.
+func userServicePreferences(addr string) func(userID string) (common.UserPreferences, error) {
+	var mu sync.Mutex
+	var client *rpc.Client
+	return func(userID string) (common.UserPreferences, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var err error
+		if client == nil {
+			if client, err = rpc.Dial("tcp", addr); err != nil {
+				return common.UserPreferences{}, err
+			}
+		}
+		var prefs common.UserPreferences
+		err = client.Call("UserService.GetPreferences", &common.NewRequest{UserID: userID}, &prefs)
+		if _, remote := err.(rpc.ServerError); err != nil && !remote {
+			// The connection is gone: dial again next time.
+			client.Close()
+			client = nil
+		}
+		return prefs, err
+	}
+}
+
+func loadFareFiles(faresFile, zonesFile string) (*internal.FareEngine, error) {
+	var records [2][][]string
+	for i, filename := range []string{faresFile, zonesFile} {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		records[i], err = csv.NewReader(file).ReadAll()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+
+	return internal.NewFareEngine(records[0], records[1])
+}
+
+func loadAccessibilityFile(filename string) (*internal.AccessibilityIndex, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return internal.NewAccessibilityIndex(records)
+}
+
+// watchLiftOutages polls TfL for stop point disruptions, keeps the lift outages used by
+// accessibility routing up to date and warns step-free riders whose active route is affected.
+func watchLiftOutages(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		points, err := internal.TfL.StopDisruptions(liftOutageModes...)
+		if err != nil {
+			log.Printf("Failed to fetch stop point disruptions: %v", err)
+		} else {
+			outages := make(map[string]string)
+			for _, point := range points {
+				if !internal.IsLiftOutage(point) {
+					continue
+				}
+				naptan := point.StationAtcoCode
+				if naptan == "" {
+					naptan = point.AtcoCode
+				}
+				outages[internal.NormalizeStopID(naptan)] = point.Description
+			}
+			started := internal.Accessibility.SetOutages(outages)
+			log.Printf("Lift outages: %d stations, %d new", len(outages), len(started))
+			if len(started) > 0 {
+				alertLiftOutages(ctx, started, outages)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-		commonName := record[1]
-		naptanCode := record[4]
-		internal.NaptanMap[commonName] = naptanCode
 	}
+}
+
+// alertLiftOutages notifies riders who need lifts when a new outage hits their active route.
+// Only the riders whose route uses one of the stations have their preferences looked up.
+func alertLiftOutages(ctx context.Context, started []string, outages map[string]string) {
+	routes, err := internal.ListActiveRoutes(ctx)
+	if err != nil {
+		log.Printf("Failed to list active routes for lift outage alerts: %v", err)
+		return
+	}
+	for _, route := range routes {
+		var affected []string
+		for _, naptan := range started {
+			if internal.RouteUsesStation(route, naptan) {
+				affected = append(affected, naptan)
+			}
+		}
+		if len(affected) == 0 {
+			continue
+		}
 
-	return internal.NaptanMap, nil
+		prefs, err := internal.Preferences.Get(route.UserID)
+		if err != nil {
+			log.Printf("Checking lift outages with the preferences known so far: %v", err)
+		}
+		if prefs.Access.Profile != common.AccessStepFree && prefs.Access.Profile != common.AccessNoStairs {
+			continue
+		}
+		for _, naptan := range affected {
+			name := naptan
+			if access, ok := internal.Accessibility.Lookup(naptan); ok {
+				name = access.Name
+			}
+			internal.NotifyUser(route.UserID, fmt.Sprintf("🛗 Lift out of service at %s, on your route:\n%s\nRecalculate your route to avoid it.", name, outages[naptan]))
+		}
+	}
 }
 
 func main() {
@@ -337,12 +1326,48 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	cfg, err := config.LoadDefaultConfig(ctx)
-	failOnError(err, "Failed to load AWS config")
-	internal.DBClient = dynamodb.NewFromConfig(cfg)
-	log.Println("DynamoDB client initialized.")
+	storeCfg := store.ConfigFromEnv()
+	var err error
+	internal.Store, err = store.Open(ctx, storeCfg)
+	failOnError(err, "Failed to open route store")
+	defer internal.Store.Close()
+	log.Printf("Route store initialized (%s).", storeCfg.Backend)
+
+	internal.TfL, err = tfl.NewFromEnv()
+	failOnError(err, "Failed to configure TfL client")
+
+	weights := internal.DefaultWeights()
+	overrides, err := internal.ParseWeights(os.Getenv("SCORING_WEIGHTS"))
+	failOnError(err, "Failed to parse SCORING_WEIGHTS")
+	for name, w := range overrides {
+		weights[name] = w
+	}
+	internal.DefaultScorer, err = internal.NewScorer(internal.DefaultCriteria(internal.WorstOf{internal.Disruptions, internal.LineStatus}, internal.Reliability), weights)
+	failOnError(err, "Failed to configure route scoring")
+	log.Printf("Route scoring weights: %s", strings.Join(internal.DefaultScorer.Weights(), ", "))
+
+	crowdingTTL := 6 * time.Hour
+	if v := os.Getenv("CROWDING_CACHE_TTL"); v != "" {
+		crowdingTTL, err = time.ParseDuration(v)
+		failOnError(err, "Failed to parse CROWDING_CACHE_TTL")
+	}
+	crowdingWorkers := 8
+	if v := os.Getenv("CROWDING_WORKERS"); v != "" {
+		crowdingWorkers, err = strconv.Atoi(v)
+		failOnError(err, "Failed to parse CROWDING_WORKERS")
+	}
+	internal.Crowding = internal.NewCrowdingCache(crowdingTTL, crowdingWorkers, internal.TfL.Crowding)
 
-	internal.TflAPIKey = os.Getenv("TFL_API_KEY")
+	internal.Recalculation = internal.RecalculationPolicy{MinImprovement: 0.1, MinMinutes: 3}
+	if v := os.Getenv("RECALC_MIN_IMPROVEMENT"); v != "" {
+		internal.Recalculation.MinImprovement, err = strconv.ParseFloat(v, 64)
+		failOnError(err, "Failed to parse RECALC_MIN_IMPROVEMENT")
+	}
+	if v := os.Getenv("RECALC_MIN_MINUTES"); v != "" {
+		internal.Recalculation.MinMinutes, err = strconv.ParseFloat(v, 64)
+		failOnError(err, "Failed to parse RECALC_MIN_MINUTES")
+	}
+	log.Printf("Recalculation threshold: %.0f%% and %.1f minutes", internal.Recalculation.MinImprovement*100, internal.Recalculation.MinMinutes)
 
 	routePlanner := new(RoutePlanner)
 	server := rpc.NewServer()
@@ -359,9 +1384,30 @@ func main() {
 	failOnError(err, "Failed to listen to TCP port")
 	log.Printf("Listening on 0.0.0.0:%s", port)
 
-	_, err = loadNapTanFile("stationCodes.csv")
+	internal.Stations, err = loadNapTanFile("stationCodes.csv")
 	failOnError(err, "Failed to load stationCodes.csv")
 
+	internal.Lines, err = lines.Open("lines.csv", internal.TfL)
+	failOnError(err, "Failed to load lines.csv")
+	log.Printf("Line registry loaded with %d lines.", internal.Lines.Len())
+
+	internal.Fallback, err = loadTopologyFile("stationTopology.csv", internal.Stations)
+	if err != nil {
+		log.Printf("Offline router disabled, could not load stationTopology.csv: %v", err)
+	}
+
+	internal.Accessibility, err = loadAccessibilityFile("stationAccessibility.csv")
+	if err != nil {
+		log.Printf("Station accessibility metadata unavailable, could not load stationAccessibility.csv: %v", err)
+	}
+
+	internal.Fares, err = loadFareFiles("fares.csv", "stationZones.csv")
+	if err != nil {
+		log.Printf("Fare estimation disabled, could not load fares.csv and stationZones.csv: %v", err)
+	} else {
+		internal.Fares.SetLedgerStore(internal.Store)
+	}
+
 	conn, ch, err := rabbitmq.InitRabbitMQ(routeOutboundNotifications, routeExchangeType)
 	failOnError(err, "Failed to connect to RabbitMQ")
 
@@ -389,9 +1435,12 @@ func main() {
 			log.Printf("[Route Planner Service] Failed to unmarshal New Request: %v", err2)
 			return false
 		}
+		for _, line := range payload.Lines {
+			internal.Disruptions.Report(line, internal.CriticalDisruption)
+		}
 		route, err3 := sharedRecalculationLogic(ctx, payload.UserID)
 		if err3 != nil {
-			if strings.Contains(err3.Error(), "no active route found") {
+			if errors.Is(err3, store.ErrNotFound) {
 				log.Printf("[Route Planner Service] No active route for user %s, skipping recalculation.", payload.UserID)
 				return true // ACK the message so it won't be requeued
 			} else {
@@ -399,24 +1448,20 @@ func main() {
 				return false // only nack/requeue for actual retryable errors
 			}
 		}
-		currentStop, er := internal.EstimateCurrentStop(*route)
+		selected, decision, er := replan(route)
 		if er != nil {
-			log.Printf("[Route Planner Service] Failed to estimate Current Stop: %v", er)
-			return false
-		}
-		log.Printf("Estimated current stop: %s", currentStop)
-		currentStopNorm := internal.NormalizeStopID(currentStop)
-		log.Printf("Lookup stop: raw=%s normalized=%s", currentStop, currentStopNorm)
-		if len(route.Legs) == 0 {
-			log.Println("No legs in route")
+			log.Printf("[Route Planner Service] Failed to recalculate route: %v", er)
 			return false
 		}
-		lastLeg := route.Legs[len(route.Legs)-1]
-		endPoint := lastLeg.ToID
-		bestJourney, _, err2 := findBestJourney(currentStopNorm, endPoint, time.Now())
-		if err2 != nil {
-			log.Printf("Failed to find best journey: %v", err2)
-			return false
+
+		if !decision.Switch {
+			// The notification service dropped the route's subscriptions with the alert: restore them.
+			if okNotif := notifyNewRoute(payload.UserID, &selected.Journey); okNotif != nil {
+				log.Printf("[Route Planner Service] Failed to notify route: %v", okNotif)
+				return false
+			}
+			internal.NotifyUser(payload.UserID, "⚠️ Critical delay reported on your route.\n"+decision.Reason)
+			return true
 		}
 
 		if err = internal.DeleteChosenRoute(ctx, route.UserID); err != nil {
@@ -424,24 +1469,87 @@ func main() {
 			return false
 		}
 
-		newRoute := internal.ConvertToChosenRoute(route.UserID, *bestJourney)
+		newRoute := selected.ChosenRoute(route.UserID)
+		internal.ContinueJourney(*route, &newRoute, decision.Reason, payload.Lines, time.Now())
+		internal.CheckIns.Forget(route.UserID)
 
 		if err2 = internal.SaveChosenRoute(ctx, newRoute); err2 != nil {
 			log.Printf("[Route Planner Service] Failed to save new route: %v", err2)
 			return false
 		}
 
-		if okNotif := notifyNewRoute(payload.UserID, bestJourney); okNotif != nil {
+		if okNotif := notifyNewRoute(payload.UserID, &selected.Journey); okNotif != nil {
 			log.Printf("[Route Planner Service] Failed to notify route: %v", okNotif)
 			return false
 		}
 		log.Println("New active route notification published successfully.")
 
-		internal.NotifyUser(payload.UserID, "🛑 Critical delay! Route recalculated:\n"+buildSummary(bestJourney))
+		internal.NotifyUser(payload.UserID, "🛑 Critical delay! Route recalculated:\n"+buildSummary(&selected.Journey)+"\n"+decision.Reason)
 
 		return true
 	}
 
+	if addr := os.Getenv("USER_SERVICE_ADDR"); addr != "" {
+		preferencesMaxAge := 15 * time.Minute
+		if v := os.Getenv("PREFERENCES_MAX_AGE"); v != "" {
+			preferencesMaxAge, err = time.ParseDuration(v)
+			failOnError(err, "Failed to parse PREFERENCES_MAX_AGE")
+		}
+		internal.Preferences.SetLoader(userServicePreferences(addr), preferencesMaxAge)
+	} else {
+		log.Println("USER_SERVICE_ADDR not set, recalculations only know the preferences of riders who planned since startup.")
+	}
+
+	if addr := os.Getenv("TRAFFIC_SERVICE_ADDR"); addr != "" {
+		reliabilityInterval := 15 * time.Minute
+		if v := os.Getenv("RELIABILITY_SYNC_INTERVAL"); v != "" {
+			reliabilityInterval, err = time.ParseDuration(v)
+			failOnError(err, "Failed to parse RELIABILITY_SYNC_INTERVAL")
+		}
+		go syncReliability(ctx, addr, reliabilityInterval)
+	} else {
+		log.Println("TRAFFIC_SERVICE_ADDR not set, routes are scored without historical reliability.")
+	}
+
+	statusInterval := 2 * time.Minute
+	if v := os.Getenv("LINE_STATUS_INTERVAL"); v != "" {
+		statusInterval, err = time.ParseDuration(v)
+		failOnError(err, "Failed to parse LINE_STATUS_INTERVAL")
+	}
+	if statusInterval > 0 {
+		go watchLineStatus(ctx, statusInterval)
+	}
+
+	internal.Scheduling = internal.SchedulePolicy{Lead: 30 * time.Minute, Recheck: 5 * time.Minute, MinShift: 3 * time.Minute}
+	for env, setting := range map[string]*time.Duration{
+		"SCHEDULE_LEAD":      &internal.Scheduling.Lead,
+		"SCHEDULE_RECHECK":   &internal.Scheduling.Recheck,
+		"SCHEDULE_MIN_SHIFT": &internal.Scheduling.MinShift,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*setting, err = time.ParseDuration(v)
+			failOnError(err, "Failed to parse "+env)
+		}
+	}
+	if scheduled, errLoad := internal.LoadScheduledJourneys(ctx); errLoad != nil {
+		log.Printf("Could not load scheduled journeys: %v", errLoad)
+	} else {
+		for _, journey := range scheduled {
+			internal.Schedules.Put(journey)
+		}
+		log.Printf("Monitoring %d scheduled journeys, final re-plan %s before leaving.", len(scheduled), internal.Scheduling.Lead)
+	}
+	go watchScheduledJourneys(ctx)
+
+	liftInterval := 5 * time.Minute
+	if v := os.Getenv("LIFT_POLL_INTERVAL"); v != "" {
+		liftInterval, err = time.ParseDuration(v)
+		failOnError(err, "Failed to parse LIFT_POLL_INTERVAL")
+	}
+	if internal.Accessibility != nil && liftInterval > 0 {
+		go watchLiftOutages(ctx, liftInterval)
+	}
+
 	routeConsumer := rabbitmq.NewConsumer(ch, notificationQueueName, notifHandler)
 	wg.Add(1)
 	go func() {
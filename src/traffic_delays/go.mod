@@ -8,6 +8,7 @@ require (
 	github.com/matteoavallone7/optimaLDN/src/common v0.0.0
 	github.com/matteoavallone7/optimaLDN/src/rabbitmq v0.0.0
 	github.com/rabbitmq/amqp091-go v1.10.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 replace github.com/matteoavallone7/optimaLDN/src/common => ../common
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/matteoavallone7/optimaLDN/src/common"
+	"log"
 )
 
 func ExecuteAndProcessQuery(ctx context.Context, fluxQuery string, alertType string) ([]common.TfLAlert, error) {
@@ -19,6 +20,9 @@ func ExecuteAndProcessQuery(ctx context.Context, fluxQuery string, alertType str
 			Timestamp: record.Time(),
 		}
 
+		if v, ok := record.ValueByKey("line_id").(string); ok {
+			alert.LineID = v
+		}
 		if v, ok := record.ValueByKey("line_name").(string); ok {
 			alert.LineName = v
 		}
@@ -26,20 +30,18 @@ func ExecuteAndProcessQuery(ctx context.Context, fluxQuery string, alertType str
 			alert.ModeName = v
 		}
 
-		switch alertType {
-		case "Critical Delay":
-			if v, ok := record.ValueByKey("status_severity_description").(string); ok {
-				alert.StatusDescription = v
-			}
-			if v, ok := record.ValueByKey("reason").(string); ok {
-				alert.Reason = v
-			}
-		case "Sudden Service Worsening":
-			if v, ok := record.ValueByKey("_value").(float64); ok {
-				alert.SeverityDrop = v
-			}
+		// Each query keeps the columns that describe its alerts, so only those are set.
+		if v, ok := record.ValueByKey("status_severity_description").(string); ok {
+			alert.StatusDescription = v
+		}
+		if v, ok := record.ValueByKey("reason").(string); ok {
+			alert.Reason = v
+		}
+		if v, ok := record.ValueByKey("_value").(float64); ok {
+			alert.SeverityDrop = v
 		}
 
+		alert.LineID = canonicalLineID(alert, alertType)
 		alerts = append(alerts, alert)
 	}
 
@@ -49,3 +51,23 @@ func ExecuteAndProcessQuery(ctx context.Context, fluxQuery string, alertType str
 
 	return alerts, nil
 }
+
+// EvaluateRule runs the query of a rule and returns the alerts of the lines it matches.
+func EvaluateRule(ctx context.Context, rule Rule, bucket string) ([]common.TfLAlert, error) {
+	return ExecuteAndProcessQuery(ctx, rule.FluxQuery(bucket), rule.Name)
+}
+
+// canonicalLineID resolves the line of an alert, by ID or else by name. Unknown lines are
+// reported and sent without an ID.
+func canonicalLineID(alert common.TfLAlert, alertType string) string {
+	ref := alert.LineID
+	if ref == "" {
+		ref = alert.LineName
+	}
+	line, err := Lines.Resolve(ref)
+	if err != nil {
+		log.Printf("Validation error in %s alert: %v", alertType, err)
+		return ""
+	}
+	return line.ID
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reliabilityPrior is the number of pseudo-samples at the line's overall disruption rate added to
+// every band, so that a band seen only a couple of times does not jump to 0% or 100%.
+const reliabilityPrior = 4
+
+type bandKey struct {
+	line, weekday, band string
+}
+
+type bandCount struct {
+	disrupted, samples int
+}
+
+// ComputeReliability reads the line status history since a given time and returns, for every
+// line, weekday and 15 minute time band, how often the line was disrupted.
+func ComputeReliability(ctx context.Context, bucket string, since time.Time) (common.ReliabilityReport, error) {
+	query := fmt.Sprintf(`
+		from(bucket: "%s")
+		  |> range(start: time(v: "%s"))
+		  |> filter(fn: (r) => r._measurement == "tfl_line_status" and r._field == "is_disrupted")
+		  |> toFloat()
+		  |> group(columns: ["line_id"])
+		  |> aggregateWindow(every: 15m, fn: max, createEmpty: false, timeSrc: "_start")
+		  |> keep(columns: ["_time", "line_id", "_value"])
+	`, bucket, since.UTC().Format(time.RFC3339))
+
+	result, err := InfluxQueryAPI.Query(ctx, query)
+	if err != nil {
+		return common.ReliabilityReport{}, fmt.Errorf("error executing reliability query: %w", err)
+	}
+
+	counts := make(map[bandKey]*bandCount)
+	lines := make(map[string]*bandCount)
+	for result.Next() {
+		record := result.Record()
+		line, ok := record.ValueByKey("line_id").(string)
+		if !ok {
+			continue
+		}
+		value, ok := record.Value().(float64)
+		if !ok {
+			continue
+		}
+
+		at := record.Time().In(tfl.London)
+		key := bandKey{line: line, weekday: Weekday(at), band: TimeBand(at)}
+		if counts[key] == nil {
+			counts[key] = &bandCount{}
+		}
+		if lines[line] == nil {
+			lines[line] = &bandCount{}
+		}
+		for _, c := range []*bandCount{counts[key], lines[line]} {
+			c.samples++
+			if value > 0 {
+				c.disrupted++
+			}
+		}
+	}
+	if result.Err() != nil {
+		return common.ReliabilityReport{}, fmt.Errorf("error during reliability query result iteration: %w", result.Err())
+	}
+
+	report := common.ReliabilityReport{GeneratedAt: time.Now(), Since: since}
+	for key, c := range counts {
+		overall := lines[key.line]
+		prior := float64(overall.disrupted) / float64(overall.samples)
+		report.Bands = append(report.Bands, common.LineReliability{
+			LineID:      key.line,
+			Weekday:     key.weekday,
+			TimeBand:    key.band,
+			Probability: (float64(c.disrupted) + reliabilityPrior*prior) / float64(c.samples+reliabilityPrior),
+			Samples:     c.samples,
+		})
+	}
+	sort.Slice(report.Bands, func(i, j int) bool {
+		a, b := report.Bands[i], report.Bands[j]
+		if a.LineID != b.LineID {
+			return a.LineID < b.LineID
+		}
+		if a.Weekday != b.Weekday {
+			return a.Weekday < b.Weekday
+		}
+		return a.TimeBand < b.TimeBand
+	})
+	return report, nil
+}
+
+// Weekday returns the TfL short weekday name of a time, e.g. "MON".
+func Weekday(t time.Time) string {
+	return strings.ToUpper(t.Weekday().String()[:3])
+}
+
+// TimeBand returns the 15 minute band a time falls in, e.g. "08:30-08:45".
+func TimeBand(t time.Time) string {
+	start := t.Truncate(time.Minute).Add(-time.Duration(t.Minute()%15) * time.Minute)
+	end := start.Add(15 * time.Minute)
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", start.Hour(), start.Minute(), end.Hour(), end.Minute())
+}
+
+// ReliabilityStore holds the latest reliability report served to other services.
+type ReliabilityStore struct {
+	mu     sync.RWMutex
+	report common.ReliabilityReport
+}
+
+func (s *ReliabilityStore) Set(report common.ReliabilityReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report = report
+}
+
+// Get returns the report, restricted to some lines if any are given.
+func (s *ReliabilityStore) Get(lineIDs []string) common.ReliabilityReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(lineIDs) == 0 {
+		return s.report
+	}
+	wanted := make(map[string]bool, len(lineIDs))
+	for _, id := range lineIDs {
+		wanted[strings.ToLower(id)] = true
+	}
+	report := common.ReliabilityReport{GeneratedAt: s.report.GeneratedAt, Since: s.report.Since}
+	for _, band := range s.report.Bands {
+		if wanted[band.LineID] {
+			report.Bands = append(report.Bands, band)
+		}
+	}
+	return report
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Predicates a rule can test the line statuses with, instead of a query of its own.
+const (
+	PredicateStatus        = "status"         // latest status is one of Statuses
+	PredicateSeverityBelow = "severity_below" // latest status severity is at most Threshold
+	PredicateSeverityDrop  = "severity_drop"  // severity fell by more than Threshold
+)
+
+// Alert severities. The notification service handles an alert type it does not know by its severity.
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// Rule is one delay detection: which lines match, and the alert published for them.
+type Rule struct {
+	Name string `yaml:"name"`
+	// Either a predicate over the tfl_line_status measurement, or a Flux query returning one
+	// row per line, with its _value read as the severity drop. The query may use {{bucket}},
+	// {{window}} and {{threshold}}.
+	Predicate  string        `yaml:"predicate"`
+	Query      string        `yaml:"query"`
+	Statuses   []string      `yaml:"statuses"`
+	Window     time.Duration `yaml:"window"`
+	Threshold  float64       `yaml:"threshold"`
+	Severity   string        `yaml:"severity"`
+	AlertType  string        `yaml:"alertType"`
+	RoutingKey string        `yaml:"routingKey"`
+	Disabled   bool          `yaml:"disabled"`
+}
+
+type ruleFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// ParseRules reads and checks a rule file. JSON is accepted as well, being valid YAML.
+func ParseRules(data []byte) ([]Rule, error) {
+	var file ruleFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var rules []Rule
+	for i, rule := range file.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i+1, rule.Name, err)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("rule %d: duplicate name '%s'", i+1, rule.Name)
+		}
+		seen[rule.Name] = true
+		if !rule.Disabled {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (r Rule) validate() error {
+	switch {
+	case r.Name == "":
+		return fmt.Errorf("missing name")
+	case r.AlertType == "":
+		return fmt.Errorf("missing alertType")
+	case r.RoutingKey == "":
+		return fmt.Errorf("missing routingKey")
+	case r.Window <= 0:
+		return fmt.Errorf("window must be a positive duration, e.g. 15m")
+	case (r.Predicate == "") == (r.Query == ""):
+		return fmt.Errorf("set either predicate or query")
+	}
+
+	switch r.Severity {
+	case SeverityCritical, SeverityWarning, SeverityInfo:
+	default:
+		return fmt.Errorf("unknown severity '%s': use %s, %s or %s", r.Severity, SeverityCritical, SeverityWarning, SeverityInfo)
+	}
+
+	switch r.Predicate {
+	case "":
+	case PredicateStatus:
+		if len(r.Statuses) == 0 {
+			return fmt.Errorf("predicate %s needs statuses", r.Predicate)
+		}
+	case PredicateSeverityBelow, PredicateSeverityDrop:
+		if r.Threshold <= 0 {
+			return fmt.Errorf("predicate %s needs a positive threshold", r.Predicate)
+		}
+	default:
+		return fmt.Errorf("unknown predicate '%s': use %s, %s or %s", r.Predicate, PredicateStatus, PredicateSeverityBelow, PredicateSeverityDrop)
+	}
+	return nil
+}
+
+// FluxQuery returns the query of a rule against a bucket. Every predicate keeps the line columns
+// and the fields ExecuteAndProcessQuery reads.
+func (r Rule) FluxQuery(bucket string) string {
+	window := r.Window.String()
+	threshold := strconv.FormatFloat(r.Threshold, 'f', -1, 64)
+	if !strings.Contains(threshold, ".") {
+		threshold += ".0" // Flux does not compare a float _value with an integer
+	}
+
+	switch r.Predicate {
+	case PredicateStatus:
+		matches := make([]string, len(r.Statuses))
+		for i, status := range r.Statuses {
+			matches[i] = "r.status_severity_description == " + strconv.Quote(status)
+		}
+		return fmt.Sprintf(`
+		from(bucket: "%s")
+		  |> range(start: -%s)
+		  |> filter(fn: (r) => r._measurement == "tfl_line_status")
+		  |> filter(fn: (r) => %s)
+		  |> group(columns: ["line_id", "line_name", "mode_name"])
+		  |> last()
+		  |> keep(columns: ["_time", "line_id", "line_name", "mode_name", "status_severity_description", "reason"])
+	`, bucket, window, strings.Join(matches, " or "))
+	case PredicateSeverityBelow:
+		return fmt.Sprintf(`
+		from(bucket: "%s")
+		  |> range(start: -%s)
+		  |> filter(fn: (r) => r._measurement == "tfl_line_status" and r._field == "status_severity")
+		  |> group(columns: ["line_id", "line_name", "mode_name"])
+		  |> last()
+		  |> filter(fn: (r) => r._value <= %s)
+		  |> keep(columns: ["_time", "line_id", "line_name", "mode_name"])
+	`, bucket, window, threshold)
+	case PredicateSeverityDrop:
+		return fmt.Sprintf(`
+		from(bucket: "%s")
+		  |> range(start: -%s)
+		  |> filter(fn: (r) => r._measurement == "tfl_line_status" and r._field == "status_severity")
+		  |> group(columns: ["line_id", "line_name", "mode_name"])
+		  |> sort(columns: ["_time"])
+		  |> difference(columns: ["_value"])
+		  |> filter(fn: (r) => r._value < -%s)
+		  |> keep(columns: ["_time", "line_id", "line_name", "mode_name", "_value"])
+	`, bucket, window, threshold)
+	}
+	return strings.NewReplacer("{{bucket}}", bucket, "{{window}}", window, "{{threshold}}", threshold).Replace(r.Query)
+}
+
+// RuleBook holds the rules of a file and reloads them when the file changes. A file that no
+// longer parses is reported and the rules loaded before it are kept.
+type RuleBook struct {
+	path    string
+	mu      sync.RWMutex
+	rules   []Rule
+	modTime time.Time
+}
+
+// NewRuleBook loads the rules of a file, which must be valid at start-up.
+func NewRuleBook(path string) (*RuleBook, error) {
+	b := &RuleBook{path: path}
+	if _, err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload reads the file again if it changed since the last load, and reports whether it did.
+func (b *RuleBook) Reload() (bool, error) {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	b.mu.RLock()
+	unchanged := info.ModTime().Equal(b.modTime)
+	b.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read rules: %w", err)
+	}
+	rules, err := ParseRules(data)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// A broken file is not read again until it changes.
+	b.modTime = info.ModTime()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", b.path, err)
+	}
+	b.rules = rules
+	return true, nil
+}
+
+// Rules returns the rules in force.
+func (b *RuleBook) Rules() []Rule {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.rules
+}
+
+// Path returns the file the rules are loaded from.
+func (b *RuleBook) Path() string {
+	return b.path
+}
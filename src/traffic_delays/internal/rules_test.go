@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRules = `rules:
+  - name: critical-delays
+    predicate: status
+    statuses: ["Severe Delays", "Closed"]
+    window: 15m
+    severity: critical
+    alertType: CriticalDelay
+    routingKey: traffic.route.update.critical
+`
+
+const testRulesChanged = testRules + `
+  - name: sudden-worsening
+    predicate: severity_drop
+    threshold: 3
+    window: 30m
+    severity: warning
+    alertType: SuddenServiceWorsening
+    routingKey: traffic.route.update.sudden
+`
+
+// writeRules writes a rule file with a modification time of its own, as Reload compares them.
+func writeRules(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ruleNames(rules []Rule) string {
+	names := make([]string, len(rules))
+	for i, rule := range rules {
+		names[i] = rule.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestRuleBookReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alertRules.yaml")
+	start := time.Now().Add(-time.Hour)
+	writeRules(t, path, testRules, start)
+
+	book, err := NewRuleBook(path)
+	if err != nil {
+		t.Fatalf("NewRuleBook: %v", err)
+	}
+	if got := ruleNames(book.Rules()); got != "critical-delays" {
+		t.Fatalf("rules %s", got)
+	}
+	if reloaded, err := book.Reload(); reloaded || err != nil {
+		t.Errorf("unchanged file: Reload = %v, %v", reloaded, err)
+	}
+
+	writeRules(t, path, testRulesChanged, start.Add(time.Minute))
+	if reloaded, err := book.Reload(); !reloaded || err != nil {
+		t.Fatalf("changed file: Reload = %v, %v", reloaded, err)
+	}
+	if got := ruleNames(book.Rules()); got != "critical-delays,sudden-worsening" {
+		t.Errorf("after reload, rules %s", got)
+	}
+
+	// A broken file is reported once and the rules loaded before it stay in force.
+	writeRules(t, path, strings.Replace(testRulesChanged, "threshold: 3", "threshold: 0", 1), start.Add(2*time.Minute))
+	if reloaded, err := book.Reload(); reloaded || err == nil {
+		t.Errorf("broken file: Reload = %v, %v", reloaded, err)
+	}
+	if reloaded, err := book.Reload(); reloaded || err != nil {
+		t.Errorf("broken file read again: Reload = %v, %v", reloaded, err)
+	}
+	if got := ruleNames(book.Rules()); got != "critical-delays,sudden-worsening" {
+		t.Errorf("after a broken file, rules %s", got)
+	}
+
+	writeRules(t, path, testRules, start.Add(3*time.Minute))
+	if reloaded, err := book.Reload(); !reloaded || err != nil {
+		t.Errorf("fixed file: Reload = %v, %v", reloaded, err)
+	}
+	if got := ruleNames(book.Rules()); got != "critical-delays" {
+		t.Errorf("after the fix, rules %s", got)
+	}
+}
+
+func TestNewRuleBookRejectsBrokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alertRules.yaml")
+	writeRules(t, path, "rules: [", time.Now())
+	if _, err := NewRuleBook(path); err == nil {
+		t.Error("NewRuleBook accepted a broken file")
+	}
+	if _, err := NewRuleBook(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("NewRuleBook accepted a missing file")
+	}
+}
+
+func TestRepoRules(t *testing.T) {
+	data, err := os.ReadFile("../../../alertRules.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseRules(data); err != nil {
+		t.Errorf("alertRules.yaml: %v", err)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	const common = "\n    window: 15m\n    severity: warning\n    alertType: T\n    routingKey: k\n"
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr bool
+	}{
+		{"status with statuses", `predicate: status` + "\n    statuses: [Closed]", false},
+		{"status without statuses", `predicate: status`, true},
+		{"severity_below positive", `predicate: severity_below` + "\n    threshold: 0.5", false},
+		{"severity_below zero", `predicate: severity_below` + "\n    threshold: 0", true},
+		{"severity_drop positive", `predicate: severity_drop` + "\n    threshold: 1", false},
+		{"severity_drop negative", `predicate: severity_drop` + "\n    threshold: -1", true},
+		{"unknown predicate", `predicate: severity_above` + "\n    threshold: 1", true},
+		{"query", `query: 'from(bucket: "{{bucket}}")'`, false},
+		{"predicate and query", `predicate: status` + "\n    statuses: [Closed]\n    query: 'from()'", true},
+		{"neither predicate nor query", `disabled: false`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := "rules:\n  - name: r\n    " + tt.rule + common
+			_, err := ParseRules([]byte(data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRules error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	for _, data := range []string{
+		"rules:\n  - name: r" + strings.Replace(common, "15m", "0s", 1) + "    predicate: status\n    statuses: [Closed]\n",
+		"rules:\n  - name: r" + strings.Replace(common, "warning", "minor", 1) + "    predicate: status\n    statuses: [Closed]\n",
+		"rules:\n  - name: r" + common + "    predicate: status\n    statuses: [Closed]\n  - name: r" + common + "    predicate: status\n    statuses: [Closed]\n",
+	} {
+		if _, err := ParseRules([]byte(data)); err == nil {
+			t.Errorf("ParseRules accepted:\n%s", data)
+		}
+	}
+
+	rules, err := ParseRules([]byte("rules:\n  - name: r" + common + "    predicate: status\n    statuses: [Closed]\n    disabled: true\n"))
+	if err != nil || len(rules) != 0 {
+		t.Errorf("disabled rule: ParseRules = %v, %v", rules, err)
+	}
+}
+
+func TestFluxQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want []string
+	}{
+		{"status", Rule{Predicate: PredicateStatus, Statuses: []string{"Severe Delays", "Closed"}, Window: 15 * time.Minute},
+			[]string{`range(start: -15m0s)`, `r.status_severity_description == "Severe Delays" or r.status_severity_description == "Closed"`}},
+		// A severity equal to the threshold matches.
+		{"severity_below integer threshold", Rule{Predicate: PredicateSeverityBelow, Threshold: 6, Window: time.Hour},
+			[]string{`range(start: -1h0m0s)`, `r._value <= 6.0`}},
+		{"severity_below fractional threshold", Rule{Predicate: PredicateSeverityBelow, Threshold: 6.5, Window: time.Hour},
+			[]string{`r._value <= 6.5`}},
+		// A drop equal to the threshold does not match.
+		{"severity_drop", Rule{Predicate: PredicateSeverityDrop, Threshold: 3, Window: 30 * time.Minute},
+			[]string{`range(start: -30m0s)`, `difference(columns: ["_value"])`, `r._value < -3.0`}},
+		{"query", Rule{Query: `from(bucket: "{{bucket}}") |> range(start: -{{window}}) |> filter(fn: (r) => r._value < -{{threshold}})`, Threshold: 2, Window: 10 * time.Minute},
+			[]string{`from(bucket: "tfl") |> range(start: -10m0s) |> filter(fn: (r) => r._value < -2.0)`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.rule.FluxQuery("tfl")
+			if !strings.Contains(query, `"tfl"`) {
+				t.Errorf("query does not read the bucket:\n%s", query)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(query, want) {
+					t.Errorf("query lacks %s:\n%s", want, query)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,11 @@ package internal
 
 import (
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/matteoavallone7/optimaLDN/src/common/lines"
 )
 
-var InfluxQueryAPI api.QueryAPI
+var (
+	InfluxQueryAPI api.QueryAPI
+	Lines          *lines.Registry
+	Reliability    = new(ReliabilityStore)
+)
@@ -6,12 +6,17 @@ import (
 	"fmt"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/matteoavallone7/optimaLDN/src/common"
+	"github.com/matteoavallone7/optimaLDN/src/common/lines"
+	"github.com/matteoavallone7/optimaLDN/src/common/tfl"
 	"github.com/matteoavallone7/optimaLDN/src/rabbitmq"
 	"github.com/matteoavallone7/optimaLDN/src/traffic_delays/internal"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
+	"net"
+	"net/rpc"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -28,100 +33,71 @@ var influOrg string
 var influDBToken string
 var influClient influxdb2.Client
 
+type TrafficService struct{}
+
+// GetReliability serves the historical disruption probability of lines by weekday and time band.
+func (t *TrafficService) GetReliability(args *common.ReliabilityQuery, reply *common.ReliabilityReport) error {
+	*reply = internal.Reliability.Get(args.LineIDs)
+	if reply.GeneratedAt.IsZero() {
+		return fmt.Errorf("line reliability has not been computed yet")
+	}
+	return nil
+}
+
 func failOnError(err error, msg string) {
 	if err != nil {
 		log.Fatalf("%s: %s", msg, err)
 	}
 }
 
-func startDelayMonitor(ctx context.Context, newPublisher *rabbitmq.Publisher) {
+// startDelayMonitor evaluates the alert rules every minute and publishes the alerts of each
+// rule that matches. The rule file is reloaded whenever it changes.
+func startDelayMonitor(ctx context.Context, newPublisher *rabbitmq.Publisher, rules *internal.RuleBook) {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			log.Println("Running delay detection...")
-			criticalDelaysQuery := fmt.Sprintf(`
-		from(bucket: "%s")
-		  |> range(start: -15m)
-		  |> filter(fn: (r) => r._measurement == "tfl_line_status")
-          |> filter(fn: (r) =>
-            r.status_severity_description == "Severe Delays" or
-            r.status_severity_description == "Part Suspended" or
-            r.status_severity_description == "Closed"
-          )
-          |> group(columns: ["line_name", "mode_name"]) 
-          |> last() // Get the latest status for each line that matches the criteria
-		  |> keep(columns: ["_time", "line_name", "mode_name", "status_severity_description", "reason"])
-	`, influBucket)
-
-			criticalDelayMessages, err := internal.ExecuteAndProcessQuery(ctx, criticalDelaysQuery, "Critical Delay")
-			if err != nil {
-				failOnError(err, "Error processing critical delays")
+			if reloaded, err := rules.Reload(); err != nil {
+				log.Printf("Keeping the current alert rules, could not reload them: %v", err)
+			} else if reloaded {
+				log.Printf("Alert rules reloaded from %s: %d rule(s).", rules.Path(), len(rules.Rules()))
 			}
 
-			suddenDropQuery := fmt.Sprintf(`
-		from(bucket: "%s")
-		  |> range(start: -30m)
-		  |> filter(fn: (r) => r._measurement == "tfl_line_status" and r._field == "status_severity")
-		  |> group(columns: ["line_name", "mode_name"])
-		  |> sort(columns: ["_time"])
-		  |> difference(columns: ["_value"])
-		  |> filter(fn: (r) => r._value < -3.0) // Threshold: A drop of more than 3 points in severity
-		  |> keep(columns: ["_time", "line_name", "mode_name", "_value"])
-	`, influBucket)
-
-			suddenDropMessages, err := internal.ExecuteAndProcessQuery(ctx, suddenDropQuery, "Sudden Service Worsening")
-			if err != nil {
-				failOnError(err, "Error processing sudden severity drops")
-			}
-
-			// --- Send Notifications to RabbitMQ based on type ---
-
-			// Send Critical Delay messages if any
-			if len(criticalDelayMessages) > 0 {
-				payload := common.NotificationPayload{
-					AlertType:   "CriticalDelay",
-					Alerts:      criticalDelayMessages,
-					GeneratedAt: time.Now(),
+			log.Println("Running delay detection...")
+			matched := 0
+			for _, rule := range rules.Rules() {
+				alerts, err := internal.EvaluateRule(ctx, rule, influBucket)
+				if err != nil {
+					log.Printf("Failed to evaluate alert rule %s: %v", rule.Name, err)
+					continue
 				}
-				jsonBody, marshalErr := json.Marshal(payload)
-				if marshalErr != nil {
-					log.Printf("Failed to marshal CriticalDelay payload to JSON: %v", marshalErr)
-				} else {
-					headers := amqp.Table{"Alert-Type": "CriticalDelay"}
-					publishErr := newPublisher.Publish("traffic.route.update.critical", jsonBody, headers)
-					if publishErr != nil {
-						log.Printf("Failed to publish Critical Delays RabbitMQ message: %v", publishErr)
-					} else {
-						log.Println("Critical Delays notification sent via RabbitMQ.")
-					}
+				if len(alerts) == 0 {
+					continue
 				}
-			}
+				matched++
 
-			// Send Sudden Service Worsening messages if any
-			if len(suddenDropMessages) > 0 {
 				payload := common.NotificationPayload{
-					AlertType:   "SuddenServiceWorsening",
-					Alerts:      suddenDropMessages,
+					AlertType:   rule.AlertType,
+					Severity:    rule.Severity,
+					Alerts:      alerts,
 					GeneratedAt: time.Now(),
 				}
 				data, marshalErr := json.Marshal(payload)
 				if marshalErr != nil {
-					log.Printf("Failed to marshal SuddenServiceWorsening payload to JSON: %v", marshalErr)
+					log.Printf("Failed to marshal %s payload to JSON: %v", rule.AlertType, marshalErr)
+					continue
+				}
+				headers := amqp.Table{"Alert-Type": rule.AlertType, "Severity": rule.Severity}
+				if publishErr := newPublisher.Publish(rule.RoutingKey, data, headers); publishErr != nil {
+					log.Printf("Failed to publish %s RabbitMQ message: %v", rule.AlertType, publishErr)
 				} else {
-					headers := amqp.Table{"Alert-Type": "SuddenServiceWorsening"}
-					publishErr := newPublisher.Publish("traffic.route.update.sudden", data, headers)
-					if publishErr != nil {
-						log.Printf("Failed to publish Sudden Service Worsening RabbitMQ message: %v", publishErr)
-					} else {
-						log.Println("Sudden Service Worsening notification sent via RabbitMQ.")
-					}
+					log.Printf("%s notification for %d line(s) sent via RabbitMQ (rule %s).", rule.AlertType, len(alerts), rule.Name)
 				}
 			}
 
-			if len(criticalDelayMessages) == 0 && len(suddenDropMessages) == 0 {
+			if matched == 0 {
 				log.Println("No significant TfL anomalies or negative trends detected at this time. All clear.")
 			}
 
@@ -132,6 +108,31 @@ func startDelayMonitor(ctx context.Context, newPublisher *rabbitmq.Publisher) {
 	}
 }
 
+// startReliabilityRefresher recomputes line reliability from the status history at start-up and
+// then at every interval.
+func startReliabilityRefresher(ctx context.Context, interval time.Duration, lookbackDays int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		since := time.Now().AddDate(0, 0, -lookbackDays)
+		report, err := internal.ComputeReliability(ctx, influBucket, since)
+		if err != nil {
+			log.Printf("Failed to compute line reliability: %v", err)
+		} else {
+			internal.Reliability.Set(report)
+			log.Printf("Line reliability computed from %d days of history: %d time bands.", lookbackDays, len(report.Bands))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Println("Reliability refresher stopping due to context cancel.")
+			return
+		}
+	}
+}
+
 func main() {
 	fmt.Println("Starting Traffic_delays service...")
 
@@ -151,6 +152,20 @@ func main() {
 	}
 	defer influClient.Close()
 
+	tflClient, err := tfl.NewFromEnv()
+	failOnError(err, "Failed to configure TfL client")
+	internal.Lines, err = lines.Open("lines.csv", tflClient)
+	failOnError(err, "Failed to load lines.csv")
+	log.Printf("Line registry loaded with %d lines.", internal.Lines.Len())
+
+	rulesPath := os.Getenv("ALERT_RULES")
+	if rulesPath == "" {
+		rulesPath = "alertRules.yaml"
+	}
+	rules, err := internal.NewRuleBook(rulesPath)
+	failOnError(err, "Failed to load alert rules")
+	log.Printf("Alert rules loaded from %s: %d rule(s).", rulesPath, len(rules.Rules()))
+
 	fmt.Println("Setting up RabbitMQ...")
 	conn, ch, err := rabbitmq.InitRabbitMQ(traffic_exchange, exchange_type)
 	failOnError(err, "Failed to initialize RabbitMQ for Notification Service's outbound events")
@@ -163,6 +178,30 @@ func main() {
 
 	trafficPublisher := rabbitmq.NewPublisher(ch, traffic_exchange)
 
+	server := rpc.NewServer()
+	err = server.Register(new(TrafficService))
+	failOnError(err, "Failed to register Traffic Service")
+
+	port := os.Getenv("TRAFFIC_SERVICE_PORT")
+	if port == "" {
+		log.Fatal("TRAFFIC_SERVICE_PORT env variable not set")
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", port))
+	failOnError(err, "Failed to listen to TCP port")
+	log.Printf("Listening on 0.0.0.0:%s", port)
+	go server.Accept(listener)
+
+	reliabilityRefresh := time.Hour
+	if v := os.Getenv("RELIABILITY_REFRESH"); v != "" {
+		reliabilityRefresh, err = time.ParseDuration(v)
+		failOnError(err, "Failed to parse RELIABILITY_REFRESH")
+	}
+	lookbackDays := 56
+	if v := os.Getenv("RELIABILITY_LOOKBACK_DAYS"); v != "" {
+		lookbackDays, err = strconv.Atoi(v)
+		failOnError(err, "Failed to parse RELIABILITY_LOOKBACK_DAYS")
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -171,10 +210,14 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	wg.Add(1)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		startDelayMonitor(ctx, trafficPublisher, rules)
+	}()
 	go func() {
 		defer wg.Done()
-		startDelayMonitor(ctx, trafficPublisher)
+		startReliabilityRefresher(ctx, reliabilityRefresh, lookbackDays)
 	}()
 
 	<-sigChan
@@ -18,6 +18,12 @@ func ConvertToUserSavedRoute(userID string, chosen common.ChosenRoute) common.Us
 		stopsNames = append(stopsNames, leg.Stops...)
 	}
 
+	legs := make([]common.RouteLeg, len(chosen.Legs))
+	for i, leg := range chosen.Legs {
+		leg.Status = ""
+		legs[i] = leg
+	}
+
 	return common.UserSavedRoute{
 		RouteID:       uuid.New().String(),
 		UserID:        userID,
@@ -28,6 +34,8 @@ func ConvertToUserSavedRoute(userID string, chosen common.ChosenRoute) common.Us
 		EstimatedTime: chosen.TotalDuration,
 		LineNames:     lineNames,
 		StopsNames:    stopsNames,
+		Legs:          legs,
+		Waypoints:     chosen.Waypoints,
 	}
 }
 
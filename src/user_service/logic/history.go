@@ -0,0 +1,32 @@
+package logic
+
+import (
+	"time"
+)
+
+const week = 7 * 24 * time.Hour
+
+// StatsSince returns where a stats period of some weeks starts, or the zero time for the whole history.
+func StatsSince(weeks int, now time.Time) time.Time {
+	if weeks <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(weeks) * week)
+}
+
+// TripsPerWeek averages a number of journeys over the period they were made in. The period runs
+// from its start, or from the first journey for the whole history, and counts as at least a week.
+func TripsPerWeek(journeys int, since, first, now time.Time) float64 {
+	if journeys == 0 {
+		return 0
+	}
+	start := since
+	if start.IsZero() {
+		start = first
+	}
+	weeks := now.Sub(start).Hours() / week.Hours()
+	if weeks < 1 {
+		weeks = 1
+	}
+	return float64(journeys) / weeks
+}
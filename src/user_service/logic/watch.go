@@ -0,0 +1,49 @@
+package logic
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]bool{"MON": true, "TUE": true, "WED": true, "THU": true, "FRI": true, "SAT": true, "SUN": true}
+
+// PrepareCommuteWatch validates a new commute watch, normalises its weekdays and gives it an ID.
+func PrepareCommuteWatch(watch *common.CommuteWatch) error {
+	if watch.UserID == "" || watch.RouteID == "" {
+		return fmt.Errorf("a commute watch needs a user and a saved route")
+	}
+	if len(watch.Weekdays) == 0 {
+		return fmt.Errorf("a commute watch needs at least one weekday")
+	}
+	seen := make(map[string]bool)
+	var days []string
+	for _, day := range watch.Weekdays {
+		day = strings.ToUpper(strings.TrimSpace(day))
+		if len(day) > 3 {
+			day = day[:3]
+		}
+		if !weekdays[day] {
+			return fmt.Errorf("unknown weekday '%s'", day)
+		}
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	watch.Weekdays = days
+
+	start, errStart := time.Parse("15:04", watch.Start)
+	end, errEnd := time.Parse("15:04", watch.End)
+	if errStart != nil || errEnd != nil {
+		return fmt.Errorf("start and end must be times like 08:00")
+	}
+	if start.Equal(end) {
+		return fmt.Errorf("a commute window cannot be empty")
+	}
+
+	watch.WatchID = uuid.New().String()
+	return nil
+}
@@ -0,0 +1,44 @@
+package logic
+
+import (
+	"github.com/matteoavallone7/optimaLDN/src/common"
+	"slices"
+	"testing"
+)
+
+func TestPrepareCommuteWatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		watch        common.CommuteWatch
+		wantWeekdays []string
+		wantErr      bool
+	}{
+		{"weekdays normalised", common.CommuteWatch{UserID: "alice", RouteID: "r", Weekdays: []string{" monday", "Tue", "MON"}, Start: "07:30", End: "09:00"}, []string{"MON", "TUE"}, false},
+		{"spanning midnight", common.CommuteWatch{UserID: "alice", RouteID: "r", Weekdays: []string{"FRI"}, Start: "23:00", End: "01:00"}, []string{"FRI"}, false},
+		{"no user", common.CommuteWatch{RouteID: "r", Weekdays: []string{"MON"}, Start: "07:30", End: "09:00"}, nil, true},
+		{"no route", common.CommuteWatch{UserID: "alice", Weekdays: []string{"MON"}, Start: "07:30", End: "09:00"}, nil, true},
+		{"no weekday", common.CommuteWatch{UserID: "alice", RouteID: "r", Start: "07:30", End: "09:00"}, nil, true},
+		{"unknown weekday", common.CommuteWatch{UserID: "alice", RouteID: "r", Weekdays: []string{"Mo"}, Start: "07:30", End: "09:00"}, nil, true},
+		{"invalid time", common.CommuteWatch{UserID: "alice", RouteID: "r", Weekdays: []string{"MON"}, Start: "7:30am", End: "09:00"}, nil, true},
+		{"hour out of range", common.CommuteWatch{UserID: "alice", RouteID: "r", Weekdays: []string{"MON"}, Start: "24:00", End: "09:00"}, nil, true},
+		{"empty window", common.CommuteWatch{UserID: "alice", RouteID: "r", Weekdays: []string{"MON"}, Start: "08:00", End: "08:00"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watch := tt.watch
+			err := PrepareCommuteWatch(&watch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PrepareCommuteWatch error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !slices.Equal(watch.Weekdays, tt.wantWeekdays) {
+				t.Errorf("weekdays %v, want %v", watch.Weekdays, tt.wantWeekdays)
+			}
+			if watch.WatchID == "" {
+				t.Error("no watch ID")
+			}
+		})
+	}
+}
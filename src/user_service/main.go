@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/matteoavallone7/optimaLDN/src/common"
 	"github.com/matteoavallone7/optimaLDN/src/rabbitmq"
@@ -31,6 +33,10 @@ const (
 	notificationOutboundExchangeName = "notification_outbound_events_exchange"
 	notificationsQueue               = "notifications_user_queue"
 	bindingKey                       = "user.update.#"
+	routeExchange                    = "route_planner_exchange"
+	historyQueue                     = "journey_history_queue"
+	historyBindingKey                = "journey.archived"
+	topLines                         = 5
 )
 
 var db *pgxpool.Pool
@@ -70,12 +76,18 @@ func saveRouteToPostgres(ctx context.Context, db *pgxpool.Pool, route common.Use
 	_, err := db.Exec(ctx, `
         INSERT INTO user_saved_routes 
         (route_id, user_id, start_point, end_point, transport_mode, 
-         stops, estimated_time, line_names, stops_names)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-        ON CONFLICT (user_id, start_point, end_point, transport_mode) DO NOTHING
+         stops, estimated_time, line_names, stops_names, legs, waypoints)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+        ON CONFLICT (user_id, start_point, end_point, transport_mode) DO UPDATE SET
+            stops = EXCLUDED.stops,
+            estimated_time = EXCLUDED.estimated_time,
+            line_names = EXCLUDED.line_names,
+            stops_names = EXCLUDED.stops_names,
+            legs = EXCLUDED.legs,
+            waypoints = EXCLUDED.waypoints
     `, route.RouteID, route.UserID, route.StartPoint, route.EndPoint,
 		route.TransportMode, route.Stops, route.EstimatedTime,
-		route.LineNames, route.StopsNames)
+		route.LineNames, route.StopsNames, nonNil(route.Legs), nonNil(route.Waypoints))
 
 	if err != nil {
 		return fmt.Errorf("failed to insert favorite route: %w", err)
@@ -89,7 +101,7 @@ func (u *UserService) GetUserSavedRoutes(args *common.NewRequest, reply *[]commo
 
 	rows, err := db.Query(ctx, `
         SELECT route_id, user_id, start_point, end_point, transport_mode, 
-               stops, estimated_time, line_names, stops_names
+               stops, estimated_time, line_names, stops_names, legs, waypoints
         FROM  user_saved_routes
         WHERE user_id = $1`, args.UserID)
 	if err != nil {
@@ -110,6 +122,8 @@ func (u *UserService) GetUserSavedRoutes(args *common.NewRequest, reply *[]commo
 			&route.EstimatedTime,
 			&route.LineNames,
 			&route.StopsNames,
+			&route.Legs,
+			&route.Waypoints,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to scan row: %w", err)
@@ -143,19 +157,22 @@ func (u *UserService) SaveFavoriteRoute(args *common.NewRequest, reply *common.S
 	return nil
 }
 
-func (u *UserService) CallAcceptSavedRoute(savedRoute common.UserSavedRoute, reply *common.SavedResp) error {
+func (u *UserService) CallAcceptSavedRoute(savedRoute common.UserSavedRoute, reply *common.RouteResult) error {
 
-	err := routePlannerClient.Call("RoutePlanner.AcceptSavedRouteRequest", &savedRoute, &reply)
+	err := routePlannerClient.Call("RoutePlanner.AcceptSavedRouteRequest", &savedRoute, reply)
 	if err != nil {
 		return fmt.Errorf("AcceptSavedRoute RPC call failed: %w", err)
 	}
 
-	reply.Status = common.StatusDone
 	return nil
 }
 
 func (u *UserService) GetSavedRouteByID(req *common.RouteLookup, reply *common.UserSavedRoute) error {
-	query := `SELECT * FROM user_saved_routes WHERE user_id=$1 AND route_id=$2`
+	query := `
+        SELECT route_id, user_id, start_point, end_point, transport_mode,
+               stops, estimated_time, line_names, stops_names, legs, waypoints
+        FROM user_saved_routes
+        WHERE user_id=$1 AND route_id=$2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -163,7 +180,8 @@ func (u *UserService) GetSavedRouteByID(req *common.RouteLookup, reply *common.U
 
 	var saved common.UserSavedRoute
 	err := row.Scan(&saved.RouteID, &saved.UserID, &saved.StartPoint, &saved.EndPoint,
-		&saved.TransportMode, &saved.Stops, &saved.EstimatedTime, &saved.LineNames, &saved.StopsNames)
+		&saved.TransportMode, &saved.Stops, &saved.EstimatedTime, &saved.LineNames, &saved.StopsNames,
+		&saved.Legs, &saved.Waypoints)
 
 	if err != nil {
 		return fmt.Errorf("route not found: %w", err)
@@ -173,6 +191,226 @@ func (u *UserService) GetSavedRouteByID(req *common.RouteLookup, reply *common.U
 	return nil
 }
 
+func (u *UserService) GetPreferences(args *common.NewRequest, reply *common.UserPreferences) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reply.UserID = args.UserID
+	err := db.QueryRow(ctx, `
+        SELECT avoid_lines, avoid_peak_lines, avoid_modes, avoid_stations, access_profile, extra_interchange_mins
+        FROM user_preferences
+        WHERE user_id = $1`, args.UserID).Scan(
+		&reply.Avoid.Lines,
+		&reply.Avoid.PeakLines,
+		&reply.Avoid.Modes,
+		&reply.Avoid.Stations,
+		&reply.Access.Profile,
+		&reply.Access.ExtraInterchangeMins,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query preferences: %w", err)
+	}
+	return nil
+}
+
+func (u *UserService) SavePreferences(args *common.UserPreferences, reply *common.SavedResp) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	avoid := args.Avoid
+	_, err := db.Exec(ctx, `
+        INSERT INTO user_preferences (user_id, avoid_lines, avoid_peak_lines, avoid_modes, avoid_stations, access_profile, extra_interchange_mins)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (user_id) DO UPDATE SET
+            avoid_lines = EXCLUDED.avoid_lines,
+            avoid_peak_lines = EXCLUDED.avoid_peak_lines,
+            avoid_modes = EXCLUDED.avoid_modes,
+            avoid_stations = EXCLUDED.avoid_stations,
+            access_profile = EXCLUDED.access_profile,
+            extra_interchange_mins = EXCLUDED.extra_interchange_mins
+    `, args.UserID, nonNil(avoid.Lines), nonNil(avoid.PeakLines), nonNil(avoid.Modes), nonNil(avoid.Stations),
+		args.Access.Profile, args.Access.ExtraInterchangeMins)
+	if err != nil {
+		return fmt.Errorf("failed to save preferences: %w", err)
+	}
+
+	reply.UserID = args.UserID
+	reply.Status = common.StatusDone
+	return nil
+}
+
+func (u *UserService) SaveCommuteWatch(args *common.CommuteWatch, reply *common.CommuteWatch) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch := *args
+	if err := logic.PrepareCommuteWatch(&watch); err != nil {
+		return err
+	}
+
+	// Only the owner of the saved route may watch it.
+	tag, err := db.Exec(ctx, `
+        INSERT INTO commute_watches (watch_id, user_id, route_id, weekdays, start_time, end_time)
+        SELECT $1, $2, route_id, $4, $5::time, $6::time
+        FROM user_saved_routes
+        WHERE user_id = $2 AND route_id = $3
+    `, watch.WatchID, watch.UserID, watch.RouteID, watch.Weekdays, watch.Start, watch.End)
+	if err != nil {
+		return fmt.Errorf("failed to save commute watch: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("saved route %s not found for user %s", watch.RouteID, watch.UserID)
+	}
+
+	*reply = watch
+	return nil
+}
+
+// GetCommuteWatches returns a user's commute watches, or everyone's when no user is given,
+// together with the stations and lines of the routes they watch.
+func (u *UserService) GetCommuteWatches(args *common.NewRequest, reply *[]common.CommuteWatch) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+        SELECT w.watch_id, w.user_id, w.route_id, w.weekdays,
+               to_char(w.start_time, 'HH24:MI'), to_char(w.end_time, 'HH24:MI'),
+               r.start_point, r.end_point, r.line_names
+        FROM commute_watches w
+        JOIN user_saved_routes r ON r.route_id = w.route_id
+        WHERE $1 = '' OR w.user_id = $1
+        ORDER BY w.start_time`, args.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to query commute watches: %w", err)
+	}
+	defer rows.Close()
+
+	var watches []common.CommuteWatch
+	for rows.Next() {
+		var watch common.CommuteWatch
+		err = rows.Scan(&watch.WatchID, &watch.UserID, &watch.RouteID, &watch.Weekdays,
+			&watch.Start, &watch.End, &watch.StartPoint, &watch.EndPoint, &watch.Lines)
+		if err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		watches = append(watches, watch)
+	}
+
+	*reply = watches
+	return nil
+}
+
+func (u *UserService) DeleteCommuteWatch(args *common.WatchLookup, reply *common.SavedResp) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag, err := db.Exec(ctx, `DELETE FROM commute_watches WHERE user_id = $1 AND watch_id = $2`, args.UserID, args.WatchID)
+	if err != nil {
+		return fmt.Errorf("failed to delete commute watch: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("commute watch %s not found for user %s", args.WatchID, args.UserID)
+	}
+
+	reply.UserID = args.UserID
+	reply.Status = common.StatusDone
+	return nil
+}
+
+// archiveJourney stores a finished journey in the user's history. Archiving the same journey
+// twice keeps the first record.
+func archiveJourney(ctx context.Context, record common.JourneyRecord) error {
+	var plannedArrival *time.Time
+	if !record.PlannedArrival.IsZero() {
+		plannedArrival = &record.PlannedArrival
+	}
+	_, err := db.Exec(ctx, `
+        INSERT INTO journey_history
+        (journey_id, user_id, outcome, reason, start_point, end_point, started_at, ended_at,
+         planned_arrival, delay_mins, time_lost_mins, line_names, legs, recalculations)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+        ON CONFLICT (journey_id) DO NOTHING
+    `, record.JourneyID, record.UserID, record.Outcome, record.Reason, record.From, record.To,
+		record.StartedAt, record.EndedAt, plannedArrival, record.DelayMins, record.TimeLostMins,
+		nonNil(record.Lines), nonNil(record.Legs), nonNil(record.Recalculations))
+	if err != nil {
+		return fmt.Errorf("failed to archive journey: %w", err)
+	}
+	return nil
+}
+
+func (u *UserService) GetTravelStats(args *common.StatsQuery, reply *common.TravelStats) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	since := logic.StatsSince(args.Weeks, now)
+	stats := common.TravelStats{UserID: args.UserID, Since: since}
+
+	var first *time.Time
+	err := db.QueryRow(ctx, `
+        SELECT count(*),
+               count(*) FILTER (WHERE outcome = 'completed'),
+               count(*) FILTER (WHERE outcome = 'aborted'),
+               count(*) FILTER (WHERE jsonb_array_length(recalculations) > 0),
+               coalesce(avg(delay_mins), 0),
+               coalesce(sum(time_lost_mins), 0),
+               min(started_at)
+        FROM journey_history
+        WHERE user_id = $1 AND started_at >= $2`, args.UserID, since).Scan(
+		&stats.Journeys,
+		&stats.Completed,
+		&stats.Aborted,
+		&stats.Recalculated,
+		&stats.AvgDelayMins,
+		&stats.TimeLostMins,
+		&first,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query journey history: %w", err)
+	}
+	if first != nil {
+		if since.IsZero() {
+			stats.Since = *first
+		}
+		stats.TripsPerWeek = logic.TripsPerWeek(stats.Journeys, since, *first, now)
+	}
+
+	rows, err := db.Query(ctx, `
+        SELECT line, count(*)
+        FROM journey_history, unnest(line_names) AS line
+        WHERE user_id = $1 AND started_at >= $2
+        GROUP BY line
+        ORDER BY count(*) DESC, line
+        LIMIT $3`, args.UserID, since, topLines)
+	if err != nil {
+		return fmt.Errorf("failed to query line usage: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var usage common.LineUsage
+		if err = rows.Scan(&usage.Line, &usage.Trips); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		stats.TopLines = append(stats.TopLines, usage)
+	}
+
+	*reply = stats
+	return nil
+}
+
+// nonNil keeps NOT NULL array and JSON columns happy when a list is empty.
+func nonNil[T any](values []T) []T {
+	if values == nil {
+		return []T{}
+	}
+	return values
+}
+
 func main() {
 	fmt.Println("Starting User Service...")
 
@@ -246,6 +484,30 @@ func main() {
 		routeConsumer.StartConsume(ctx)
 	}()
 
+	_, err = rabbitmq.DeclareAndBindQueue(ch, historyQueue, historyBindingKey, routeExchange)
+	failOnError(err, fmt.Sprintf("Failed to declare and bind queue '%s' for Route Planner Service", historyQueue))
+
+	historyHandler := func(delivery amqp.Delivery) bool {
+		var record common.JourneyRecord
+		if err2 := json.Unmarshal(delivery.Body, &record); err2 != nil {
+			log.Printf("[User Service] Failed to unmarshal journey record: %v", err2)
+			return true // a malformed record will never be stored: drop it
+		}
+		if err2 := archiveJourney(ctx, record); err2 != nil {
+			log.Printf("[User Service] %v", err2)
+			return false
+		}
+		log.Printf("[User Service] Archived %s journey %s of user %s.", record.Outcome, record.JourneyID, record.UserID)
+		return true
+	}
+
+	historyConsumer := rabbitmq.NewConsumer(ch, historyQueue, historyHandler)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		historyConsumer.StartConsume(ctx)
+	}()
+
 	go func() {
 		for {
 			server.Accept(listener)